@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "Generate shell completion scripts",
+	Long: `Generate a completion script for bash, zsh or fish.
+
+Completions include package names, read from the local package database:
+available packages for install and info, installed packages for remove
+and upgrade.
+
+Bash:
+  mix completion bash > /usr/share/bash-completion/completions/mix
+
+Zsh (with compinit enabled):
+  mix completion zsh > "${fpath[1]}/_mix"
+
+Fish:
+  mix completion fish > ~/.config/fish/completions/mix.fish
+
+Start a new shell for the completions to take effect.`,
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletionV2(os.Stdout, true)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		default:
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+	rootCmd.CompletionOptions.DisableDefaultCmd = true
+}
+
+// completePackages suggests packages whose names start with the word being
+// completed, leaving out those already on the command line. It reads the
+// database directly, without the lock or migrations, so completion stays
+// fast while another mix is running; any failure just means no
+// suggestions.
+func completePackages(installedOnly bool) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if _, err := os.Stat(dbPath); err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		db, err := manager.OpenDatabase(dbPath)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		defer db.Close()
+
+		names, err := db.PackageNames(toComplete, installedOnly)
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return withoutArgs(names, args), cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completePackage suggests one package name, available or installed.
+func completePackage(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completePackages(false)(cmd, args, toComplete)
+}
+
+// completeInstall suggests available packages, or .mixpkg files once the
+// word looks like a path.
+func completeInstall(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if strings.ContainsRune(toComplete, os.PathSeparator) || strings.HasPrefix(toComplete, ".") || strings.HasPrefix(toComplete, "~") {
+		return []string{"mixpkg"}, cobra.ShellCompDirectiveFilterFileExt
+	}
+	return completePackages(false)(cmd, args, toComplete)
+}
+
+// completeVisoFile suggests .viso files for commands taking one image.
+func completeVisoFile(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return []string{"viso"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// completeMixmagisk suggests subcommands, login users for grant and users
+// holding a policy for revoke.
+func completeMixmagisk(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	switch {
+	case len(args) == 0:
+		return []string{
+			"status\tShow mixmagisk status",
+			"grant\tGrant root access to a user",
+			"revoke\tRevoke root access from a user",
+			"log\tShow recent root operations",
+			"policy\tManage access policies",
+			"shell\tStart an interactive root shell",
+		}, cobra.ShellCompDirectiveNoFileComp
+	case len(args) == 1 && args[0] == "grant":
+		granted := policyUsers()
+		var users []string
+		for _, user := range loginUsers() {
+			if !contains(granted, user) {
+				users = append(users, user)
+			}
+		}
+		return users, cobra.ShellCompDirectiveNoFileComp
+	case len(args) == 1 && args[0] == "revoke":
+		return policyUsers(), cobra.ShellCompDirectiveNoFileComp
+	case args[0] == "grant" || args[0] == "revoke" || args[0] == "status" || args[0] == "log":
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+// loginUsers returns the users in /etc/passwd that have a login shell.
+func loginUsers() []string {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var users []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		shell := filepath.Base(fields[6])
+		if shell == "nologin" || shell == "false" || shell == "sync" {
+			continue
+		}
+		users = append(users, fields[0])
+	}
+	sort.Strings(users)
+	return users
+}
+
+// policyUsers returns the users that have a mixmagisk policy.
+func policyUsers() []string {
+	matches, _ := filepath.Glob(filepath.Join(mixmagiskPolicy, "*.policy"))
+	users := make([]string, 0, len(matches))
+	for _, m := range matches {
+		users = append(users, strings.TrimSuffix(filepath.Base(m), ".policy"))
+	}
+	return users
+}
+
+// withoutArgs drops the names already given as arguments.
+func withoutArgs(names, args []string) []string {
+	var result []string
+	for _, name := range names {
+		if !contains(args, name) {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+)
+
+var (
+	configPath = manager.DefaultConfigPath
+	worldPath  = manager.DefaultWorldPath
+	proxyURL   string
+	caFile     string
+	clientCert string
+	clientKey  string
+	userAgent  string
+	authToken  string
+	timeout    time.Duration
+
+	lockTimeout time.Duration
+)
+
+func init() {
+	flags := rootCmd.PersistentFlags()
+	flags.StringVar(&configPath, "config", configPath, "path to configuration file")
+	flags.StringVar(&worldPath, "world", worldPath, "path to the world file of explicitly installed packages")
+	flags.StringVar(&proxyURL, "proxy", "", "HTTP proxy URL (default from HTTP_PROXY/HTTPS_PROXY)")
+	flags.StringVar(&caFile, "ca-file", "", "additional PEM CA bundle for https repositories")
+	flags.StringVar(&clientCert, "client-cert", "", "PEM client certificate for mutual TLS")
+	flags.StringVar(&clientKey, "client-key", "", "PEM client key for mutual TLS")
+	flags.StringVar(&userAgent, "user-agent", "", "User-Agent header for repository requests")
+	flags.StringVar(&authToken, "auth-token", "", "bearer token for the repository given by --repo")
+	flags.DurationVar(&timeout, "timeout", 0, "how long a repository request may wait to connect, respond or make progress (e.g. 30s)")
+	flags.DurationVar(&lockTimeout, "lock-timeout", 0, "how long to wait for another mix process to finish (negative waits forever)")
+}
+
+// loadConfig reads the configuration file and applies command line
+// overrides. It also picks up the configured repository when --repo was
+// not given.
+func loadConfig() (*manager.Config, error) {
+	cfg, err := manager.LoadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := rootCmd.PersistentFlags()
+	if !flags.Changed("repo") && cfg.Repo != "" {
+		repoURL = cfg.Repo
+	}
+
+	if flags.Changed("proxy") {
+		cfg.HTTP.Proxy = proxyURL
+	}
+	if flags.Changed("ca-file") {
+		cfg.HTTP.CAFile = caFile
+	}
+	if flags.Changed("client-cert") {
+		cfg.HTTP.ClientCert = clientCert
+	}
+	if flags.Changed("client-key") {
+		cfg.HTTP.ClientKey = clientKey
+	}
+	if flags.Changed("timeout") {
+		cfg.HTTP.Timeout = manager.Duration(timeout)
+	}
+	if flags.Changed("user-agent") {
+		cfg.HTTP.UserAgent = userAgent
+	}
+	if cfg.HTTP.UserAgent == "" {
+		cfg.HTTP.UserAgent = "mix/" + version
+	}
+	if flags.Changed("auth-token") {
+		if cfg.HTTP.Repos == nil {
+			cfg.HTTP.Repos = make(map[string]manager.RepoConfig)
+		}
+		repo := cfg.HTTP.Repos[repoURL]
+		repo.Token, repo.TokenFile = authToken, ""
+		cfg.HTTP.Repos[repoURL] = repo
+	}
+
+	return cfg, nil
+}
+
+// newHTTPClient builds the repository HTTP client from configuration and
+// flags.
+func newHTTPClient() (*http.Client, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return httpClientFor(cfg)
+}
+
+func httpClientFor(cfg *manager.Config) (*http.Client, error) {
+	client, err := manager.NewHTTPClient(cfg.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP configuration: %w", err)
+	}
+	return client, nil
+}
+
+// openManager opens the package manager with the configured repository
+// transport and architectures, holding the state lock in the given mode
+// until it is closed. Only the exclusive holder migrates the database: a
+// reader that finds migrations pending takes the exclusive lock instead.
+// Callers must Close it.
+func openManager(mode manager.LockMode) (*manager.Manager, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	client, err := httpClientFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := acquireLock(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	var mgr *manager.Manager
+	if mode == manager.SharedLock {
+		mgr, err = manager.Open(dbPath, repoURL, cacheDir)
+		if errors.Is(err, manager.ErrMigrationsPending) {
+			lock.Release()
+			if lock, err = acquireLock(manager.ExclusiveLock); err != nil {
+				return nil, err
+			}
+			mgr, err = manager.New(dbPath, repoURL, cacheDir)
+		}
+	} else {
+		mgr, err = manager.New(dbPath, repoURL, cacheDir)
+	}
+	if err != nil {
+		lock.Release()
+		return nil, fmt.Errorf("failed to initialize package manager: %w", err)
+	}
+	mgr.SetLock(lock)
+
+	if err := mgr.SetHTTPClient(client); err != nil {
+		mgr.Close()
+		return nil, err
+	}
+	mgr.SetArches(manager.Arches{Native: cfg.Arch, Foreign: cfg.ForeignArches})
+	return mgr, nil
+}
+
+// lockPath is the lock file guarding the database, the cache and the
+// install root. It lives next to the database so that every mix sharing a
+// database also shares the lock.
+func lockPath() string {
+	return filepath.Join(filepath.Dir(dbPath), "lock")
+}
+
+// acquireLock takes the state lock, waiting up to --lock-timeout and
+// telling the user who holds it while waiting.
+func acquireLock(mode manager.LockMode) (*manager.Lock, error) {
+	lock, err := manager.AcquireLock(lockPath(), mode, 0)
+	var locked *manager.LockedError
+	if lockTimeout == 0 || !errors.As(err, &locked) {
+		return lock, lockError(err)
+	}
+
+	if locked.PID > 0 {
+		fmt.Fprintf(os.Stderr, "Waiting for lock held by PID %d...\n", locked.PID)
+	} else {
+		fmt.Fprintln(os.Stderr, "Waiting for lock held by another mix process...")
+	}
+	lock, err = manager.AcquireLock(lockPath(), mode, lockTimeout)
+	return lock, lockError(err)
+}
+
+func lockError(err error) error {
+	var locked *manager.LockedError
+	if errors.As(err, &locked) {
+		return fmt.Errorf("%w; wait for it to finish or use --lock-timeout", err)
+	}
+	return err
+}
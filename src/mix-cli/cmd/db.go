@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Package database maintenance",
+	Long: `Inspect and upgrade the package database schema.
+
+Every mix command migrates the database automatically when it opens it;
+these commands make the step explicit, e.g. for images built ahead of time.`,
+}
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Args:  cobra.NoArgs,
+	RunE:  runDBMigrate,
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the schema version and database integrity",
+	Long: `Report the schema version of the database and any pending migrations,
+and run SQLite's integrity and foreign key checks. Exits non-zero when
+migrations are pending or problems are found.`,
+	Args: cobra.NoArgs,
+	RunE: runDBCheck,
+}
+
+type dbMigrateOutput struct {
+	SchemaVersion int      `json:"schema_version"`
+	Applied       []string `json:"applied"`
+}
+
+type dbCheckOutput struct {
+	SchemaVersion int      `json:"schema_version"`
+	LatestVersion int      `json:"latest_version"`
+	Pending       []string `json:"pending"`
+	Problems      []string `json:"problems"`
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbCheckCmd)
+}
+
+func runDBMigrate(cmd *cobra.Command, args []string) error {
+	lock, err := acquireLock(manager.ExclusiveLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	db, err := manager.OpenDatabase(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	applied, err := db.Migrate()
+	for _, m := range applied {
+		printVerbose("Applied migration %s\n", m)
+	}
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return writeOutput("DatabaseMigration", dbMigrateOutput{SchemaVersion: manager.SchemaVersion(), Applied: nonNil(applied)})
+	}
+
+	if len(applied) == 0 {
+		fmt.Printf("Database is up to date (schema version %d).\n", manager.SchemaVersion())
+		return nil
+	}
+	for _, m := range applied {
+		fmt.Printf("  %s\n", m)
+	}
+	fmt.Printf("Applied %d migration(s); schema version is now %d.\n", len(applied), manager.SchemaVersion())
+	return nil
+}
+
+func runDBCheck(cmd *cobra.Command, args []string) error {
+	lock, err := acquireLock(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	db, err := manager.OpenDatabase(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	status, err := db.MigrationStatus()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	problems, err := db.Check()
+	if err != nil {
+		return fmt.Errorf("failed to check database: %w", err)
+	}
+
+	healthy := status.UpToDate() && len(problems) == 0
+
+	if structuredOutput() {
+		err := writeOutput("DatabaseCheck", dbCheckOutput{
+			SchemaVersion: status.Current,
+			LatestVersion: status.Latest,
+			Pending:       nonNil(status.Pending),
+			Problems:      nonNil(problems),
+		})
+		if err == nil && !healthy {
+			err = errReported
+		}
+		return err
+	}
+
+	fmt.Printf("Schema version: %d (latest %d)\n", status.Current, status.Latest)
+	if status.Current > status.Latest {
+		fmt.Println("⚠️  Database was written by a newer version of mix")
+	}
+	for _, m := range status.Pending {
+		fmt.Printf("  pending: %s\n", m)
+	}
+	for _, p := range problems {
+		fmt.Printf("  problem: %s\n", p)
+	}
+
+	switch {
+	case len(problems) > 0:
+		return fmt.Errorf("database has %d problem(s)", len(problems))
+	case len(status.Pending) > 0:
+		return fmt.Errorf("%d migration(s) pending; run 'mix db migrate'", len(status.Pending))
+	case !healthy:
+		return fmt.Errorf("unsupported schema version %d", status.Current)
+	}
+	fmt.Println("✅ Database is healthy")
+	return nil
+}
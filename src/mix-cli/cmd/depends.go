@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var dependsCmd = &cobra.Command{
+	Use:   "depends [package]",
+	Short: "Show the dependencies of a package",
+	Long: `Show the packages a package depends on.
+
+With --tree, the dependencies of the dependencies are shown as well; a
+package that appears more than once is expanded the first time only.
+With --reverse, the installed packages that depend on the package are
+shown instead, like mix rdepends.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runDepends,
+	ValidArgsFunction: completePackage,
+}
+
+var rdependsCmd = &cobra.Command{
+	Use:               "rdepends [package]",
+	Short:             "Show the installed packages that depend on a package",
+	Long:              `Show the installed packages that depend on a package. Same as mix depends --reverse.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runDepends,
+	ValidArgsFunction: completePackage,
+}
+
+var whyCmd = &cobra.Command{
+	Use:   "why [package]",
+	Short: "Show why a package is installed",
+	Long: `Show why an installed package is on the system: either it was installed
+explicitly, or the shortest chain of dependencies leading to it from an
+explicitly installed package.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runWhy,
+	ValidArgsFunction: completePackages(true),
+}
+
+func init() {
+	rootCmd.AddCommand(dependsCmd)
+	rootCmd.AddCommand(rdependsCmd)
+	rootCmd.AddCommand(whyCmd)
+	for _, cmd := range []*cobra.Command{dependsCmd, rdependsCmd} {
+		cmd.Flags().BoolP("tree", "t", false, "show all dependencies as a tree")
+	}
+	dependsCmd.Flags().BoolP("reverse", "r", false, "show the installed packages that depend on the package")
+}
+
+type dependencyTreeOutput struct {
+	Reverse bool                    `json:"reverse"`
+	Tree    *manager.DependencyNode `json:"tree"`
+}
+
+func runDepends(cmd *cobra.Command, args []string) error {
+	tree, _ := cmd.Flags().GetBool("tree")
+	reverse := cmd.Name() == "rdepends"
+	if !reverse {
+		reverse, _ = cmd.Flags().GetBool("reverse")
+	}
+
+	mgr, err := openManager(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	root, err := mgr.DependencyTree(args[0], reverse)
+	if err != nil {
+		return err
+	}
+	if !tree {
+		for _, child := range root.Children {
+			child.Children, child.Repeated = nil, false
+		}
+	}
+
+	if structuredOutput() {
+		return writeOutput("DependencyTree", dependencyTreeOutput{Reverse: reverse, Tree: root})
+	}
+
+	if len(root.Children) == 0 {
+		if reverse {
+			fmt.Printf("No installed package depends on %s.\n", root.Name)
+		} else {
+			fmt.Printf("%s has no dependencies.\n", root.Name)
+		}
+		return nil
+	}
+
+	if tree {
+		fmt.Println(dependencyLabel(root))
+		printDependencyTree(root.Children, "")
+		return nil
+	}
+
+	if reverse {
+		fmt.Printf("Installed packages depending on %s:\n", root.Name)
+	} else {
+		fmt.Printf("%s depends on:\n", root.Name)
+	}
+	for _, child := range root.Children {
+		fmt.Printf("  %s\n", dependencyLabel(child))
+	}
+	return nil
+}
+
+// dependencyLabel describes a tree node: its name, version and state.
+func dependencyLabel(node *manager.DependencyNode) string {
+	label := node.Name
+	if node.Version != "" {
+		label += " " + node.Version
+	}
+	switch {
+	case node.Missing:
+		label += " (missing)"
+	case node.Installed:
+		label += " [installed]"
+	}
+	if node.Repeated {
+		label += " (see above)"
+	}
+	return label
+}
+
+func printDependencyTree(nodes []*manager.DependencyNode, indent string) {
+	for i, node := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Printf("%s%s%s\n", indent, branch, dependencyLabel(node))
+		printDependencyTree(node.Children, indent+next)
+	}
+}
+
+type whyOutput struct {
+	Package  string   `json:"package"`
+	Explicit bool     `json:"explicit"`
+	Chain    []string `json:"chain"`
+}
+
+func runWhy(cmd *cobra.Command, args []string) error {
+	mgr, err := openManager(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	name := args[0]
+	chain, err := mgr.Why(name)
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return writeOutput("Why", whyOutput{Package: name, Explicit: len(chain) == 1, Chain: nonNil(chain)})
+	}
+
+	switch len(chain) {
+	case 0:
+		fmt.Printf("%s was installed as a dependency, but no explicitly installed package needs it any more.\n", name)
+	case 1:
+		fmt.Printf("%s was installed explicitly.\n", name)
+	default:
+		fmt.Printf("%s is needed by %s:\n  %s\n", name, chain[0], strings.Join(chain, " -> "))
+	}
+	return nil
+}
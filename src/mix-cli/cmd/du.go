@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var duCmd = &cobra.Command{
+	Use:   "du [packages...]",
+	Short: "Show the disk usage of installed packages",
+	Long: `Show how much space installed packages take, largest first.
+
+For each package three sizes are shown:
+
+  SIZE       the files of the package itself
+  EXCLUSIVE  the space freed by removing the package together with the
+             dependencies nothing else needs
+  CLOSURE    the package and everything it depends on; what is not
+             exclusive is shared with other packages
+
+With --tree, the dependencies of each package (by default the explicitly
+installed ones) are shown as a tree, with shared dependencies marked.
+In VRAM mode the root filesystem lives in memory, so these sizes are
+the RAM the packages cost.`,
+	Example: `  mix du
+  mix du --exclusive
+  mix du --tree openssh`,
+	RunE:              runDu,
+	ValidArgsFunction: completePackages(true),
+}
+
+func init() {
+	rootCmd.AddCommand(duCmd)
+	duCmd.Flags().BoolP("tree", "t", false, "show the dependencies of each package as a tree")
+	duCmd.Flags().BoolP("exclusive", "e", false, "sort by exclusive size")
+}
+
+type diskUsageOutput struct {
+	Packages []*manager.PackageUsage `json:"packages"`
+	Total    int64                   `json:"total"`
+}
+
+type diskUsageTreeOutput struct {
+	Trees []*manager.UsageNode `json:"trees"`
+}
+
+func runDu(cmd *cobra.Command, args []string) error {
+	tree, _ := cmd.Flags().GetBool("tree")
+	exclusive, _ := cmd.Flags().GetBool("exclusive")
+
+	mgr, err := openManager(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	usage, err := mgr.DiskUsage()
+	if err != nil {
+		return err
+	}
+	for _, name := range args {
+		if _, ok := usage.Packages[name]; !ok {
+			return fmt.Errorf("package %s is not installed", name)
+		}
+	}
+
+	var total int64
+	for _, pkg := range usage.Packages {
+		total += pkg.Size
+	}
+	pkgs := usage.Sorted(exclusive || tree)
+	if len(args) > 0 {
+		wanted := make(map[string]bool, len(args))
+		for _, name := range args {
+			wanted[name] = true
+		}
+		selected := []*manager.PackageUsage{}
+		for _, pkg := range pkgs {
+			if wanted[pkg.Name] {
+				selected = append(selected, pkg)
+			}
+		}
+		pkgs = selected
+	}
+
+	if tree {
+		trees := []*manager.UsageNode{}
+		for _, pkg := range pkgs {
+			if len(args) > 0 || pkg.Explicit {
+				trees = append(trees, usage.Tree(pkg.Name))
+			}
+		}
+		if structuredOutput() {
+			return writeOutput("DiskUsageTree", diskUsageTreeOutput{Trees: trees})
+		}
+		if len(trees) == 0 {
+			fmt.Println("No packages installed.")
+		}
+		for i, root := range trees {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s (%s exclusive)\n", usageLabel(root), manager.FormatSize(root.Exclusive))
+			printUsageTree(root.Children, "")
+		}
+		return nil
+	}
+
+	if structuredOutput() {
+		return writeOutput("DiskUsage", diskUsageOutput{Packages: pkgs, Total: total})
+	}
+
+	if len(pkgs) == 0 {
+		fmt.Println("No packages installed.")
+		return nil
+	}
+	fmt.Printf("%10s %10s %10s  %s\n", "SIZE", "EXCLUSIVE", "CLOSURE", "PACKAGE")
+	for _, pkg := range pkgs {
+		fmt.Printf("%10s %10s %10s  %s\n", manager.FormatSize(pkg.Size), manager.FormatSize(pkg.Exclusive),
+			manager.FormatSize(pkg.Closure), pkg.Name)
+	}
+	if len(args) == 0 {
+		fmt.Printf("\nTotal: %s in %d packages\n", manager.FormatSize(total), len(pkgs))
+	}
+	return nil
+}
+
+// usageLabel describes a disk usage tree node: its name, version and
+// size.
+func usageLabel(node *manager.UsageNode) string {
+	label := fmt.Sprintf("%s %s  %s", node.Name, node.Version, manager.FormatSize(node.Size))
+	if node.Shared {
+		label += " [shared]"
+	}
+	if node.Repeated {
+		label += " (see above)"
+	}
+	return label
+}
+
+func printUsageTree(nodes []*manager.UsageNode, indent string) {
+	for i, node := range nodes {
+		branch, next := "├── ", "│   "
+		if i == len(nodes)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Printf("%s%s%s\n", indent, branch, usageLabel(node))
+		printUsageTree(node.Children, indent+next)
+	}
+}
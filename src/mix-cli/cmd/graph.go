@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [packages...]",
+	Short: "Export the dependency graph",
+	Long: `Export a dependency graph for visualization.
+
+The graph covers the named packages and everything they depend on, or
+without arguments all installed packages (with --available, every
+package in the database). The DOT format can be rendered with Graphviz:
+
+  mix graph | dot -Tsvg > packages.svg
+
+Explicitly installed packages are drawn bold, other installed packages
+filled and missing dependencies dashed.`,
+	RunE:              runGraph,
+	ValidArgsFunction: completePackages(false),
+}
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	graphCmd.Flags().StringP("format", "f", "dot", "graph format: dot or json")
+	graphCmd.Flags().BoolP("available", "a", false, "graph every available package, not only installed ones")
+}
+
+func runGraph(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	available, _ := cmd.Flags().GetBool("available")
+	if format != "dot" && format != "json" {
+		return fmt.Errorf("unknown graph format %q, want dot or json", format)
+	}
+
+	mgr, err := openManager(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	graph, err := mgr.DependencyGraph(args, available)
+	if err != nil {
+		return err
+	}
+
+	if structuredOutput() {
+		return writeOutput("DependencyGraph", graph)
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(graph)
+	}
+	return writeDOT(os.Stdout, graph)
+}
+
+// writeDOT writes graph in the Graphviz DOT language.
+func writeDOT(w io.Writer, graph *manager.DependencyGraph) error {
+	if _, err := fmt.Fprintln(w, "digraph packages {\n\trankdir=LR;\n\tnode [shape=box];"); err != nil {
+		return err
+	}
+	for _, pkg := range graph.Packages {
+		label := dotEscape(pkg.Name)
+		if pkg.Version != "" {
+			label += `\n` + dotEscape(pkg.Version)
+		}
+		attrs := `label="` + label + `"`
+		switch {
+		case pkg.Explicit:
+			attrs += `, style="bold,filled"`
+		case pkg.Installed:
+			attrs += ", style=filled"
+		case pkg.Missing:
+			attrs += ", style=dashed"
+		}
+		if _, err := fmt.Fprintf(w, "\t%s [%s];\n", dotQuote(pkg.Name), attrs); err != nil {
+			return err
+		}
+	}
+	for _, edge := range graph.Edges {
+		if _, err := fmt.Fprintf(w, "\t%s -> %s;\n", dotQuote(edge.From), dotQuote(edge.To)); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// dotEscape escapes s for use inside a quoted DOT string.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func dotQuote(s string) string {
+	return `"` + dotEscape(s) + `"`
+}
@@ -9,11 +9,12 @@ import (
 )
 
 var infoCmd = &cobra.Command{
-	Use:   "info [package]",
-	Short: "Show package information",
-	Long:  `Display detailed information about a package.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runInfo,
+	Use:               "info [package]",
+	Short:             "Show package information",
+	Long:              `Display detailed information about a package.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runInfo,
+	ValidArgsFunction: completePackage,
 }
 
 func init() {
@@ -21,13 +22,31 @@ func init() {
 	infoCmd.Flags().BoolP("files", "f", false, "list files installed by package")
 }
 
+type packageInfoOutput struct {
+	Name          string   `json:"name"`
+	Version       string   `json:"version"`
+	Description   string   `json:"description"`
+	Size          int64    `json:"size"`
+	InstalledSize int64    `json:"installed_size,omitempty"`
+	Installed     bool     `json:"installed"`
+	Origin        string   `json:"origin,omitempty"`
+	Arch          string   `json:"arch,omitempty"`
+	License       string   `json:"license,omitempty"`
+	Homepage      string   `json:"homepage,omitempty"`
+	Maintainer    string   `json:"maintainer,omitempty"`
+	BuildDate     string   `json:"build_date,omitempty"`
+	Dependencies  []string `json:"dependencies"`
+	Checksum      string   `json:"checksum,omitempty"`
+	Files         []string `json:"files,omitempty"`
+}
+
 func runInfo(cmd *cobra.Command, args []string) error {
 	showFiles, _ := cmd.Flags().GetBool("files")
 	pkgName := args[0]
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.SharedLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
@@ -36,11 +55,57 @@ func runInfo(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get package info: %w", err)
 	}
 
+	if structuredOutput() {
+		out := packageInfoOutput{
+			Name:          info.Name,
+			Version:       info.Version,
+			Description:   info.Description,
+			Size:          info.Size,
+			InstalledSize: info.InstalledSize,
+			Installed:     info.Installed,
+			Origin:        info.Origin,
+			Arch:          info.Arch,
+			License:       info.License,
+			Homepage:      info.Homepage,
+			Maintainer:    info.Maintainer,
+			BuildDate:     info.BuildDate,
+			Dependencies:  info.Dependencies,
+			Checksum:      info.Checksum,
+		}
+		if out.Dependencies == nil {
+			out.Dependencies = []string{}
+		}
+		if showFiles && info.Installed {
+			out.Files, err = mgr.GetPackageFiles(pkgName)
+			if err != nil {
+				return fmt.Errorf("failed to get package files: %w", err)
+			}
+		}
+		return writeOutput("PackageInfo", out)
+	}
+
 	fmt.Printf("Package: %s\n", info.Name)
 	fmt.Printf("Version: %s\n", info.Version)
 	fmt.Printf("Description: %s\n", info.Description)
-	fmt.Printf("Size: %s\n", formatSize(info.Size))
+	fmt.Printf("Size: %s\n", manager.FormatSize(info.Size))
+	if info.InstalledSize > 0 {
+		fmt.Printf("Installed size: %s\n", manager.FormatSize(info.InstalledSize))
+	}
 	fmt.Printf("Installed: %v\n", info.Installed)
+	if info.Origin != "" {
+		fmt.Printf("Origin: %s\n", info.Origin)
+	}
+	for _, field := range []struct{ label, value string }{
+		{"Architecture", info.Arch},
+		{"License", info.License},
+		{"Homepage", info.Homepage},
+		{"Maintainer", info.Maintainer},
+		{"Build date", info.BuildDate},
+	} {
+		if field.value != "" {
+			fmt.Printf("%s: %s\n", field.label, field.value)
+		}
+	}
 
 	if len(info.Dependencies) > 0 {
 		fmt.Printf("Dependencies: %s\n", strings.Join(info.Dependencies, ", "))
@@ -65,16 +130,3 @@ func runInfo(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
-
-func formatSize(bytes int64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%d B", bytes)
-	}
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
-	}
-	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
-}
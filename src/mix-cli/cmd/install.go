@@ -1,98 +1,110 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/mixos-go/src/mix-cli/pkg/manager"
 	"github.com/spf13/cobra"
-
-	"github.com/charmbracelet/bubbles/progress"
-	"github.com/charmbracelet/bubbles/spinner"
-	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/lipgloss"
-	"golang.org/x/term"
 )
 
 var installCmd = &cobra.Command{
 	Use:   "install [packages...]",
 	Short: "Install packages",
-	Long:  `Install one or more packages with automatic dependency resolution.`,
-	Args:  cobra.MinimumNArgs(1),
-	RunE:  runInstall,
-}
+	Long: `Install one or more packages with automatic dependency resolution.
 
-// tuiModel is a Bubble Tea model used to render install progress.
-type tuiModel struct {
-	sp   spinner.Model
-	prog progress.Model
-	msg  string
-	ch   <-chan manager.ProgressUpdate
-}
+Arguments may be package names from the repository or paths to local
+.mixpkg files. With --from-dir, every package in the directory becomes
+available for installation and dependency resolution; if no packages are
+named, all of them are installed.
 
-func (m tuiModel) Init() tea.Cmd {
-	return tea.Batch(m.sp.Tick, func() tea.Msg {
-		for u := range m.ch {
-			return u
+Packages built for an architecture other than the native one, noarch or
+a configured foreign architecture are refused unless --force-arch is
+given.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if dir, _ := cmd.Flags().GetString("from-dir"); dir == "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
 		}
 		return nil
-	})
+	},
+	RunE:              runInstall,
+	ValidArgsFunction: completeInstall,
 }
 
-func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
-	switch msg := msg.(type) {
-	case spinner.TickMsg:
-		var c tea.Cmd
-		m.sp, c = m.sp.Update(msg)
-		cmd = c
-	case progress.FrameMsg:
-		var c tea.Cmd
-		pm, c := m.prog.Update(msg)
-		if newProg, ok := pm.(progress.Model); ok {
-			m.prog = newProg
-		}
-		cmd = c
-	case manager.ProgressUpdate:
-		m.msg = msg.Message
-		if setter, ok := interface{}(&m.prog).(interface{ SetPercent(float64) }); ok {
-			setter.SetPercent(msg.Percent)
-		}
-		// schedule listening for next update
-		return m, func() tea.Msg {
-			for u := range m.ch {
-				return u
-			}
-			return nil
+func init() {
+	rootCmd.AddCommand(installCmd)
+	installCmd.Flags().BoolP("yes", "y", false, "assume yes to all prompts")
+	installCmd.Flags().Bool("no-deps", false, "skip dependency resolution")
+	installCmd.Flags().String("from-dir", "", "install from a directory of .mixpkg files")
+	installCmd.Flags().Bool("force-arch", false, "install packages built for an architecture this system does not run")
+}
+
+// isLocalPackageArg reports whether an install argument names a package
+// file rather than a repository package.
+func isLocalPackageArg(arg string) bool {
+	if strings.HasSuffix(arg, ".mixpkg") {
+		return true
+	}
+	if strings.ContainsRune(arg, os.PathSeparator) {
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			return true
 		}
-	case nil:
-		// channel closed
-		return m, tea.Quit
 	}
-	return m, cmd
+	return false
 }
 
-func (m tuiModel) View() string {
-	title := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12")).Render("Mix Installer")
-	body := lipgloss.NewStyle().Align(lipgloss.Center).Render(m.msg)
-	return title + "\n\n" + m.sp.View() + " " + m.prog.View() + "\n\n" + body
-}
+// registerLocalPackages registers --from-dir and file arguments with mgr and
+// returns the package names to install.
+func registerLocalPackages(mgr *manager.Manager, fromDir string, args []string) ([]string, error) {
+	var names []string
+	if fromDir != "" {
+		dirNames, err := mgr.AddLocalDir(fromDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", fromDir, err)
+		}
+		if len(dirNames) == 0 {
+			return nil, fmt.Errorf("no .mixpkg files found in %s", fromDir)
+		}
+		if len(args) == 0 {
+			names = append(names, dirNames...)
+		}
+	}
 
-func init() {
-	rootCmd.AddCommand(installCmd)
-	installCmd.Flags().BoolP("yes", "y", false, "assume yes to all prompts")
-	installCmd.Flags().Bool("no-deps", false, "skip dependency resolution")
+	for _, arg := range args {
+		if !isLocalPackageArg(arg) {
+			names = append(names, arg)
+			continue
+		}
+		pkg, err := mgr.AddLocalPackage(arg)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, pkg.Metadata.Name)
+	}
+
+	return names, nil
 }
 
 func runInstall(cmd *cobra.Command, args []string) error {
 	yes, _ := cmd.Flags().GetBool("yes")
 	noDeps, _ := cmd.Flags().GetBool("no-deps")
+	fromDir, _ := cmd.Flags().GetString("from-dir")
+	forceArch, _ := cmd.Flags().GetBool("force-arch")
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.ExclusiveLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
+	mgr.SetForceArch(forceArch)
+
+	args, err = registerLocalPackages(mgr, fromDir, args)
+	if err != nil {
+		return err
+	}
 
 	// Resolve dependencies
 	var toInstall []string
@@ -101,91 +113,148 @@ func runInstall(cmd *cobra.Command, args []string) error {
 	} else {
 		printVerbose("Resolving dependencies...\n")
 		toInstall, err = mgr.ResolveDependencies(args)
+		if errors.Is(err, manager.ErrArchMismatch) {
+			return fmt.Errorf("%w; use --force-arch to install it anyway", err)
+		}
 		if err != nil {
 			return fmt.Errorf("dependency resolution failed: %w", err)
 		}
 	}
 
+	install := installPackage(mgr, args)
+	space, err := mgr.PlanSpace(manager.OpInstall, toInstall)
+	if err != nil {
+		return fmt.Errorf("failed to work out the disk space needed: %w", err)
+	}
+
+	if structuredOutput() {
+		out := planOutput{Packages: toInstall, Space: space, Applied: yes}
+		if out.Packages == nil {
+			out.Packages = []string{}
+		}
+		var applyErr error
+		if yes {
+			if err := space.Check(); err != nil {
+				out.Applied = false
+				if werr := writeOutput("InstallPlan", out); werr != nil {
+					return werr
+				}
+				return err
+			}
+			if err := markExplicit(mgr, args); err != nil {
+				return err
+			}
+			stop := startEventLog(mgr)
+			out.Results, applyErr = applyStructured(toInstall, func(pkg string) error {
+				return install(cmd.Context(), pkg)
+			})
+			stop()
+		}
+		if err := writeOutput("InstallPlan", out); err != nil {
+			return err
+		}
+		return applyErr
+	}
+
 	if len(toInstall) == 0 {
 		fmt.Println("All packages are already installed.")
-		return nil
+		return markExplicit(mgr, args)
 	}
 
 	// Show what will be installed
 	fmt.Printf("The following packages will be installed:\n")
+	local := mgr.LocalPackages()
 	for _, pkg := range toInstall {
-		fmt.Printf("  %s\n", pkg)
+		if lp, ok := local[pkg]; ok {
+			fmt.Printf("  %s %s (from %s)\n", pkg, lp.Metadata.Version, lp.Path)
+		} else {
+			fmt.Printf("  %s\n", pkg)
+		}
 	}
 	fmt.Printf("\nTotal: %d package(s)\n", len(toInstall))
+	if err := printSpacePlan(space); err != nil {
+		return err
+	}
 
 	// Confirm installation
 	if !yes {
-		fmt.Print("\nProceed with installation? [y/N] ")
-		var response string
-		fmt.Scanln(&response)
-		if response != "y" && response != "Y" {
+		fmt.Println()
+		if !confirm(cmd.Context(), "Proceed with installation?") {
 			fmt.Println("Installation cancelled.")
 			return nil
 		}
 	}
+	if err := markExplicit(mgr, args); err != nil {
+		return err
+	}
 
-	// If stdout is a terminal, run a TUI installer; otherwise run headless
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		// create progress channel
-		ch := make(chan manager.ProgressUpdate)
-		errCh := make(chan error, 1)
-		mgr.SetProgressChan(ch)
+	return runTransaction(cmd, mgr, transaction{
+		op:       manager.OpInstall,
+		packages: toInstall,
+		apply:    install,
+	})
+}
 
-		// start installation in goroutine
-		go func() {
-			for _, pkg := range toInstall {
-				if err := mgr.Install(pkg); err != nil {
-					errCh <- fmt.Errorf("failed to install %s: %w", pkg, err)
-					close(ch)
-					return
-				}
+// markExplicit records the requested packages that are already installed
+// as explicitly installed and adds them to the world file, as asking for a
+// package that was pulled in as a dependency means it is wanted for its
+// own sake.
+func markExplicit(mgr *manager.Manager, requested []string) error {
+	var installed []string
+	for _, pkg := range requested {
+		if ok, _ := mgr.IsInstalled(pkg); ok {
+			if err := mgr.SetExplicit(pkg, true); err != nil {
+				return err
 			}
-			close(ch)
-			errCh <- nil
-		}()
-
-		// prepare spinner and progress and start tuiModel
-		s := spinner.New()
-		s.Spinner = spinner.Line
-		pmod := progress.New(progress.WithDefaultGradient())
-		pmod.Width = 40
-
-		model := tuiModel{sp: s, prog: pmod, msg: "Starting...", ch: ch}
-		prg := tea.NewProgram(model)
-
-		// run TUI (blocking) while installations happen in goroutine
-		if err := prg.Start(); err != nil {
-			// fallback to headless if UI fails
-			for _, pkg := range toInstall {
-				if err := mgr.Install(pkg); err != nil {
-					return fmt.Errorf("failed to install %s: %w", pkg, err)
-				}
-			}
-		}
-
-		// wait for install result
-		if err := <-errCh; err != nil {
-			return err
+			installed = append(installed, pkg)
 		}
+	}
+	return updateWorld(mgr, installed, nil)
+}
 
-		fmt.Println("\nInstallation complete!")
+// updateWorld adds and removes packages from the world file, creating it
+// if need be.
+func updateWorld(mgr *manager.Manager, add, remove []string) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	world, err := mgr.LoadWorld(worldPath)
+	if err != nil {
+		return err
+	}
+	// The first change writes out the world file
+	_, err = os.Stat(worldPath)
+	changed := os.IsNotExist(err)
+	for _, pkg := range add {
+		changed = world.Add(pkg) || changed
+	}
+	for _, pkg := range remove {
+		changed = world.Remove(pkg) || changed
+	}
+	if !changed {
 		return nil
 	}
+	if err := world.Save(); err != nil {
+		return fmt.Errorf("failed to update %s: %w", worldPath, err)
+	}
+	return nil
+}
 
-	// non-interactive install
-	for _, pkg := range toInstall {
-		fmt.Printf("Installing %s...\n", pkg)
-		if err := mgr.Install(pkg); err != nil {
-			return fmt.Errorf("failed to install %s: %w", pkg, err)
+// installPackage returns the apply function of an install transaction.
+// The requested packages are added to the world file; the others are
+// recorded as installed as dependencies, see mix why.
+func installPackage(mgr *manager.Manager, requested []string) func(ctx context.Context, pkg string) error {
+	explicit := make(map[string]bool, len(requested))
+	for _, pkg := range requested {
+		explicit[pkg] = true
+	}
+	return func(ctx context.Context, pkg string) error {
+		if err := mgr.Install(ctx, pkg); err != nil {
+			return err
+		}
+		if explicit[pkg] {
+			return updateWorld(mgr, []string{pkg}, nil)
 		}
-		fmt.Printf("  ✓ %s installed successfully\n", pkg)
+		return mgr.SetExplicit(pkg, false)
 	}
-
-	fmt.Println("\nInstallation complete!")
-	return nil
 }
@@ -19,12 +19,24 @@ func init() {
 	listCmd.Flags().BoolP("all", "a", false, "list all available packages")
 }
 
+type packageSummary struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Installed   bool   `json:"installed"`
+}
+
+type packageListOutput struct {
+	Scope    string           `json:"scope"`
+	Packages []packageSummary `json:"packages"`
+}
+
 func runList(cmd *cobra.Command, args []string) error {
 	all, _ := cmd.Flags().GetBool("all")
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.SharedLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
@@ -39,6 +51,22 @@ func runList(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to list packages: %w", err)
 	}
 
+	if structuredOutput() {
+		out := packageListOutput{Scope: "installed", Packages: []packageSummary{}}
+		if all {
+			out.Scope = "available"
+		}
+		for _, pkg := range packages {
+			out.Packages = append(out.Packages, packageSummary{
+				Name:        pkg.Name,
+				Version:     pkg.Version,
+				Description: pkg.Description,
+				Installed:   pkg.Installed,
+			})
+		}
+		return writeOutput("PackageList", out)
+	}
+
 	if len(packages) == 0 {
 		if all {
 			fmt.Println("No packages available. Run 'mix update' to refresh the package database.")
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Mirror package repositories",
+	Long:  `Mirror remote package repositories for use on networks without internet access.`,
+}
+
+var mirrorSyncCmd = &cobra.Command{
+	Use:   "sync <src-url> <dest-dir>",
+	Short: "Synchronize a local mirror with a remote repository",
+	Long: `Download the index and packages of a remote repository into a local
+directory. Packages already present with a matching checksum are skipped,
+every download is verified, and package files that are no longer in the
+index are pruned. The source may publish a v1 index.json or a v2 index;
+the mirror publishes both, and its v2 index keeps generations and diffs
+of its own between syncs.
+
+The result can be used directly with --repo file://<dest-dir> or served
+with 'mix repo serve <dest-dir>'.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runMirrorSync,
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.AddCommand(mirrorSyncCmd)
+
+	mirrorSyncCmd.Flags().StringSlice("include", nil, "only mirror packages matching these glob patterns")
+	mirrorSyncCmd.Flags().StringSlice("exclude", nil, "skip packages matching these glob patterns")
+	mirrorSyncCmd.Flags().Bool("with-deps", true, "also mirror dependencies of included packages")
+	mirrorSyncCmd.Flags().String("pubkey", "", "verify the source index with this ed25519 public key (PEM)")
+	mirrorSyncCmd.Flags().String("sign-key", "", "re-sign a filtered index with this ed25519 private key (PEM)")
+	mirrorSyncCmd.Flags().Bool("no-prune", false, "keep package files that were removed from the index")
+	mirrorSyncCmd.Flags().Bool("dry-run", false, "show what would be fetched and pruned without changing anything")
+}
+
+func runMirrorSync(cmd *cobra.Command, args []string) error {
+	src, dest := args[0], args[1]
+
+	opts := manager.MirrorOptions{}
+	opts.Include, _ = cmd.Flags().GetStringSlice("include")
+	opts.Exclude, _ = cmd.Flags().GetStringSlice("exclude")
+	opts.WithDeps, _ = cmd.Flags().GetBool("with-deps")
+	opts.DryRun, _ = cmd.Flags().GetBool("dry-run")
+	noPrune, _ := cmd.Flags().GetBool("no-prune")
+	opts.Prune = !noPrune
+
+	if path, _ := cmd.Flags().GetString("pubkey"); path != "" {
+		key, err := manager.LoadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load public key: %w", err)
+		}
+		opts.PublicKey = key
+	}
+	if path, _ := cmd.Flags().GetString("sign-key"); path != "" {
+		key, err := manager.LoadSigningKey(path)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		opts.SignKey = key
+	}
+
+	client, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
+	opts.Client = client
+
+	if !opts.DryRun {
+		fmt.Printf("Mirroring %s to %s...\n", src, dest)
+	}
+
+	plan, err := manager.MirrorRepository(cmd.Context(), src, dest, opts)
+	if err != nil {
+		return fmt.Errorf("mirror sync failed: %w", err)
+	}
+
+	for _, pkg := range plan.Fetch {
+		printVerbose("  fetch %-30s %-12s %s\n", pkg.Name, pkg.Version, manager.FormatSize(pkg.Size))
+	}
+	for _, file := range plan.Prune {
+		printVerbose("  prune %s\n", file)
+	}
+
+	if opts.DryRun {
+		fmt.Printf("Would mirror %d package(s):\n", len(plan.Packages))
+		fmt.Printf("  fetch: %d package(s), %s\n", len(plan.Fetch), manager.FormatSize(plan.FetchBytes))
+		fmt.Printf("  prune: %d file(s), %s\n", len(plan.Prune), manager.FormatSize(plan.PruneBytes))
+		return nil
+	}
+
+	fmt.Printf("Mirrored %d package(s): fetched %d (%s), pruned %d\n",
+		len(plan.Packages), len(plan.Fetch), manager.FormatSize(plan.FetchBytes), len(plan.Prune))
+	return nil
+}
@@ -66,6 +66,7 @@ Usage:
   mixmagisk revoke <user>       Revoke root access from user
   mixmagisk log                 Show recent root operations
   mixmagisk policy              Manage access policies`,
+	ValidArgsFunction: completeMixmagisk,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			showMixmagiskStatus()
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+// outputAPIVersion identifies the schema of structured output. It only
+// changes when a field is removed or changes meaning; new fields may be
+// added within a version.
+const outputAPIVersion = "mix/v1"
+
+var outputFormat = "text"
+
+// outputEnvelope wraps every structured result so consumers can dispatch on
+// kind and check api_version before decoding data.
+type outputEnvelope struct {
+	APIVersion string       `json:"api_version"`
+	Kind       string       `json:"kind"`
+	Data       interface{}  `json:"data,omitempty"`
+	Error      *outputError `json:"error,omitempty"`
+}
+
+type outputError struct {
+	Command string `json:"command"`
+	Message string `json:"message"`
+}
+
+// errReported is returned by commands whose failure has already been
+// written as part of a structured result, so Execute does not print a
+// second Error document.
+var errReported = errors.New("operation failed")
+
+// planOutput is the structured result of install and remove. Results is
+// only present when the plan was applied.
+type planOutput struct {
+	Packages []string           `json:"packages"`
+	Warnings []string           `json:"warnings,omitempty"`
+	Space    *manager.SpacePlan `json:"space,omitempty"`
+	Applied  bool               `json:"applied"`
+	Results  []operationResult  `json:"results,omitempty"`
+}
+
+// operationResult is the outcome of one package operation.
+type operationResult struct {
+	Package string `json:"package"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// applyStructured runs op for each package in order, stopping at the first
+// failure, and returns the per-package results. The error is errReported
+// when an operation failed.
+func applyStructured(packages []string, op func(string) error) ([]operationResult, error) {
+	var results []operationResult
+	for _, pkg := range packages {
+		if err := op(pkg); err != nil {
+			results = append(results, operationResult{Package: pkg, Status: "failed", Error: err.Error()})
+			return results, errReported
+		}
+		results = append(results, operationResult{Package: pkg, Status: "ok"})
+	}
+	return results, nil
+}
+
+// nonNil makes empty lists encode as [] rather than null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", outputFormat, "output format: text, json or yaml")
+}
+
+// checkOutputFormat validates --output and, for structured formats, stops
+// cobra from printing errors and usage as text.
+func checkOutputFormat(cmd *cobra.Command) error {
+	switch outputFormat {
+	case "text":
+		return nil
+	case "json", "yaml":
+		cmd.SilenceErrors = true
+		cmd.SilenceUsage = true
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q (want text, json or yaml)", outputFormat)
+	}
+}
+
+// structuredOutput reports whether results should be printed as JSON or
+// YAML instead of text.
+func structuredOutput() bool {
+	return outputFormat == "json" || outputFormat == "yaml"
+}
+
+// writeOutput prints data wrapped in the versioned envelope.
+func writeOutput(kind string, data interface{}) error {
+	return writeEnvelope(os.Stdout, outputEnvelope{APIVersion: outputAPIVersion, Kind: kind, Data: data})
+}
+
+// writeError prints err as a structured Error document.
+func writeError(cmd *cobra.Command, err error) {
+	name := ""
+	if cmd != nil {
+		name = strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+	}
+	writeEnvelope(os.Stdout, outputEnvelope{
+		APIVersion: outputAPIVersion,
+		Kind:       "Error",
+		Error:      &outputError{Command: name, Message: err.Error()},
+	})
+}
+
+func writeEnvelope(w io.Writer, env outputEnvelope) error {
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == "yaml" {
+		var buf bytes.Buffer
+		if err := jsonToYAML(&buf, data); err != nil {
+			return err
+		}
+		_, err = w.Write(buf.Bytes())
+		return err
+	}
+
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// jsonToYAML re-encodes a JSON document as block-style YAML, keeping the
+// key order of the input. Strings are emitted double-quoted, which YAML
+// reads with the same escapes as JSON.
+func jsonToYAML(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeOrdered(dec)
+	if err != nil {
+		return err
+	}
+	writeYAMLValue(w, v, 0, false)
+	return nil
+}
+
+// orderedField is one key/value pair of a JSON object in document order.
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			fields := []orderedField{}
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, orderedField{key: keyTok.(string), value: value})
+			}
+			_, err := dec.Token()
+			return fields, err
+		case '[':
+			items := []interface{}{}
+			for dec.More() {
+				item, err := decodeOrdered(dec)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			_, err := dec.Token()
+			return items, err
+		}
+	}
+	return tok, nil
+}
+
+func writeYAMLValue(w io.Writer, v interface{}, indent int, inList bool) {
+	pad := strings.Repeat("  ", indent)
+
+	switch t := v.(type) {
+	case []orderedField:
+		if len(t) == 0 {
+			fmt.Fprintln(w, "{}")
+			return
+		}
+		for i, f := range t {
+			prefix := pad
+			if inList && i == 0 {
+				prefix = ""
+			}
+			key := yamlKey(f.key)
+			switch fv := f.value.(type) {
+			case []orderedField:
+				if len(fv) == 0 {
+					fmt.Fprintf(w, "%s%s: {}\n", prefix, key)
+					continue
+				}
+				fmt.Fprintf(w, "%s%s:\n", prefix, key)
+				writeYAMLValue(w, fv, indent+1, false)
+			case []interface{}:
+				if len(fv) == 0 {
+					fmt.Fprintf(w, "%s%s: []\n", prefix, key)
+					continue
+				}
+				fmt.Fprintf(w, "%s%s:\n", prefix, key)
+				writeYAMLValue(w, fv, indent, false)
+			default:
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, key, yamlScalar(fv))
+			}
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			fmt.Fprintln(w, "[]")
+			return
+		}
+		for _, item := range t {
+			switch iv := item.(type) {
+			case []orderedField:
+				if len(iv) == 0 {
+					fmt.Fprintf(w, "%s- {}\n", pad)
+					continue
+				}
+				fmt.Fprintf(w, "%s- ", pad)
+				writeYAMLValue(w, iv, indent+1, true)
+			case []interface{}:
+				fmt.Fprintf(w, "%s-\n", pad)
+				writeYAMLValue(w, iv, indent+1, false)
+			default:
+				fmt.Fprintf(w, "%s- %s\n", pad, yamlScalar(iv))
+			}
+		}
+	default:
+		fmt.Fprintln(w, yamlScalar(t))
+	}
+}
+
+// yamlReserved lists plain scalars YAML resolves to something other than a
+// string, such as booleans and null (matched case-insensitively).
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"y": true, "n": true, "null": true,
+}
+
+// yamlKey leaves plain identifiers unquoted: a letter or underscore followed
+// by letters, digits, underscores and dashes, other than the words YAML reads
+// as booleans or null. Every other key is quoted like a string value.
+func yamlKey(key string) string {
+	if key == "" || yamlReserved[strings.ToLower(key)] {
+		return yamlScalar(key)
+	}
+	for i, r := range key {
+		letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_'
+		if !letter && (i == 0 || !(r >= '0' && r <= '9' || r == '-')) {
+			return yamlScalar(key)
+		}
+	}
+	return key
+}
+
+func yamlScalar(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		data, _ := json.Marshal(t)
+		return string(data)
+	case json.Number:
+		return t.String()
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	default:
+		return fmt.Sprint(t)
+	}
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestYAMLKeys(t *testing.T) {
+	for key, want := range map[string]string{
+		"name":         "name",
+		"api_version":  "api_version",
+		"x86-64":       "x86-64",
+		"true":         `"true"`,
+		"False":        `"False"`,
+		"null":         `"null"`,
+		"yes":          `"yes"`,
+		"~":            `"~"`,
+		"":             `""`,
+		"42":           `"42"`,
+		"1.5":          `"1.5"`,
+		"-1":           `"-1"`,
+		"0x1F":         `"0x1F"`,
+		"usr/bin/curl": `"usr/bin/curl"`,
+		"key: value":   `"key: value"`,
+	} {
+		if got := yamlKey(key); got != want {
+			t.Errorf("yamlKey(%q) = %s, want %s", key, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jsonToYAML(&buf, []byte(`{"true": 1, "null": null, "~": "x", "8.0": ["a"], "name": "curl"}`)); err != nil {
+		t.Fatalf("jsonToYAML failed: %v", err)
+	}
+	want := "\"true\": 1\n\"null\": null\n\"~\": \"x\"\n\"8.0\":\n- \"a\"\nname: \"curl\"\n"
+	if buf.String() != want {
+		t.Errorf("jsonToYAML = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"golang.org/x/term"
+)
+
+// Progress display modes selected with --progress.
+const (
+	progressAuto  = "auto"
+	progressTTY   = "tty"
+	progressPlain = "plain"
+	progressJSON  = "json"
+)
+
+var progressMode = progressAuto
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&progressMode, "progress", progressMode, "progress display: auto, tty, plain or json")
+}
+
+// checkProgressMode validates --progress.
+func checkProgressMode() error {
+	switch progressMode {
+	case progressAuto, progressTTY, progressPlain, progressJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown progress mode %q (want auto, tty, plain or json)", progressMode)
+	}
+}
+
+// progressDisplay resolves --progress to tty, plain or json. In auto mode
+// structured output gets JSON events, a capable terminal gets the
+// interactive view and everything else gets plain lines. The interactive
+// view needs text output on stdout, so tty falls back to plain otherwise.
+func progressDisplay() string {
+	switch progressMode {
+	case progressTTY:
+		if structuredOutput() {
+			return progressPlain
+		}
+		return progressTTY
+	case progressPlain, progressJSON:
+		return progressMode
+	}
+
+	if structuredOutput() {
+		return progressJSON
+	}
+	if terminalCapable(os.Stdout) {
+		return progressTTY
+	}
+	return progressPlain
+}
+
+// terminalCapable reports whether f is a terminal that can redraw the
+// interactive view. Dumb terminals and serial consoles, which report no
+// window size (such as the ttyS0 console of mix viso boot), are not.
+func terminalCapable(f *os.File) bool {
+	fd := int(f.Fd())
+	if !term.IsTerminal(fd) {
+		return false
+	}
+	switch os.Getenv("TERM") {
+	case "", "dumb":
+		return false
+	}
+	width, height, err := term.GetSize(fd)
+	return err == nil && width > 0 && height > 0
+}
+
+// startEventLog subscribes to mgr's events and writes them as JSON lines
+// to stderr in json mode, or as plain lines otherwise: to stderr in
+// structured output mode, so stdout only carries the result, and to stdout
+// in text mode. The returned function closes the subscription and waits
+// until every event has been written.
+func startEventLog(mgr *manager.Manager) (stop func()) {
+	sub := mgr.Subscribe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		switch {
+		case progressDisplay() == progressJSON:
+			writeJSONEvents(os.Stderr, sub.C)
+		case structuredOutput():
+			writePlainEvents(os.Stderr, sub.C)
+		default:
+			writePlainEvents(os.Stdout, sub.C)
+		}
+	}()
+
+	return func() {
+		sub.Close()
+		<-done
+	}
+}
+
+// plainProgressInterval is how often the plain logger repeats the
+// percentage of a running download.
+const plainProgressInterval = 2 * time.Second
+
+// writePlainEvents is the plain logger: timestamped package starts and
+// completions, download percentages, script output and warnings. Phases
+// are only shown with --verbose. Failures are left to the command's error.
+func writePlainEvents(w io.Writer, events <-chan manager.Event) {
+	// lastProgress is when the percentage of the running package was last
+	// printed
+	var lastProgress time.Time
+
+	for e := range events {
+		stamp := e.Time.Format("15:04:05")
+		switch e.Type {
+		case manager.EventStart:
+			lastProgress = e.Time
+			switch e.Op {
+			case manager.OpInstall:
+				fmt.Fprintf(w, "%s Installing %s...\n", stamp, e.Package)
+			case manager.OpRemove:
+				fmt.Fprintf(w, "%s Removing %s...\n", stamp, e.Package)
+			case manager.OpUpgrade:
+				fmt.Fprintf(w, "%s Upgrading %s...\n", stamp, e.Package)
+			}
+		case manager.EventDone:
+			switch e.Op {
+			case manager.OpInstall:
+				fmt.Fprintf(w, "%s   ✓ %s installed successfully\n", stamp, e.Package)
+			case manager.OpRemove:
+				fmt.Fprintf(w, "%s   ✓ %s removed successfully\n", stamp, e.Package)
+			case manager.OpUpgrade:
+				fmt.Fprintf(w, "%s   ✓ %s upgraded to %s\n", stamp, e.Package, e.Version)
+			}
+		case manager.EventPhase:
+			if verbose {
+				fmt.Fprintf(w, "%s     %s\n", stamp, e.Message)
+			}
+		case manager.EventProgress:
+			finished := e.BytesTotal > 0 && e.BytesDone == e.BytesTotal
+			if !finished && e.Time.Sub(lastProgress) < plainProgressInterval {
+				continue
+			}
+			lastProgress = e.Time
+			if e.BytesTotal > 0 {
+				fmt.Fprintf(w, "%s     %s %3d%% (%s / %s)\n", stamp, e.Phase,
+					e.BytesDone*100/e.BytesTotal, manager.FormatSize(e.BytesDone), manager.FormatSize(e.BytesTotal))
+			} else {
+				fmt.Fprintf(w, "%s     %s %s\n", stamp, e.Phase, manager.FormatSize(e.BytesDone))
+			}
+		case manager.EventOutput:
+			fmt.Fprintf(w, "%s     | %s\n", stamp, e.Message)
+		case manager.EventWarning:
+			fmt.Fprintf(w, "%s     ⚠️  %s\n", stamp, e.Message)
+		}
+	}
+}
+
+// writeJSONEvents is the JSON emitter: one event object per line.
+func writeJSONEvents(w io.Writer, events <-chan manager.Event) {
+	enc := json.NewEncoder(w)
+	for e := range events {
+		enc.Encode(e)
+	}
+}
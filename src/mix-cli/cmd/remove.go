@@ -1,25 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
 
 	"github.com/mixos-go/src/mix-cli/pkg/manager"
 	"github.com/spf13/cobra"
-
-	"github.com/charmbracelet/bubbles/progress"
-	"github.com/charmbracelet/bubbles/spinner"
-	tea "github.com/charmbracelet/bubbletea"
-	"golang.org/x/term"
 )
 
 var removeCmd = &cobra.Command{
-	Use:     "remove [packages...]",
-	Aliases: []string{"uninstall", "rm"},
-	Short:   "Remove packages",
-	Long:    `Remove one or more installed packages.`,
-	Args:    cobra.MinimumNArgs(1),
-	RunE:    runRemove,
+	Use:               "remove [packages...]",
+	Aliases:           []string{"uninstall", "rm"},
+	Short:             "Remove packages",
+	Long:              `Remove one or more installed packages.`,
+	Args:              cobra.MinimumNArgs(1),
+	RunE:              runRemove,
+	ValidArgsFunction: completePackages(true),
 }
 
 func init() {
@@ -32,14 +28,14 @@ func runRemove(cmd *cobra.Command, args []string) error {
 	yes, _ := cmd.Flags().GetBool("yes")
 	purge, _ := cmd.Flags().GetBool("purge")
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.ExclusiveLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
 	// Check which packages are installed
-	var toRemove []string
+	var toRemove, warnings []string
 	for _, pkg := range args {
 		installed, err := mgr.IsInstalled(pkg)
 		if err != nil {
@@ -48,15 +44,10 @@ func runRemove(cmd *cobra.Command, args []string) error {
 		if installed {
 			toRemove = append(toRemove, pkg)
 		} else {
-			fmt.Printf("Package %s is not installed, skipping.\n", pkg)
+			warnings = append(warnings, fmt.Sprintf("Package %s is not installed, skipping.", pkg))
 		}
 	}
 
-	if len(toRemove) == 0 {
-		fmt.Println("No packages to remove.")
-		return nil
-	}
-
 	// Check for reverse dependencies
 	for _, pkg := range toRemove {
 		deps, err := mgr.GetReverseDependencies(pkg)
@@ -64,8 +55,37 @@ func runRemove(cmd *cobra.Command, args []string) error {
 			return fmt.Errorf("failed to check reverse dependencies: %w", err)
 		}
 		if len(deps) > 0 {
-			fmt.Printf("Warning: %s is required by: %v\n", pkg, deps)
+			warnings = append(warnings, fmt.Sprintf("Warning: %s is required by: %v", pkg, deps))
+		}
+	}
+
+	remove := removePackage(mgr, purge)
+	if structuredOutput() {
+		out := planOutput{Packages: toRemove, Warnings: warnings, Applied: yes}
+		if out.Packages == nil {
+			out.Packages = []string{}
+		}
+		var applyErr error
+		if yes {
+			stop := startEventLog(mgr)
+			out.Results, applyErr = applyStructured(toRemove, func(pkg string) error {
+				return remove(cmd.Context(), pkg)
+			})
+			stop()
+		}
+		if err := writeOutput("RemovePlan", out); err != nil {
+			return err
 		}
+		return applyErr
+	}
+
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+
+	if len(toRemove) == 0 {
+		fmt.Println("No packages to remove.")
+		return nil
 	}
 
 	// Show what will be removed
@@ -80,67 +100,27 @@ func runRemove(cmd *cobra.Command, args []string) error {
 
 	// Confirm removal
 	if !yes {
-		fmt.Print("\nProceed with removal? [y/N] ")
-		var response string
-		fmt.Scanln(&response)
-		if response != "y" && response != "Y" {
+		fmt.Println()
+		if !confirm(cmd.Context(), "Proceed with removal?") {
 			fmt.Println("Removal cancelled.")
 			return nil
 		}
 	}
 
-	// If stdout is a terminal, run TUI remover; otherwise run headless
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		ch := make(chan manager.ProgressUpdate)
-		errCh := make(chan error, 1)
-		mgr.SetProgressChan(ch)
-
-		go func() {
-			for _, pkg := range toRemove {
-				if err := mgr.Remove(pkg, purge); err != nil {
-					errCh <- fmt.Errorf("failed to remove %s: %w", pkg, err)
-					close(ch)
-					return
-				}
-			}
-			close(ch)
-			errCh <- nil
-		}()
-
-		s := spinner.New()
-		s.Spinner = spinner.Line
-		pmod := progress.New(progress.WithDefaultGradient())
-		pmod.Width = 40
-
-		model := tuiModel{sp: s, prog: pmod, msg: "Starting...", ch: ch}
-		prg := tea.NewProgram(model)
-
-		if err := prg.Start(); err != nil {
-			// fallback to headless if UI fails
-			for _, pkg := range toRemove {
-				if err := mgr.Remove(pkg, purge); err != nil {
-					return fmt.Errorf("failed to remove %s: %w", pkg, err)
-				}
-			}
-		}
+	return runTransaction(cmd, mgr, transaction{
+		op:       manager.OpRemove,
+		packages: toRemove,
+		apply:    remove,
+	})
+}
 
-		if err := <-errCh; err != nil {
+// removePackage returns the apply function of a remove transaction, which
+// also drops the removed packages from the world file.
+func removePackage(mgr *manager.Manager, purge bool) func(ctx context.Context, pkg string) error {
+	return func(ctx context.Context, pkg string) error {
+		if err := mgr.Remove(ctx, pkg, purge); err != nil {
 			return err
 		}
-
-		fmt.Println("\nRemoval complete!")
-		return nil
-	}
-
-	// non-interactive removal
-	for _, pkg := range toRemove {
-		fmt.Printf("Removing %s...\n", pkg)
-		if err := mgr.Remove(pkg, purge); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", pkg, err)
-		}
-		fmt.Printf("  ✓ %s removed successfully\n", pkg)
+		return updateWorld(mgr, nil, []string{pkg})
 	}
-
-	fmt.Println("\nRemoval complete!")
-	return nil
 }
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Package repository tools",
+	Long: `Tools for building and serving mix package repositories.
+
+A repository is a directory of .mixpkg files named
+<name>-<version>-<arch>.mixpkg (<name>-<version>.mixpkg for packages that
+declare no architecture) plus the indexes describing them: index.json for
+older clients and the compressed index.v2.json.gz with its diffs in
+index.v2.diffs/ (each optionally signed with a .sig next to it).`,
+}
+
+var repoIndexCmd = &cobra.Command{
+	Use:   "index <dir>",
+	Short: "Generate the package index for a directory of packages",
+	Long: `Scan a directory of .mixpkg files and write the indexes that
+'mix update' downloads: index.json and the compressed v2 index. Checksums,
+hashes and sizes are computed from the files.
+
+Whenever the package set changes the v2 index moves to a new generation,
+and a diff from the previous generation is written to index.v2.diffs/ so
+clients download only what changed. The last 10 diffs are kept.
+
+With --sign-key the indexes are signed with an ed25519 key. --name records
+a repository name in the v2 header.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRepoIndex,
+}
+
+var repoServeCmd = &cobra.Command{
+	Use:   "serve <dir>",
+	Short: "Serve a repository over HTTP",
+	Long: `Serve a repository directory over HTTP for local development and
+testing. Responses include ETags and support Range requests.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRepoServe,
+}
+
+var repoKeygenCmd = &cobra.Command{
+	Use:   "keygen <prefix>",
+	Short: "Generate an index signing key pair",
+	Long:  `Generate an ed25519 key pair as <prefix>.key and <prefix>.pub.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRepoKeygen,
+}
+
+func init() {
+	rootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoIndexCmd)
+	repoCmd.AddCommand(repoServeCmd)
+	repoCmd.AddCommand(repoKeygenCmd)
+
+	repoIndexCmd.Flags().String("sign-key", "", "sign the index with this ed25519 private key (PEM)")
+	repoIndexCmd.Flags().String("name", "", "repository name recorded in the v2 index")
+	repoServeCmd.Flags().String("addr", "127.0.0.1:8080", "address to listen on")
+}
+
+func runRepoIndex(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	signKey, _ := cmd.Flags().GetString("sign-key")
+	name, _ := cmd.Flags().GetString("name")
+
+	var key ed25519.PrivateKey
+	if signKey != "" {
+		var err error
+		key, err = manager.LoadSigningKey(signKey)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+	}
+
+	index, err := manager.BuildIndex(dir)
+	if err != nil {
+		return fmt.Errorf("failed to build index: %w", err)
+	}
+
+	if err := manager.WriteIndex(dir, index, key); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	header, err := manager.WriteIndexV2(dir, index, manager.IndexOptions{Repository: name, Key: key})
+	if err != nil {
+		return fmt.Errorf("failed to write v2 index: %w", err)
+	}
+
+	for _, pkg := range index {
+		printVerbose("  %-30s %-12s %s\n", pkg.Name, pkg.Version, manager.FormatSize(pkg.Size))
+	}
+	fmt.Printf("Indexed %d package(s) in %s (generation %d)\n", len(index), dir, header.Generation)
+	if key != nil {
+		fmt.Printf("Signed with key %s\n", header.KeyID)
+	}
+	return nil
+}
+
+func runRepoServe(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+	addr, _ := cmd.Flags().GetString("addr")
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           manager.NewRepoHandler(dir),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	fmt.Printf("Serving %s on http://%s/\n", dir, addr)
+	fmt.Printf("Use it with: mix --repo http://%s update\n", addr)
+	return server.ListenAndServe()
+}
+
+func runRepoKeygen(cmd *cobra.Command, args []string) error {
+	prefix := args[0]
+	if err := manager.GenerateSigningKey(prefix); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	fmt.Printf("Private key: %s.key\n", prefix)
+	fmt.Printf("Public key:  %s.pub\n", prefix)
+	return nil
+}
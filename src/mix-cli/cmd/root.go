@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -23,16 +27,29 @@ var rootCmd = &cobra.Command{
 It provides commands to install, remove, update, and search for packages.
 Packages are distributed in the .mixpkg format with dependency resolution.`,
 	Version: version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(cmd); err != nil {
+			return err
+		}
+		return checkProgressMode()
+	},
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := signalContext()
+	defer stop()
+
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	if err != nil && structuredOutput() && !errors.Is(err, errReported) {
+		writeError(cmd, err)
+	}
+	return err
 }
 
 func init() {
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().StringVar(&dbPath, "db", dbPath, "path to package database")
-	rootCmd.PersistentFlags().StringVar(&repoURL, "repo", repoURL, "package repository URL")
+	rootCmd.PersistentFlags().StringVar(&repoURL, "repo", repoURL, "package repository (http(s):// or file:// URL, or a directory)")
 	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache", cacheDir, "package cache directory")
 
 	// Ensure directories exist
@@ -40,6 +57,53 @@ func init() {
 	os.MkdirAll("/var/lib/mix", 0755)
 }
 
+// signalContext returns a context that is cancelled by the first SIGINT or
+// SIGTERM, so running operations stop at the next safe point. A second
+// signal exits immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+		case <-ctx.Done():
+			return
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stopping at the next safe point (interrupt again to force)...")
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// confirm asks a yes/no question on stdin. It returns false unless the
+// answer is yes, including when ctx is cancelled while waiting.
+func confirm(ctx context.Context, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer := make(chan string, 1)
+	go func() {
+		var response string
+		fmt.Scanln(&response)
+		answer <- response
+	}()
+
+	select {
+	case response := <-answer:
+		return response == "y" || response == "Y"
+	case <-ctx.Done():
+		fmt.Println()
+		return false
+	}
+}
+
 func printVerbose(format string, args ...interface{}) {
 	if verbose {
 		fmt.Printf(format, args...)
@@ -11,23 +11,55 @@ import (
 var searchCmd = &cobra.Command{
 	Use:   "search [query]",
 	Short: "Search for packages",
-	Long:  `Search for packages by name or description.`,
-	Args:  cobra.MinimumNArgs(1),
-	RunE:  runSearch,
+	Long: `Search for packages by name or description.
+
+Every word of the query must appear in the name or description of a
+package. Packages named exactly like a word come first, then the best
+matches. Field filters narrow the search:
+
+  name:ssh          the name contains ssh (* is a wildcard)
+  desc:client       the description mentions client
+  dep:openssl       the package depends on openssl
+  file:/usr/bin/ssh the package ships that path; a file name or a
+                    relative path matches any directory (* is a wildcard)
+  installed:yes     only installed packages (installed:no, the others)
+
+With --file, mix finds the packages that provide a path, installed or
+not, from the file lists of the package index.`,
+	Example: `  mix search ssh client
+  mix search dep:openssl installed:no
+  mix search --file /usr/bin/ssh`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if file, _ := cmd.Flags().GetString("file"); file == "" {
+			return cobra.MinimumNArgs(1)(cmd, args)
+		}
+		return nil
+	},
+	RunE: runSearch,
 }
 
 func init() {
 	rootCmd.AddCommand(searchCmd)
 	searchCmd.Flags().BoolP("installed", "i", false, "search only installed packages")
+	searchCmd.Flags().StringP("file", "f", "", "find the packages that provide a path")
+}
+
+type searchOutput struct {
+	Query   string                 `json:"query"`
+	Results []manager.SearchResult `json:"results"`
 }
 
 func runSearch(cmd *cobra.Command, args []string) error {
 	installedOnly, _ := cmd.Flags().GetBool("installed")
+	file, _ := cmd.Flags().GetString("file")
 	query := strings.Join(args, " ")
+	if file != "" {
+		query = strings.TrimSpace(query + ` file:"` + file + `"`)
+	}
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.SharedLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
@@ -36,6 +68,13 @@ func runSearch(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("search failed: %w", err)
 	}
 
+	if structuredOutput() {
+		if results == nil {
+			results = []manager.SearchResult{}
+		}
+		return writeOutput("SearchResults", searchOutput{Query: query, Results: results})
+	}
+
 	if len(results) == 0 {
 		fmt.Printf("No packages found matching '%s'\n", query)
 		return nil
@@ -51,6 +90,9 @@ func runSearch(cmd *cobra.Command, args []string) error {
 		if pkg.Description != "" {
 			fmt.Printf("    %s\n", pkg.Description)
 		}
+		for _, path := range pkg.Files {
+			fmt.Printf("    provides %s\n", path)
+		}
 	}
 	fmt.Println("\n[*] = installed")
 
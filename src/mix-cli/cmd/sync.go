@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Make the installed packages match the world file",
+	Long: `Install, upgrade and remove packages so that the system matches the
+world file (/etc/mix/world, see --world).
+
+The world file lists the packages wanted on the system, one per line,
+optionally with a version constraint:
+
+  # base system
+  openssh
+  openssl>=3.0
+  zlib=1.3
+
+Packages that are missing are installed with their dependencies,
+packages whose version does not satisfy their constraint are upgraded
+(or downgraded) to the repository version, and packages that are neither
+listed nor needed by a listed package are removed. The changes are shown
+before anything is done; disk space is checked up front, and mix stops
+at the first failure, so running sync again picks up where it left off.
+
+mix install and mix remove keep the world file up to date. Without a
+world file, the explicitly installed packages are used.`,
+	Args: cobra.NoArgs,
+	RunE: runSync,
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().BoolP("yes", "y", false, "assume yes to all prompts")
+}
+
+// opSync is the transaction of mix sync, which mixes installs, upgrades
+// and removals.
+const opSync = "sync"
+
+type syncPlanOutput struct {
+	World   []manager.WorldEntry     `json:"world"`
+	Install []string                 `json:"install"`
+	Upgrade []manager.PackageUpgrade `json:"upgrade"`
+	Remove  []string                 `json:"remove"`
+	Space   *manager.SpacePlan       `json:"space,omitempty"`
+	Applied bool                     `json:"applied"`
+	Results []operationResult        `json:"results,omitempty"`
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	mgr, err := openManager(manager.ExclusiveLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	world, err := mgr.LoadWorld(worldPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the world file: %w", err)
+	}
+	plan, err := mgr.PlanSync(world)
+	if err != nil {
+		return fmt.Errorf("failed to plan the sync: %w", err)
+	}
+
+	// Upgrades come first, as new packages may need the new versions, and
+	// removals last, so nothing still needed goes away
+	var packages []string
+	apply := make(map[string]func(ctx context.Context, pkg string) error)
+	details := make(map[string]string)
+	for _, pkg := range plan.Upgrade {
+		packages = append(packages, pkg.Name)
+		apply[pkg.Name] = mgr.Upgrade
+		details[pkg.Name] = pkg.CurrentVersion + " -> " + pkg.NewVersion
+	}
+	fetch := append([]string(nil), packages...)
+	for _, pkg := range plan.Install {
+		packages = append(packages, pkg)
+		apply[pkg] = mgr.Install
+		if info, err := mgr.GetPackageInfo(pkg); err == nil {
+			details[pkg] = "install " + info.Version
+		}
+		fetch = append(fetch, pkg)
+	}
+	for _, pkg := range plan.Remove {
+		packages = append(packages, pkg)
+		apply[pkg] = func(ctx context.Context, pkg string) error {
+			return mgr.Remove(ctx, pkg, false)
+		}
+		if info, err := mgr.GetPackageInfo(pkg); err == nil {
+			details[pkg] = "remove " + info.Version
+		}
+	}
+
+	space, err := mgr.PlanSpace(manager.OpInstall, fetch)
+	if err != nil {
+		return fmt.Errorf("failed to work out the disk space needed: %w", err)
+	}
+
+	if structuredOutput() {
+		out := syncPlanOutput{
+			World:   world.Entries(),
+			Install: plan.Install,
+			Upgrade: plan.Upgrade,
+			Remove:  plan.Remove,
+			Space:   space,
+			Applied: yes,
+		}
+		if out.World == nil {
+			out.World = []manager.WorldEntry{}
+		}
+		var applyErr error
+		if yes {
+			if err := space.Check(); err != nil {
+				out.Applied = false
+				if werr := writeOutput("SyncPlan", out); werr != nil {
+					return werr
+				}
+				return err
+			}
+			stop := startEventLog(mgr)
+			out.Results, applyErr = applyStructured(packages, func(pkg string) error {
+				return apply[pkg](cmd.Context(), pkg)
+			})
+			stop()
+			if err := mgr.MarkWorld(world); err != nil {
+				return err
+			}
+		}
+		if err := writeOutput("SyncPlan", out); err != nil {
+			return err
+		}
+		return applyErr
+	}
+
+	if plan.Empty() {
+		fmt.Printf("The installed packages already match %s.\n", worldPath)
+		return mgr.MarkWorld(world)
+	}
+
+	fmt.Printf("The following changes will be made to match %s:\n", worldPath)
+	for _, pkg := range plan.Upgrade {
+		fmt.Printf("  ~ %s (%s)\n", pkg.Name, details[pkg.Name])
+	}
+	for _, pkg := range plan.Install {
+		fmt.Printf("  + %s (%s)\n", pkg, details[pkg])
+	}
+	for _, pkg := range plan.Remove {
+		fmt.Printf("  - %s (%s)\n", pkg, details[pkg])
+	}
+	fmt.Printf("\nTotal: %d to install, %d to upgrade, %d to remove\n",
+		len(plan.Install), len(plan.Upgrade), len(plan.Remove))
+	if err := printSpacePlan(space); err != nil {
+		return err
+	}
+
+	if !yes {
+		fmt.Println()
+		if !confirm(cmd.Context(), "Proceed with sync?") {
+			fmt.Println("Sync cancelled.")
+			return nil
+		}
+	}
+
+	err = runTransaction(cmd, mgr, transaction{
+		op:       opSync,
+		packages: packages,
+		details:  details,
+		apply: func(ctx context.Context, pkg string) error {
+			return apply[pkg](ctx, pkg)
+		},
+	})
+	if markErr := mgr.MarkWorld(world); err == nil {
+		err = markErr
+	}
+	return err
+}
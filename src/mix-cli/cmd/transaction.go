@@ -0,0 +1,391 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+// transaction is a batch of package operations of one kind, applied in
+// order and stopping at the first failure.
+type transaction struct {
+	op       string
+	packages []string
+	// details is shown next to a package, e.g. "1.0 -> 1.1"
+	details map[string]string
+	apply   func(ctx context.Context, pkg string) error
+}
+
+type transactionVerbs struct {
+	progressive string
+	past        string
+	noun        string
+}
+
+var verbs = map[string]transactionVerbs{
+	manager.OpInstall: {"Installing", "installed", "Installation"},
+	manager.OpRemove:  {"Removing", "removed", "Removal"},
+	manager.OpUpgrade: {"Upgrading", "upgraded", "Upgrade"},
+	opSync:            {"Syncing", "synced", "Sync"},
+}
+
+// runTransaction applies tx, showing the transaction view in tty progress
+// mode and the event log otherwise, and prints a summary of what succeeded and
+// what failed.
+func runTransaction(cmd *cobra.Command, mgr *manager.Manager, tx transaction) error {
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	var results []operationResult
+	if progressDisplay() == progressTTY {
+		sub := mgr.Subscribe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer sub.Close()
+			results, _ = applyStructured(tx.packages, func(pkg string) error {
+				return tx.apply(ctx, pkg)
+			})
+		}()
+
+		prg := tea.NewProgram(newTransactionModel(tx, sub.C, cancel))
+		if _, err := prg.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Progress view unavailable: %v\n", err)
+		}
+		<-done
+	} else {
+		stop := startEventLog(mgr)
+		results, _ = applyStructured(tx.packages, func(pkg string) error {
+			return tx.apply(ctx, pkg)
+		})
+		stop()
+	}
+
+	return printTransactionSummary(tx, results)
+}
+
+// printSpacePlan shows the download and installed size of a transaction.
+// If a filesystem lacks the space, it shows the breakdown per filesystem
+// and returns the error, before anything is changed.
+func printSpacePlan(plan *manager.SpacePlan) error {
+	if plan.Download > 0 {
+		fmt.Printf("Download size:  %s\n", manager.FormatSize(plan.Download))
+	}
+	fmt.Printf("Installed size: %s\n", manager.FormatSize(plan.Installed))
+	if len(plan.Unknown) > 0 {
+		fmt.Printf("  (not known yet for %s, checked after download)\n", strings.Join(plan.Unknown, ", "))
+	}
+
+	err := plan.Check()
+	if err == nil {
+		return nil
+	}
+	fmt.Println("\nNot enough disk space:")
+	for _, mount := range plan.Mounts {
+		name := mount.MountPoint
+		if mount.Tmpfs {
+			name += " (tmpfs)"
+		}
+		mark := "ok"
+		if mount.Needed > mount.Free {
+			mark = "short by " + manager.FormatSize(mount.Needed-mount.Free)
+		}
+		fmt.Printf("  %-30s needs %10s, %10s free  %s\n", name,
+			manager.FormatSize(mount.Needed), manager.FormatSize(mount.Free), mark)
+	}
+	return err
+}
+
+// printTransactionSummary lists succeeded, failed and skipped packages,
+// with their details, and returns the failure, if any.
+func printTransactionSummary(tx transaction, results []operationResult) error {
+	v := verbs[tx.op]
+	label := func(pkg string) string {
+		if detail := tx.details[pkg]; detail != "" {
+			return pkg + " " + detail
+		}
+		return pkg
+	}
+
+	var succeeded []string
+	var failed *operationResult
+	for i, r := range results {
+		if r.Status == "ok" {
+			succeeded = append(succeeded, label(r.Package))
+		} else {
+			failed = &results[i]
+		}
+	}
+	var skipped []string
+	for _, pkg := range tx.packages[len(results):] {
+		skipped = append(skipped, label(pkg))
+	}
+
+	fmt.Println("\nSummary:")
+	if len(succeeded) > 0 {
+		fmt.Printf("  ✓ %s: %s\n", v.past, strings.Join(succeeded, ", "))
+	}
+	if failed == nil {
+		fmt.Printf("\n%s complete!\n", v.noun)
+		return nil
+	}
+
+	fmt.Printf("  ✗ failed: %s\n", label(failed.Package))
+	if len(skipped) > 0 {
+		fmt.Printf("  - skipped: %s\n", strings.Join(skipped, ", "))
+	}
+	return fmt.Errorf("failed to %s %s: %s", tx.op, failed.Package, failed.Error)
+}
+
+// Package row states in the transaction view.
+const (
+	rowPending = iota
+	rowRunning
+	rowDone
+	rowFailed
+)
+
+type transactionRow struct {
+	name   string
+	detail string
+	state  int
+	status string
+
+	bytesDone  int64
+	bytesTotal int64
+	// speed is a moving average in bytes per second
+	speed    float64
+	lastSeen time.Time
+}
+
+// logLines is how many log lines the collapsed and expanded pane show.
+const (
+	logLinesCollapsed = 3
+	logLinesExpanded  = 15
+)
+
+// transactionModel is the Bubble Tea view of a transaction. It consumes
+// manager events until the subscription is closed.
+type transactionModel struct {
+	title   string
+	rows    []*transactionRow
+	byName  map[string]*transactionRow
+	events  <-chan manager.Event
+	cancel  context.CancelFunc
+	sp      spinner.Model
+	bar     progress.Model
+	log     []string
+	expand  bool
+	stopped bool
+}
+
+// eventsClosedMsg reports that the event subscription was closed.
+type eventsClosedMsg struct{}
+
+func newTransactionModel(tx transaction, events <-chan manager.Event, cancel context.CancelFunc) transactionModel {
+	sp := spinner.New()
+	sp.Spinner = spinner.Dot
+	bar := progress.New(progress.WithDefaultGradient())
+	bar.Width = 50
+
+	m := transactionModel{
+		title:  fmt.Sprintf("%s %d package(s)", verbs[tx.op].progressive, len(tx.packages)),
+		byName: make(map[string]*transactionRow),
+		events: events,
+		cancel: cancel,
+		sp:     sp,
+		bar:    bar,
+	}
+	for _, pkg := range tx.packages {
+		row := &transactionRow{name: pkg, detail: tx.details[pkg], status: "pending"}
+		m.rows = append(m.rows, row)
+		m.byName[pkg] = row
+	}
+	return m
+}
+
+func (m transactionModel) next() tea.Msg {
+	e, ok := <-m.events
+	if !ok {
+		return eventsClosedMsg{}
+	}
+	return e
+}
+
+func (m transactionModel) Init() tea.Cmd {
+	return tea.Batch(m.sp.Tick, m.next)
+}
+
+func (m transactionModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c":
+			// The terminal is in raw mode, so Ctrl-C arrives as a key
+			// press rather than SIGINT
+			if !m.stopped {
+				m.stopped = true
+				m.cancel()
+				m.log = append(m.log, "Cancelling after the current step...")
+			}
+		case "l":
+			m.expand = !m.expand
+		}
+		return m, nil
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.sp, cmd = m.sp.Update(msg)
+		return m, cmd
+	case progress.FrameMsg:
+		bar, cmd := m.bar.Update(msg)
+		if b, ok := bar.(progress.Model); ok {
+			m.bar = b
+		}
+		return m, cmd
+	case manager.Event:
+		m.apply(msg)
+		return m, tea.Batch(m.next, m.bar.SetPercent(m.percent()))
+	case eventsClosedMsg:
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+// apply updates the view state from one event.
+func (m *transactionModel) apply(e manager.Event) {
+	row := m.byName[e.Package]
+	if row == nil {
+		return
+	}
+
+	switch e.Type {
+	case manager.EventStart:
+		row.state, row.status = rowRunning, "starting"
+	case manager.EventPhase:
+		row.status = e.Message
+		row.bytesDone, row.bytesTotal, row.speed = 0, 0, 0
+		row.lastSeen = time.Time{}
+	case manager.EventProgress:
+		now := time.Now()
+		if !row.lastSeen.IsZero() {
+			if dt := now.Sub(row.lastSeen).Seconds(); dt > 0 {
+				rate := float64(e.BytesDone-row.bytesDone) / dt
+				if row.speed == 0 {
+					row.speed = rate
+				} else {
+					row.speed = 0.7*row.speed + 0.3*rate
+				}
+			}
+		}
+		row.bytesDone, row.bytesTotal, row.lastSeen = e.BytesDone, e.BytesTotal, now
+	case manager.EventOutput:
+		m.log = append(m.log, e.Package+": "+e.Message)
+	case manager.EventWarning:
+		m.log = append(m.log, e.Package+": warning: "+e.Message)
+	case manager.EventError:
+		row.state, row.status = rowFailed, "failed"
+		m.log = append(m.log, e.Package+": error: "+e.Message)
+		m.expand = true
+	case manager.EventDone:
+		row.state, row.status = rowDone, "done"
+		if e.Version != "" {
+			row.status = "done (" + e.Version + ")"
+		}
+	}
+}
+
+// percent is the overall progress: finished packages plus the download
+// fraction of the running one.
+func (m transactionModel) percent() float64 {
+	if len(m.rows) == 0 {
+		return 1
+	}
+	var total float64
+	for _, row := range m.rows {
+		switch {
+		case row.state == rowDone || row.state == rowFailed:
+			total++
+		case row.state == rowRunning && row.bytesTotal > 0:
+			total += float64(row.bytesDone) / float64(row.bytesTotal)
+		}
+	}
+	return total / float64(len(m.rows))
+}
+
+func (m transactionModel) View() string {
+	title := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("12"))
+	faint := lipgloss.NewStyle().Faint(true)
+	green := lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
+	red := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+
+	width := 0
+	for _, row := range m.rows {
+		label := row.name
+		if row.detail != "" {
+			label += " " + row.detail
+		}
+		if len(label) > width {
+			width = len(label)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(title.Render(m.title) + "\n\n")
+
+	for _, row := range m.rows {
+		label := row.name
+		if row.detail != "" {
+			label += " " + row.detail
+		}
+		label = fmt.Sprintf("%-*s", width, label)
+
+		status := row.status
+		if row.bytesTotal > 0 && row.state == rowRunning {
+			status = fmt.Sprintf("%s  %s / %s", status, manager.FormatSize(row.bytesDone), manager.FormatSize(row.bytesTotal))
+			if row.speed > 0 {
+				status += fmt.Sprintf("  %s/s", manager.FormatSize(int64(row.speed)))
+			}
+		}
+
+		switch row.state {
+		case rowPending:
+			b.WriteString(faint.Render(fmt.Sprintf("  · %s  %s", label, status)))
+		case rowRunning:
+			b.WriteString(fmt.Sprintf("  %s %s  %s", m.sp.View(), label, status))
+		case rowDone:
+			b.WriteString(green.Render("  ✓ ") + label + "  " + faint.Render(status))
+		case rowFailed:
+			b.WriteString(red.Render("  ✗ " + label + "  " + status))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n  " + m.bar.View() + "\n")
+
+	if len(m.log) > 0 {
+		lines, hint := logLinesCollapsed, "l: expand log"
+		if m.expand {
+			lines, hint = logLinesExpanded, "l: collapse log"
+		}
+		start := len(m.log) - lines
+		if start < 0 {
+			start = 0
+		}
+		b.WriteString("\n" + faint.Render(fmt.Sprintf("  Log (%d lines, %s)", len(m.log), hint)) + "\n")
+		for _, line := range m.log[start:] {
+			b.WriteString(faint.Render("  | "+line) + "\n")
+		}
+	}
+
+	b.WriteString(faint.Render("\n  ctrl+c: cancel after the current step") + "\n")
+	return b.String()
+}
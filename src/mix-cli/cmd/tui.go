@@ -0,0 +1,683 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
+	"github.com/spf13/cobra"
+)
+
+var tuiCmd = &cobra.Command{
+	Use:   "tui",
+	Short: "Browse and manage packages interactively",
+	Long: `Open a full-screen package browser.
+
+Search available and installed packages, inspect their dependencies,
+reverse dependencies and files, and queue installs, removals and upgrades.
+The queued plan is shown for confirmation and then applied like the
+install, upgrade and remove commands.`,
+	Args: cobra.NoArgs,
+	RunE: runTUI,
+}
+
+func init() {
+	rootCmd.AddCommand(tuiCmd)
+}
+
+// browseEntry is one package in the browser list.
+type browseEntry struct {
+	name        string
+	description string
+	// available and installed are the repository and installed versions,
+	// empty when the package is not in that set
+	available string
+	installed string
+	upgrade   *manager.PackageUpgrade
+}
+
+// browseDetail is the detail pane content of a package, loaded when the
+// cursor first reaches it.
+type browseDetail struct {
+	info  *manager.PackageInfo
+	rdeps []string
+	files []string
+	err   error
+}
+
+// browsePlan is the queued work, with installs resolved to include their
+// dependencies.
+type browsePlan struct {
+	install []string
+	// requested lists the packages marked for install, which go into the
+	// world file; the rest of install are their dependencies
+	requested []string
+	upgrade   []manager.PackageUpgrade
+	remove    []string
+	warnings  []string
+	err       error
+}
+
+func (p browsePlan) empty() bool {
+	return len(p.install) == 0 && len(p.upgrade) == 0 && len(p.remove) == 0
+}
+
+// Browser scopes, cycled with tab.
+const (
+	scopeAll = iota
+	scopeInstalled
+	scopeUpgradable
+	scopeCount
+)
+
+var scopeNames = []string{"all", "installed", "upgradable"}
+
+// Browser screens.
+const (
+	browseList = iota
+	browseSearch
+	browsePlanView
+)
+
+type browseModel struct {
+	mgr     *manager.Manager
+	entries []browseEntry
+	visible []int
+	details map[string]*browseDetail
+	// marks maps a package to the queued operation
+	marks map[string]string
+
+	screen int
+	scope  int
+	search textinput.Model
+	cursor int
+	offset int
+	width  int
+	height int
+	status string
+
+	plan      browsePlan
+	confirmed bool
+}
+
+func newBrowseModel(mgr *manager.Manager) (browseModel, error) {
+	available, err := mgr.ListAvailable()
+	if err != nil {
+		return browseModel{}, fmt.Errorf("failed to list packages: %w", err)
+	}
+	installed, err := mgr.ListInstalled()
+	if err != nil {
+		return browseModel{}, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	upgrades, err := mgr.GetUpgradablePackages()
+	if err != nil {
+		return browseModel{}, fmt.Errorf("failed to check for upgrades: %w", err)
+	}
+
+	byName := make(map[string]*browseEntry)
+	for _, pkg := range available {
+		byName[pkg.Name] = &browseEntry{name: pkg.Name, description: pkg.Description, available: pkg.Version}
+	}
+	for _, pkg := range installed {
+		e, ok := byName[pkg.Name]
+		if !ok {
+			e = &browseEntry{name: pkg.Name, description: pkg.Description}
+			byName[pkg.Name] = e
+		}
+		e.installed = pkg.Version
+	}
+	for i := range upgrades {
+		if e, ok := byName[upgrades[i].Name]; ok {
+			e.upgrade = &upgrades[i]
+		}
+	}
+
+	m := browseModel{
+		mgr:     mgr,
+		details: make(map[string]*browseDetail),
+		marks:   make(map[string]string),
+		search:  textinput.New(),
+		width:   100,
+		height:  30,
+	}
+	for _, e := range byName {
+		m.entries = append(m.entries, *e)
+	}
+	sort.Slice(m.entries, func(i, j int) bool { return m.entries[i].name < m.entries[j].name })
+
+	m.search.Prompt = "/ "
+	m.search.Placeholder = "search names and descriptions"
+	m.search.CharLimit = 64
+	m.filter()
+	return m, nil
+}
+
+// filter recomputes the visible entries from the scope and search text.
+func (m *browseModel) filter() {
+	query := strings.ToLower(strings.TrimSpace(m.search.Value()))
+	m.visible = m.visible[:0]
+	for i, e := range m.entries {
+		switch {
+		case m.scope == scopeInstalled && e.installed == "":
+			continue
+		case m.scope == scopeUpgradable && e.upgrade == nil:
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(e.name), query) &&
+			!strings.Contains(strings.ToLower(e.description), query) {
+			continue
+		}
+		m.visible = append(m.visible, i)
+	}
+	m.cursor, m.offset = 0, 0
+	m.loadDetail()
+}
+
+// current returns the entry under the cursor, or nil if the list is empty.
+func (m *browseModel) current() *browseEntry {
+	if len(m.visible) == 0 {
+		return nil
+	}
+	return &m.entries[m.visible[m.cursor]]
+}
+
+// loadDetail fills the detail cache for the entry under the cursor.
+func (m *browseModel) loadDetail() {
+	e := m.current()
+	if e == nil || m.details[e.name] != nil {
+		return
+	}
+
+	d := &browseDetail{}
+	d.info, d.err = m.mgr.GetPackageInfo(e.name)
+	if d.err == nil {
+		d.rdeps, _ = m.mgr.GetReverseDependencies(e.name)
+		if e.installed != "" {
+			d.files, _ = m.mgr.GetPackageFiles(e.name)
+		}
+	}
+	m.details[e.name] = d
+}
+
+// listHeight is the number of list rows that fit on screen.
+func (m browseModel) listHeight() int {
+	if h := m.height - 7; h > 3 {
+		return h
+	}
+	return 3
+}
+
+func (m *browseModel) move(delta int) {
+	if len(m.visible) == 0 {
+		return
+	}
+	m.cursor += delta
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+	if m.cursor >= len(m.visible) {
+		m.cursor = len(m.visible) - 1
+	}
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if h := m.listHeight(); m.cursor >= m.offset+h {
+		m.offset = m.cursor - h + 1
+	}
+	m.loadDetail()
+}
+
+// mark queues op for the entry under the cursor, or clears its mark if op
+// is already queued. An empty op picks the natural operation: upgrade for
+// outdated packages, remove for other installed ones and install otherwise.
+func (m *browseModel) mark(op string) {
+	e := m.current()
+	if e == nil {
+		return
+	}
+
+	if op == "" {
+		switch {
+		case m.marks[e.name] != "":
+			delete(m.marks, e.name)
+			return
+		case e.upgrade != nil:
+			op = manager.OpUpgrade
+		case e.installed != "":
+			op = manager.OpRemove
+		default:
+			op = manager.OpInstall
+		}
+	} else if m.marks[e.name] == op {
+		delete(m.marks, e.name)
+		return
+	}
+
+	switch {
+	case op == manager.OpInstall && e.installed != "":
+		m.status = e.name + " is already installed"
+	case op == manager.OpInstall && e.available == "":
+		m.status = e.name + " is not in the repository"
+	case op == manager.OpRemove && e.installed == "":
+		m.status = e.name + " is not installed"
+	case op == manager.OpUpgrade && e.upgrade == nil:
+		m.status = e.name + " has no upgrade"
+	default:
+		m.marks[e.name] = op
+	}
+}
+
+// buildPlan resolves the queued operations into a plan.
+func (m browseModel) buildPlan() browsePlan {
+	var plan browsePlan
+	var install []string
+	removing := make(map[string]bool)
+
+	for _, e := range m.entries {
+		switch m.marks[e.name] {
+		case manager.OpInstall:
+			install = append(install, e.name)
+		case manager.OpUpgrade:
+			plan.upgrade = append(plan.upgrade, *e.upgrade)
+		case manager.OpRemove:
+			plan.remove = append(plan.remove, e.name)
+			removing[e.name] = true
+		}
+	}
+
+	if len(install) > 0 {
+		plan.requested = install
+		plan.install, plan.err = m.mgr.ResolveDependencies(install)
+	}
+
+	for _, pkg := range plan.remove {
+		rdeps, err := m.mgr.GetReverseDependencies(pkg)
+		if err != nil {
+			plan.err = fmt.Errorf("failed to check reverse dependencies: %w", err)
+			break
+		}
+		var kept []string
+		for _, dep := range rdeps {
+			if !removing[dep] {
+				kept = append(kept, dep)
+			}
+		}
+		if len(kept) > 0 {
+			plan.warnings = append(plan.warnings, fmt.Sprintf("%s is required by: %s", pkg, strings.Join(kept, ", ")))
+		}
+	}
+
+	return plan
+}
+
+func (m browseModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m browseModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.move(0)
+		return m, nil
+	case tea.KeyMsg:
+		switch m.screen {
+		case browseSearch:
+			return m.updateSearch(msg)
+		case browsePlanView:
+			return m.updatePlan(msg)
+		}
+		return m.updateList(msg)
+	}
+	return m, nil
+}
+
+func (m browseModel) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.status = ""
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "up", "k":
+		m.move(-1)
+	case "down", "j":
+		m.move(1)
+	case "pgup":
+		m.move(-m.listHeight())
+	case "pgdown":
+		m.move(m.listHeight())
+	case "home", "g":
+		m.move(-len(m.visible))
+	case "end", "G":
+		m.move(len(m.visible))
+	case "/":
+		m.screen = browseSearch
+		return m, m.search.Focus()
+	case "tab":
+		m.scope = (m.scope + 1) % scopeCount
+		m.filter()
+	case " ":
+		m.mark("")
+	case "i":
+		m.mark(manager.OpInstall)
+	case "r":
+		m.mark(manager.OpRemove)
+	case "u":
+		m.mark(manager.OpUpgrade)
+	case "enter":
+		if len(m.marks) == 0 {
+			m.status = "Nothing queued; mark packages with space, i, r or u"
+			break
+		}
+		m.plan = m.buildPlan()
+		m.screen = browsePlanView
+	}
+	return m, nil
+}
+
+func (m browseModel) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.search.SetValue("")
+		fallthrough
+	case "enter":
+		m.search.Blur()
+		m.screen = browseList
+		m.filter()
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	m.filter()
+	return m, cmd
+}
+
+func (m browseModel) updatePlan(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "esc", "n":
+		m.screen = browseList
+	case "y", "enter":
+		if m.plan.err == nil && !m.plan.empty() {
+			m.confirmed = true
+			return m, tea.Quit
+		}
+	}
+	return m, nil
+}
+
+func (m browseModel) View() string {
+	if m.screen == browsePlanView {
+		return m.viewPlan()
+	}
+
+	header := titleStyle.UnsetMarginBottom().Render("mix packages") + mutedStyle.Render(fmt.Sprintf("  %d shown · scope: %s · %d queued",
+		len(m.visible), scopeNames[m.scope], len(m.marks)))
+
+	listWidth := m.width * 2 / 5
+	if listWidth < 30 {
+		listWidth = 30
+	}
+	detailWidth := m.width - listWidth - 4
+	if detailWidth < 20 {
+		detailWidth = 20
+	}
+
+	body := lipgloss.JoinHorizontal(lipgloss.Top,
+		lipgloss.NewStyle().Width(listWidth).Render(m.viewList(listWidth)),
+		lipgloss.NewStyle().Width(detailWidth).PaddingLeft(2).Render(m.viewDetail(detailWidth-2)),
+	)
+
+	search := mutedStyle.Render("/ to search")
+	if m.screen == browseSearch || m.search.Value() != "" {
+		search = m.search.View()
+	}
+
+	footer := mutedStyle.Render("space: toggle · i/r/u: install/remove/upgrade · tab: scope · enter: review plan · q: quit")
+	if m.status != "" {
+		footer = lipgloss.NewStyle().Foreground(warningColor).Render(m.status)
+	}
+
+	return header + "\n" + search + "\n\n" + body + "\n" + footer
+}
+
+// markSymbols shows a queued operation in the list.
+var markSymbols = map[string]string{
+	manager.OpInstall: "+",
+	manager.OpRemove:  "-",
+	manager.OpUpgrade: "^",
+}
+
+func (m browseModel) viewList(width int) string {
+	if len(m.visible) == 0 {
+		return mutedStyle.Render("No packages match.")
+	}
+
+	var b strings.Builder
+	end := m.offset + m.listHeight()
+	if end > len(m.visible) {
+		end = len(m.visible)
+	}
+	for i := m.offset; i < end; i++ {
+		e := m.entries[m.visible[i]]
+
+		mark := " "
+		if op := m.marks[e.name]; op != "" {
+			mark = markSymbols[op]
+		}
+		state := ""
+		switch {
+		case e.upgrade != nil:
+			state = "↑"
+		case e.installed != "":
+			state = "*"
+		}
+
+		line := fmt.Sprintf("[%s] %-1s %s", mark, state, e.name)
+		if len(line) > width-1 {
+			line = line[:width-1]
+		}
+		switch {
+		case i == m.cursor:
+			line = selectedStyle.Render("> " + line)
+		case m.marks[e.name] != "":
+			line = "  " + subtitleStyle.UnsetMarginBottom().Render(line)
+		default:
+			line = "  " + line
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func (m browseModel) viewDetail(width int) string {
+	e := m.current()
+	if e == nil {
+		return ""
+	}
+	d := m.details[e.name]
+
+	var b strings.Builder
+	b.WriteString(selectedStyle.Render(e.name) + "\n")
+	if e.description != "" {
+		b.WriteString(lipgloss.NewStyle().Width(width).Render(e.description) + "\n")
+	}
+	b.WriteString("\n")
+
+	field := func(label, value string) {
+		b.WriteString(mutedStyle.Render(fmt.Sprintf("%-12s", label)) + value + "\n")
+	}
+	if e.installed != "" {
+		field("Installed", e.installed)
+	}
+	if e.available != "" {
+		field("Available", e.available)
+	}
+	if e.upgrade != nil {
+		field("Upgrade", e.upgrade.CurrentVersion+" -> "+e.upgrade.NewVersion)
+	}
+
+	if d == nil {
+		return b.String()
+	}
+	if d.err != nil {
+		b.WriteString(errorStyle.Render(d.err.Error()) + "\n")
+		return b.String()
+	}
+
+	if d.info.Size > 0 {
+		field("Size", manager.FormatSize(d.info.Size))
+	}
+	field("Depends", joinOrNone(d.info.Dependencies))
+	field("Required by", joinOrNone(d.rdeps))
+
+	if e.installed != "" {
+		b.WriteString("\n" + mutedStyle.Render(fmt.Sprintf("Files (%d)", len(d.files))) + "\n")
+		limit := m.listHeight() - 10
+		if limit < 3 {
+			limit = 3
+		}
+		for i, f := range d.files {
+			if i == limit {
+				b.WriteString(mutedStyle.Render(fmt.Sprintf("  ... and %d more", len(d.files)-limit)) + "\n")
+				break
+			}
+			b.WriteString("  " + f + "\n")
+		}
+	}
+	return b.String()
+}
+
+func (m browseModel) viewPlan() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Review plan") + "\n")
+
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		b.WriteString(subtitleStyle.UnsetMarginBottom().Render(fmt.Sprintf("%s (%d)", title, len(lines))) + "\n")
+		for _, line := range lines {
+			b.WriteString("  " + line + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	var upgrades []string
+	for _, u := range m.plan.upgrade {
+		upgrades = append(upgrades, fmt.Sprintf("%s (%s -> %s)", u.Name, u.CurrentVersion, u.NewVersion))
+	}
+	section("Install", m.plan.install)
+	section("Upgrade", upgrades)
+	section("Remove", m.plan.remove)
+
+	for _, w := range m.plan.warnings {
+		b.WriteString(lipgloss.NewStyle().Foreground(warningColor).Render("Warning: "+w) + "\n")
+	}
+
+	switch {
+	case m.plan.err != nil:
+		b.WriteString(errorStyle.Render(m.plan.err.Error()) + "\n")
+		b.WriteString(helpStyle.Render("esc: back to the list · q: quit"))
+	case m.plan.empty():
+		b.WriteString(mutedStyle.Render("Nothing to do: the queued packages are already installed.") + "\n")
+		b.WriteString(helpStyle.Render("esc: back to the list · q: quit"))
+	default:
+		b.WriteString(helpStyle.Render("y: apply · esc: back to the list · q: quit"))
+	}
+	return b.String()
+}
+
+func joinOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+func runTUI(cmd *cobra.Command, args []string) error {
+	if structuredOutput() || !terminalCapable(os.Stdout) {
+		return fmt.Errorf("mix tui needs an interactive terminal")
+	}
+
+	// Browse under a shared lock so other mix commands can run meanwhile
+	mgr, err := openManager(manager.SharedLock)
+	if err != nil {
+		return err
+	}
+	model, err := newBrowseModel(mgr)
+	if err != nil {
+		mgr.Close()
+		return err
+	}
+	final, err := tea.NewProgram(model, tea.WithAltScreen()).Run()
+	mgr.Close()
+	if err != nil {
+		return fmt.Errorf("package browser failed: %w", err)
+	}
+
+	m := final.(browseModel)
+	if !m.confirmed {
+		return nil
+	}
+	return applyBrowsePlan(cmd, m.plan)
+}
+
+// applyBrowsePlan applies a confirmed plan under the exclusive lock:
+// installs first, then upgrades, then removals.
+func applyBrowsePlan(cmd *cobra.Command, plan browsePlan) error {
+	mgr, err := openManager(manager.ExclusiveLock)
+	if err != nil {
+		return err
+	}
+	defer mgr.Close()
+
+	if len(plan.install) > 0 {
+		err := runTransaction(cmd, mgr, transaction{
+			op:       manager.OpInstall,
+			packages: plan.install,
+			apply:    installPackage(mgr, plan.requested),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.upgrade) > 0 {
+		names := make([]string, len(plan.upgrade))
+		details := make(map[string]string)
+		for i, pkg := range plan.upgrade {
+			names[i] = pkg.Name
+			details[pkg.Name] = pkg.CurrentVersion + " -> " + pkg.NewVersion
+		}
+		err := runTransaction(cmd, mgr, transaction{
+			op:       manager.OpUpgrade,
+			packages: names,
+			details:  details,
+			apply: func(ctx context.Context, pkg string) error {
+				return mgr.Upgrade(ctx, pkg)
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(plan.remove) > 0 {
+		return runTransaction(cmd, mgr, transaction{
+			op:       manager.OpRemove,
+			packages: plan.remove,
+			apply:    removePackage(mgr, false),
+		})
+	}
+	return nil
+}
@@ -1,16 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/mixos-go/src/mix-cli/pkg/manager"
 	"github.com/spf13/cobra"
-
-	"github.com/charmbracelet/bubbles/progress"
-	"github.com/charmbracelet/bubbles/spinner"
-	tea "github.com/charmbracelet/bubbletea"
-	"golang.org/x/term"
 )
 
 var updateCmd = &cobra.Command{
@@ -21,10 +17,11 @@ var updateCmd = &cobra.Command{
 }
 
 var upgradeCmd = &cobra.Command{
-	Use:   "upgrade [packages...]",
-	Short: "Upgrade packages",
-	Long:  `Upgrade installed packages to their latest versions.`,
-	RunE:  runUpgrade,
+	Use:               "upgrade [packages...]",
+	Short:             "Upgrade packages",
+	Long:              `Upgrade installed packages to their latest versions.`,
+	RunE:              runUpgrade,
+	ValidArgsFunction: completePackages(true),
 }
 
 func init() {
@@ -33,28 +30,82 @@ func init() {
 	upgradeCmd.Flags().BoolP("yes", "y", false, "assume yes to all prompts")
 }
 
+type upgradePlanOutput struct {
+	Upgrades []manager.PackageUpgrade `json:"upgrades"`
+	Warnings []string                 `json:"warnings,omitempty"`
+	Space    *manager.SpacePlan       `json:"space,omitempty"`
+	Applied  bool                     `json:"applied"`
+	Results  []operationResult        `json:"results,omitempty"`
+}
+
 func runUpdate(cmd *cobra.Command, args []string) error {
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.ExclusiveLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
-	fmt.Println("Updating package database...")
-	if err := mgr.UpdateDatabase(); err != nil {
+	if !structuredOutput() {
+		fmt.Println("Updating package database...")
+	}
+	stop := startEventLog(mgr)
+	summary, err := mgr.UpdateDatabase(cmd.Context())
+	stop()
+	if err != nil {
 		return fmt.Errorf("failed to update database: %w", err)
 	}
 
-	fmt.Println("Package database updated successfully!")
+	if structuredOutput() {
+		summary.Added = nonNil(summary.Added)
+		summary.Removed = nonNil(summary.Removed)
+		if summary.Updated == nil {
+			summary.Updated = []manager.PackageChange{}
+		}
+		return writeOutput("UpdateResult", summary)
+	}
+
+	printSyncSummary(summary)
 	return nil
 }
 
+// printSyncSummary reports what an index sync changed.
+func printSyncSummary(s *manager.SyncSummary) {
+	if s.NotModified {
+		fmt.Printf("Package index unchanged since the last update (%d packages).\n", s.Packages)
+	} else {
+		fmt.Printf("Package database updated: %d new, %d updated, %d removed (%d packages).\n",
+			len(s.Added), len(s.Updated), len(s.Removed), s.Packages)
+		if len(s.Added) > 0 {
+			fmt.Printf("  New:     %s\n", strings.Join(s.Added, ", "))
+		}
+		if len(s.Updated) > 0 {
+			updated := make([]string, len(s.Updated))
+			for i, c := range s.Updated {
+				updated[i] = fmt.Sprintf("%s (%s -> %s)", c.Name, c.OldVersion, c.NewVersion)
+			}
+			fmt.Printf("  Updated: %s\n", strings.Join(updated, ", "))
+		}
+		if len(s.Removed) > 0 {
+			fmt.Printf("  Removed: %s\n", strings.Join(s.Removed, ", "))
+		}
+	}
+	if s.Diffs > 0 {
+		printVerbose("Applied %d index diff(s), now at generation %d\n", s.Diffs, s.Generation)
+	} else if s.Generation > 0 {
+		printVerbose("Index generation %d\n", s.Generation)
+	}
+
+	if s.Upgradable > 0 {
+		fmt.Printf("%d installed package(s) can be upgraded; run 'mix upgrade' to see them.\n", s.Upgradable)
+	}
+}
+
 func runUpgrade(cmd *cobra.Command, args []string) error {
 	yes, _ := cmd.Flags().GetBool("yes")
 
-	mgr, err := manager.New(dbPath, repoURL, cacheDir)
+	mgr, err := openManager(manager.ExclusiveLock)
 	if err != nil {
-		return fmt.Errorf("failed to initialize package manager: %w", err)
+		return err
 	}
 	defer mgr.Close()
 
@@ -62,12 +113,13 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 	var toUpgrade []manager.PackageUpgrade
 	var checkErr error
 
+	var warnings []string
 	if len(args) > 0 {
 		// Upgrade specific packages
 		for _, pkg := range args {
 			upgrade, err := mgr.CheckUpgrade(pkg)
 			if err != nil {
-				fmt.Printf("Warning: %s: %v\n", pkg, err)
+				warnings = append(warnings, fmt.Sprintf("Warning: %s: %v", pkg, err))
 				continue
 			}
 			if upgrade != nil {
@@ -82,6 +134,45 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	names := make([]string, len(toUpgrade))
+	for i, pkg := range toUpgrade {
+		names[i] = pkg.Name
+	}
+	space, err := mgr.PlanSpace(manager.OpUpgrade, names)
+	if err != nil {
+		return fmt.Errorf("failed to work out the disk space needed: %w", err)
+	}
+
+	if structuredOutput() {
+		out := upgradePlanOutput{Upgrades: toUpgrade, Warnings: warnings, Space: space, Applied: yes}
+		if out.Upgrades == nil {
+			out.Upgrades = []manager.PackageUpgrade{}
+		}
+		var applyErr error
+		if yes {
+			if err := space.Check(); err != nil {
+				out.Applied = false
+				if werr := writeOutput("UpgradePlan", out); werr != nil {
+					return werr
+				}
+				return err
+			}
+			stop := startEventLog(mgr)
+			out.Results, applyErr = applyStructured(names, func(pkg string) error {
+				return mgr.Upgrade(cmd.Context(), pkg)
+			})
+			stop()
+		}
+		if err := writeOutput("UpgradePlan", out); err != nil {
+			return err
+		}
+		return applyErr
+	}
+
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+
 	if len(toUpgrade) == 0 {
 		fmt.Println("All packages are up to date.")
 		return nil
@@ -93,71 +184,30 @@ func runUpgrade(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  %s (%s -> %s)\n", pkg.Name, pkg.CurrentVersion, pkg.NewVersion)
 	}
 	fmt.Printf("\nTotal: %d package(s)\n", len(toUpgrade))
+	if err := printSpacePlan(space); err != nil {
+		return err
+	}
 
 	// Confirm upgrade
 	if !yes {
-		fmt.Print("\nProceed with upgrade? [y/N] ")
-		var response string
-		fmt.Scanln(&response)
-		if response != "y" && response != "Y" {
+		fmt.Println()
+		if !confirm(cmd.Context(), "Proceed with upgrade?") {
 			fmt.Println("Upgrade cancelled.")
 			return nil
 		}
 	}
 
-	// Perform upgrades (TUI if terminal)
-	if term.IsTerminal(int(os.Stdout.Fd())) {
-		ch := make(chan manager.ProgressUpdate)
-		errCh := make(chan error, 1)
-		mgr.SetProgressChan(ch)
-
-		go func() {
-			for _, pkg := range toUpgrade {
-				if err := mgr.Upgrade(pkg.Name); err != nil {
-					errCh <- fmt.Errorf("failed to upgrade %s: %w", pkg.Name, err)
-					close(ch)
-					return
-				}
-			}
-			close(ch)
-			errCh <- nil
-		}()
-
-		s := spinner.New()
-		s.Spinner = spinner.Line
-		pmod := progress.New(progress.WithDefaultGradient())
-		pmod.Width = 40
-
-		model := tuiModel{sp: s, prog: pmod, msg: "Starting...", ch: ch}
-		prg := tea.NewProgram(model)
-
-		if err := prg.Start(); err != nil {
-			// fallback to headless if UI fails
-			for _, pkg := range toUpgrade {
-				if err := mgr.Upgrade(pkg.Name); err != nil {
-					return fmt.Errorf("failed to upgrade %s: %w", pkg.Name, err)
-				}
-				fmt.Printf("  ✓ %s upgraded to %s\n", pkg.Name, pkg.NewVersion)
-			}
-		}
-
-		if err := <-errCh; err != nil {
-			return err
-		}
-
-		fmt.Println("\nUpgrade complete!")
-		return nil
-	}
-
-	// non-interactive upgrade
+	details := make(map[string]string)
 	for _, pkg := range toUpgrade {
-		fmt.Printf("Upgrading %s...\n", pkg.Name)
-		if err := mgr.Upgrade(pkg.Name); err != nil {
-			return fmt.Errorf("failed to upgrade %s: %w", pkg.Name, err)
-		}
-		fmt.Printf("  ✓ %s upgraded to %s\n", pkg.Name, pkg.NewVersion)
+		details[pkg.Name] = pkg.CurrentVersion + " -> " + pkg.NewVersion
 	}
 
-	fmt.Println("\nUpgrade complete!")
-	return nil
+	return runTransaction(cmd, mgr, transaction{
+		op:       manager.OpUpgrade,
+		packages: names,
+		details:  details,
+		apply: func(ctx context.Context, pkg string) error {
+			return mgr.Upgrade(ctx, pkg)
+		},
+	})
 }
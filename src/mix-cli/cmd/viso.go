@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/mixos-go/src/mix-cli/pkg/manager"
 	"github.com/spf13/cobra"
 )
 
@@ -23,16 +25,18 @@ CDROM/ISO formats. It provides:
   - SDISK boot mechanism
   - qcow2 format with compression
 
-VISO files use the .viso extension and can be booted with:
+VISO files use the .viso extension and can be booted with the QEMU
+system emulator for their architecture, e.g. on x86_64:
   qemu-system-x86_64 -drive file=image.viso,format=qcow2,if=virtio`,
 }
 
 var visoInfoCmd = &cobra.Command{
-	Use:   "info [viso-file]",
-	Short: "Show VISO image information",
-	Long:  `Display detailed information about a VISO image file.`,
-	Args:  cobra.MaximumNArgs(1),
-	RunE:  runVisoInfo,
+	Use:               "info [viso-file]",
+	Short:             "Show VISO image information",
+	Long:              `Display detailed information about a VISO image file.`,
+	Args:              cobra.MaximumNArgs(1),
+	RunE:              runVisoInfo,
+	ValidArgsFunction: completeVisoFile,
 }
 
 var visoListCmd = &cobra.Command{
@@ -45,9 +49,13 @@ var visoListCmd = &cobra.Command{
 var visoBootCmd = &cobra.Command{
 	Use:   "boot [viso-file]",
 	Short: "Show boot command for VISO",
-	Long:  `Display the QEMU command to boot a VISO image.`,
-	Args:  cobra.ExactArgs(1),
-	RunE:  runVisoBoot,
+	Long: `Display the QEMU command to boot a VISO image.
+
+The emulator matches the architecture of the image (see --arch). KVM is
+only used when that is the architecture of this machine.`,
+	Args:              cobra.ExactArgs(1),
+	RunE:              runVisoBoot,
+	ValidArgsFunction: completeVisoFile,
 }
 
 func init() {
@@ -59,6 +67,7 @@ func init() {
 	visoBootCmd.Flags().Bool("vram", false, "Enable VRAM mode")
 	visoBootCmd.Flags().String("memory", "2G", "Memory size")
 	visoBootCmd.Flags().Bool("kvm", true, "Enable KVM acceleration")
+	visoBootCmd.Flags().String("arch", "", "architecture of the image (default from its metadata, else this machine's)")
 }
 
 // VISO metadata structure
@@ -89,7 +98,133 @@ type VisoMetadata struct {
 	} `json:"requirements"`
 }
 
+type visoInfoOutput struct {
+	Path        string        `json:"path"`
+	Size        int64         `json:"size"`
+	Modified    string        `json:"modified"`
+	Metadata    *VisoMetadata `json:"metadata,omitempty"`
+	BootCommand []string      `json:"boot_command"`
+}
+
+type visoImage struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	Archive bool   `json:"archive"`
+}
+
+type visoListOutput struct {
+	Images []visoImage `json:"images"`
+}
+
+type visoBootOutput struct {
+	Path    string   `json:"path"`
+	VRAM    bool     `json:"vram"`
+	Command []string `json:"command"`
+}
+
+// readVisoMetadata loads config/viso.json next to a VISO image, if present.
+func readVisoMetadata(visoPath string) *VisoMetadata {
+	metadataPath := filepath.Join(filepath.Dir(visoPath), "config", "viso.json")
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return nil
+	}
+	var metadata VisoMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil
+	}
+	return &metadata
+}
+
+// qemuArches maps package architectures to QEMU system emulator names
+// where the two differ.
+var qemuArches = map[string]string{
+	"i686":    "i386",
+	"armv7":   "arm",
+	"ppc64le": "ppc64",
+}
+
+// qemuConsoles holds the serial console of architectures whose QEMU
+// machine does not provide ttyS0.
+var qemuConsoles = map[string]string{
+	"aarch64": "ttyAMA0",
+	"armv7":   "ttyAMA0",
+	"ppc64le": "hvc0",
+	"s390x":   "ttysclp0",
+}
+
+// qemuSystem returns the QEMU system emulator for arch.
+func qemuSystem(arch string) string {
+	if name, ok := qemuArches[arch]; ok {
+		return "qemu-system-" + name
+	}
+	return "qemu-system-" + arch
+}
+
+// visoArch returns the architecture a VISO image is booted as: the one
+// in its metadata, or else the one of this machine.
+func visoArch(metadata *VisoMetadata) string {
+	if metadata != nil && metadata.Requirements.Arch != "" {
+		return metadata.Requirements.Arch
+	}
+	return manager.NativeArch()
+}
+
+// visoBootArgs returns the QEMU command line that boots a VISO image
+// built for arch. KVM is left out unless arch is the native one.
+func visoBootArgs(visoPath, arch, memory string, kvm, vram bool) []string {
+	args := []string{
+		qemuSystem(arch),
+		"-drive", fmt.Sprintf("file=%s,format=qcow2,if=virtio,cache=writeback,aio=threads", visoPath),
+		"-m", memory,
+	}
+	switch arch {
+	case "aarch64", "armv7", "riscv64":
+		args = append(args, "-machine", "virt")
+	}
+	if kvm && arch == manager.NativeArch() {
+		args = append(args, "-cpu", "host", "-enable-kvm")
+	} else if arch == "aarch64" {
+		// The default CPU of the virt machine is 32-bit
+		args = append(args, "-cpu", "max")
+	}
+
+	// Build kernel append line
+	console := "ttyS0"
+	if c, ok := qemuConsoles[arch]; ok {
+		console = c
+	}
+	appendParts := []string{"console=" + console}
+	if vram {
+		appendParts = append(appendParts, "VRAM=auto")
+	}
+
+	// Get VISO name for SDISK
+	visoName := strings.TrimSuffix(filepath.Base(visoPath), ".viso")
+	appendParts = append(appendParts, fmt.Sprintf("SDISK=%s.VISO", visoName))
+
+	return append(args, "-append", strings.Join(appendParts, " "), "-nographic")
+}
+
 func runVisoInfo(cmd *cobra.Command, args []string) error {
+	if structuredOutput() {
+		if len(args) == 0 {
+			return fmt.Errorf("a VISO file is required with --output %s", outputFormat)
+		}
+		info, err := os.Stat(args[0])
+		if err != nil {
+			return fmt.Errorf("VISO file not found: %s", args[0])
+		}
+		metadata := readVisoMetadata(args[0])
+		return writeOutput("VisoInfo", visoInfoOutput{
+			Path:        args[0],
+			Size:        info.Size(),
+			Modified:    info.ModTime().Format(time.RFC3339),
+			Metadata:    metadata,
+			BootCommand: visoBootArgs(args[0], visoArch(metadata), "2G", true, false),
+		})
+	}
+
 	fmt.Println("")
 	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║              VISO - Virtual ISO Format                       ║")
@@ -153,48 +288,40 @@ func runVisoInfo(cmd *cobra.Command, args []string) error {
 	fmt.Println("")
 
 	// Try to read metadata if it's a directory or mounted
-	metadataPath := filepath.Join(filepath.Dir(visoPath), "config", "viso.json")
-	if data, err := os.ReadFile(metadataPath); err == nil {
-		var metadata VisoMetadata
-		if err := json.Unmarshal(data, &metadata); err == nil {
-			fmt.Println("Metadata:")
-			fmt.Println("=========")
-			fmt.Printf("  Name:    %s\n", metadata.Name)
-			fmt.Printf("  Version: %s\n", metadata.Version)
-			fmt.Printf("  Format:  %s\n", metadata.Format)
-			fmt.Printf("  Created: %s\n", metadata.Created)
-			fmt.Println("")
-
-			fmt.Println("Features:")
-			fmt.Printf("  VRAM Support:     %v\n", metadata.Features.VramSupport)
-			fmt.Printf("  SDISK Boot:       %v\n", metadata.Features.SdiskBoot)
-			fmt.Printf("  Virtio Optimized: %v\n", metadata.Features.VirtioOptimized)
-			fmt.Println("")
-
-			fmt.Println("Requirements:")
-			fmt.Printf("  Min RAM:      %d MB\n", metadata.Requirements.MinRamMB)
-			fmt.Printf("  VRAM Min RAM: %d MB\n", metadata.Requirements.VramMinRamMB)
-			fmt.Printf("  Architecture: %s\n", metadata.Requirements.Arch)
-		}
+	metadata := readVisoMetadata(visoPath)
+	if metadata != nil {
+		fmt.Println("Metadata:")
+		fmt.Println("=========")
+		fmt.Printf("  Name:    %s\n", metadata.Name)
+		fmt.Printf("  Version: %s\n", metadata.Version)
+		fmt.Printf("  Format:  %s\n", metadata.Format)
+		fmt.Printf("  Created: %s\n", metadata.Created)
+		fmt.Println("")
+
+		fmt.Println("Features:")
+		fmt.Printf("  VRAM Support:     %v\n", metadata.Features.VramSupport)
+		fmt.Printf("  SDISK Boot:       %v\n", metadata.Features.SdiskBoot)
+		fmt.Printf("  Virtio Optimized: %v\n", metadata.Features.VirtioOptimized)
+		fmt.Println("")
+
+		fmt.Println("Requirements:")
+		fmt.Printf("  Min RAM:      %d MB\n", metadata.Requirements.MinRamMB)
+		fmt.Printf("  VRAM Min RAM: %d MB\n", metadata.Requirements.VramMinRamMB)
+		fmt.Printf("  Architecture: %s\n", metadata.Requirements.Arch)
 	}
 
 	fmt.Println("")
 	fmt.Println("Boot Command:")
 	fmt.Println("=============")
-	fmt.Printf("  qemu-system-x86_64 \\\n")
-	fmt.Printf("    -drive file=%s,format=qcow2,if=virtio,cache=writeback,aio=threads \\\n", visoPath)
-	fmt.Printf("    -m 2G -cpu host -enable-kvm\n")
+	printBootCommand(visoBootArgs(visoPath, visoArch(metadata), "2G", true, false), "  ")
 	fmt.Println("")
 
 	return nil
 }
 
-func runVisoList(cmd *cobra.Command, args []string) error {
-	fmt.Println("")
-	fmt.Println("Available VISO Images:")
-	fmt.Println("======================")
-	fmt.Println("")
-
+// findVisoImages looks for VISO images and archives in the default
+// locations.
+func findVisoImages() []visoImage {
 	// Search locations
 	searchPaths := []string{
 		".",
@@ -203,39 +330,51 @@ func runVisoList(cmd *cobra.Command, args []string) error {
 		os.Getenv("HOME") + "/mixos",
 	}
 
-	found := false
+	images := []visoImage{}
 	for _, searchPath := range searchPaths {
-		files, err := filepath.Glob(filepath.Join(searchPath, "*.viso"))
-		if err != nil {
-			continue
-		}
-
-		for _, file := range files {
-			info, err := os.Stat(file)
+		for _, pattern := range []string{"*.viso", "*.viso.tar.gz"} {
+			files, err := filepath.Glob(filepath.Join(searchPath, pattern))
 			if err != nil {
 				continue
 			}
 
-			found = true
-			sizeMB := float64(info.Size()) / (1024 * 1024)
-			fmt.Printf("  %s (%.2f MB)\n", file, sizeMB)
+			for _, file := range files {
+				info, err := os.Stat(file)
+				if err != nil {
+					continue
+				}
+				images = append(images, visoImage{
+					Path:    file,
+					Size:    info.Size(),
+					Archive: strings.HasSuffix(file, ".tar.gz"),
+				})
+			}
 		}
+	}
+	return images
+}
 
-		// Also check for .viso.tar.gz
-		files, _ = filepath.Glob(filepath.Join(searchPath, "*.viso.tar.gz"))
-		for _, file := range files {
-			info, err := os.Stat(file)
-			if err != nil {
-				continue
-			}
+func runVisoList(cmd *cobra.Command, args []string) error {
+	images := findVisoImages()
+	if structuredOutput() {
+		return writeOutput("VisoList", visoListOutput{Images: images})
+	}
 
-			found = true
-			sizeMB := float64(info.Size()) / (1024 * 1024)
-			fmt.Printf("  %s (%.2f MB) [archive]\n", file, sizeMB)
+	fmt.Println("")
+	fmt.Println("Available VISO Images:")
+	fmt.Println("======================")
+	fmt.Println("")
+
+	for _, image := range images {
+		sizeMB := float64(image.Size) / (1024 * 1024)
+		if image.Archive {
+			fmt.Printf("  %s (%.2f MB) [archive]\n", image.Path, sizeMB)
+		} else {
+			fmt.Printf("  %s (%.2f MB)\n", image.Path, sizeMB)
 		}
 	}
 
-	if !found {
+	if len(images) == 0 {
 		fmt.Println("  No VISO images found.")
 		fmt.Println("")
 		fmt.Println("  Build a VISO image with: make viso")
@@ -250,57 +389,62 @@ func runVisoBoot(cmd *cobra.Command, args []string) error {
 	vramMode, _ := cmd.Flags().GetBool("vram")
 	memory, _ := cmd.Flags().GetString("memory")
 	kvmEnabled, _ := cmd.Flags().GetBool("kvm")
+	arch, _ := cmd.Flags().GetString("arch")
 
 	// Check if file exists
 	if _, err := os.Stat(visoPath); err != nil {
 		return fmt.Errorf("VISO file not found: %s", visoPath)
 	}
 
+	if arch == "" {
+		arch = visoArch(readVisoMetadata(visoPath))
+	}
+	bootArgs := visoBootArgs(visoPath, arch, memory, kvmEnabled, vramMode)
+	if structuredOutput() {
+		return writeOutput("VisoBootCommand", visoBootOutput{Path: visoPath, VRAM: vramMode, Command: bootArgs})
+	}
+
 	fmt.Println("")
 	fmt.Println("QEMU Boot Command:")
 	fmt.Println("==================")
 	fmt.Println("")
 
-	var cmdParts []string
-	cmdParts = append(cmdParts, "qemu-system-x86_64")
-	cmdParts = append(cmdParts, fmt.Sprintf("  -drive file=%s,format=qcow2,if=virtio,cache=writeback,aio=threads", visoPath))
-	cmdParts = append(cmdParts, fmt.Sprintf("  -m %s", memory))
+	printBootCommand(bootArgs, "")
 
-	if kvmEnabled {
-		cmdParts = append(cmdParts, "  -cpu host")
-		cmdParts = append(cmdParts, "  -enable-kvm")
-	}
+	fmt.Println("")
 
-	// Build kernel append line
-	appendParts := []string{"console=ttyS0"}
 	if vramMode {
-		appendParts = append(appendParts, "VRAM=auto")
+		fmt.Println("Note: VRAM mode enabled - system will run from RAM")
+		fmt.Println("      Requires minimum 2GB RAM (4GB recommended)")
 	}
 
-	// Get VISO name for SDISK
-	visoName := filepath.Base(visoPath)
-	visoName = strings.TrimSuffix(visoName, ".viso")
-	appendParts = append(appendParts, fmt.Sprintf("SDISK=%s.VISO", visoName))
+	fmt.Println("")
+	return nil
+}
 
-	cmdParts = append(cmdParts, fmt.Sprintf("  -append \"%s\"", strings.Join(appendParts, " ")))
-	cmdParts = append(cmdParts, "  -nographic")
+// printBootCommand prints a QEMU command line with one option per line,
+// quoting values that contain spaces, so it can be pasted into a shell.
+func printBootCommand(bootArgs []string, indent string) {
+	var cmdParts []string
+	cmdParts = append(cmdParts, bootArgs[0])
+	for i := 1; i < len(bootArgs); i++ {
+		part := "  " + bootArgs[i]
+		if strings.HasPrefix(bootArgs[i], "-") && i+1 < len(bootArgs) && !strings.HasPrefix(bootArgs[i+1], "-") {
+			i++
+			if strings.Contains(bootArgs[i], " ") {
+				part += fmt.Sprintf(" \"%s\"", bootArgs[i])
+			} else {
+				part += " " + bootArgs[i]
+			}
+		}
+		cmdParts = append(cmdParts, part)
+	}
 
-	// Print command
 	for i, part := range cmdParts {
 		if i < len(cmdParts)-1 {
-			fmt.Printf("%s \\\n", part)
+			fmt.Printf("%s%s \\\n", indent, part)
 		} else {
-			fmt.Println(part)
+			fmt.Println(indent + part)
 		}
 	}
-
-	fmt.Println("")
-
-	if vramMode {
-		fmt.Println("Note: VRAM mode enabled - system will run from RAM")
-		fmt.Println("      Requires minimum 2GB RAM (4GB recommended)")
-	}
-
-	fmt.Println("")
-	return nil
 }
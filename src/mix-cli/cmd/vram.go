@@ -66,14 +66,48 @@ func init() {
 }
 
 // Memory information structure
+// (all values in MB)
 type MemInfo struct {
-	MemTotal     int64
-	MemFree      int64
-	MemAvailable int64
-	Buffers      int64
-	Cached       int64
-	SwapTotal    int64
-	SwapFree     int64
+	MemTotal     int64 `json:"total_mb"`
+	MemFree      int64 `json:"free_mb"`
+	MemAvailable int64 `json:"available_mb"`
+	Buffers      int64 `json:"buffers_mb"`
+	Cached       int64 `json:"cached_mb"`
+	SwapTotal    int64 `json:"swap_total_mb"`
+	SwapFree     int64 `json:"swap_free_mb"`
+}
+
+type vramStatusOutput struct {
+	Active     bool     `json:"active"`
+	SizeMB     int64    `json:"size_mb,omitempty"`
+	Memory     *MemInfo `json:"memory"`
+	Capable    bool     `json:"capable"`
+	Capability string   `json:"capability"`
+	Enabled    bool     `json:"enabled_next_boot"`
+}
+
+// vramStatus collects the state reported by 'mix vram status'.
+func vramStatus() (*vramStatusOutput, error) {
+	info, err := getMemInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory info: %w", err)
+	}
+
+	out := &vramStatusOutput{Active: isVramActive(), Memory: info}
+	if out.Active {
+		if data, err := os.ReadFile("/run/initramfs/vram-size"); err == nil {
+			out.SizeMB, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		}
+	}
+	out.Capable, out.Capability = checkVramCapability()
+	_, err = os.Stat("/etc/mixos/vram-enabled")
+	out.Enabled = err == nil
+	return out, nil
+}
+
+type vramConfigOutput struct {
+	EnabledNextBoot bool   `json:"enabled_next_boot"`
+	Parameter       string `json:"parameter,omitempty"`
 }
 
 // Get memory information from /proc/meminfo
@@ -156,6 +190,14 @@ func checkVramCapability() (bool, string) {
 }
 
 func runVramStatus(cmd *cobra.Command, args []string) error {
+	if structuredOutput() {
+		out, err := vramStatus()
+		if err != nil {
+			return err
+		}
+		return writeOutput("VramStatus", out)
+	}
+
 	fmt.Println("")
 	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║                    VRAM Status                               ║")
@@ -211,6 +253,14 @@ func runVramEnable(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("cannot enable VRAM: %s", msg)
 	}
 
+	if structuredOutput() {
+		os.MkdirAll("/etc/mixos", 0755)
+		if err := os.WriteFile("/etc/mixos/vram-enabled", []byte("auto\n"), 0644); err != nil {
+			return fmt.Errorf("failed to enable VRAM: %w", err)
+		}
+		return writeOutput("VramConfig", vramConfigOutput{EnabledNextBoot: true, Parameter: "VRAM=auto"})
+	}
+
 	fmt.Println("Enabling VRAM mode for next boot...")
 
 	// Update GRUB/bootloader configuration
@@ -247,6 +297,11 @@ func runVramEnable(cmd *cobra.Command, args []string) error {
 }
 
 func runVramDisable(cmd *cobra.Command, args []string) error {
+	if structuredOutput() {
+		os.Remove("/etc/mixos/vram-enabled")
+		return writeOutput("VramConfig", vramConfigOutput{EnabledNextBoot: false})
+	}
+
 	fmt.Println("Disabling VRAM mode...")
 
 	// Remove VRAM flag file
@@ -261,6 +316,14 @@ func runVramDisable(cmd *cobra.Command, args []string) error {
 }
 
 func runVramInfo(cmd *cobra.Command, args []string) error {
+	if structuredOutput() {
+		out, err := vramStatus()
+		if err != nil {
+			return err
+		}
+		return writeOutput("VramStatus", out)
+	}
+
 	fmt.Println("")
 	fmt.Println("╔══════════════════════════════════════════════════════════════╗")
 	fmt.Println("║              VRAM - Virtual RAM Mode                         ║")
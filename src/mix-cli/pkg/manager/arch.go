@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// NoArch marks packages that run on every architecture, such as scripts
+// and data. Index entries without an arch are treated the same way.
+const NoArch = "noarch"
+
+// ErrArchMismatch is returned when a package is built for an architecture
+// the system does not run.
+var ErrArchMismatch = errors.New("architecture mismatch")
+
+// goArches maps Go architecture names to the names used in packages.
+var goArches = map[string]string{
+	"amd64":   "x86_64",
+	"386":     "i686",
+	"arm64":   "aarch64",
+	"arm":     "armv7",
+	"riscv64": "riscv64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// NativeArch returns the package architecture of the running mix binary.
+func NativeArch() string {
+	if arch, ok := goArches[runtime.GOARCH]; ok {
+		return arch
+	}
+	return runtime.GOARCH
+}
+
+// Arches is the set of architectures packages are installed for: the
+// native one and, in order of preference, foreign ones the system can
+// also run (for example through binfmt emulation).
+type Arches struct {
+	Native  string
+	Foreign []string
+}
+
+// rank orders the architectures packages are chosen from: the native
+// architecture first, then noarch, then foreign architectures in the
+// configured order. It returns -1 for architectures the system does not run.
+func (a Arches) rank(arch string) int {
+	switch arch {
+	case a.Native:
+		return 0
+	case "", NoArch:
+		return 1
+	}
+	for i, foreign := range a.Foreign {
+		if arch == foreign {
+			return 2 + i
+		}
+	}
+	return -1
+}
+
+// Accepts reports whether packages built for arch can be installed.
+func (a Arches) Accepts(arch string) bool {
+	return a.rank(arch) >= 0
+}
+
+// String lists the architectures, native first.
+func (a Arches) String() string {
+	return strings.Join(append([]string{a.Native}, a.Foreign...), ",")
+}
+
+// check returns an ErrArchMismatch error if name, built for arch, cannot
+// be installed.
+func (a Arches) check(name, arch string) error {
+	if a.Accepts(arch) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s is built for %s, this system runs %s", ErrArchMismatch, name, arch, a)
+}
+
+// selectArches reduces an index that may list a package for several
+// architectures to the entry to use for each package, preferring
+// architectures by rank. Packages with no entry the system can run are
+// left out of selected; their entries are returned as foreign, for
+// installs that override the architecture check. Both are sorted by name.
+func (a Arches) selectArches(packages []PackageInfo) (selected, foreign []PackageInfo) {
+	best := make(map[string]PackageInfo)
+	for _, pkg := range packages {
+		rank := a.rank(pkg.Arch)
+		if rank < 0 {
+			continue
+		}
+		if current, ok := best[pkg.Name]; ok && a.rank(current.Arch) <= rank {
+			continue
+		}
+		best[pkg.Name] = pkg
+	}
+
+	selected = make([]PackageInfo, 0, len(best))
+	for _, pkg := range best {
+		selected = append(selected, pkg)
+	}
+	for _, pkg := range packages {
+		if _, ok := best[pkg.Name]; !ok {
+			foreign = append(foreign, pkg)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+	sort.SliceStable(foreign, func(i, j int) bool { return foreign[i].Name < foreign[j].Name })
+	return selected, foreign
+}
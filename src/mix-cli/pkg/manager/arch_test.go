@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSelectArches(t *testing.T) {
+	arches := Arches{Native: "x86_64", Foreign: []string{"i686"}}
+
+	selected, foreign := arches.selectArches([]PackageInfo{
+		{Name: "curl", Version: "8.0", Arch: "aarch64"},
+		{Name: "curl", Version: "8.0", Arch: "x86_64"},
+		{Name: "docs", Version: "1.0", Arch: NoArch},
+		{Name: "legacy", Version: "1.0"},
+		{Name: "wine", Version: "9.0", Arch: "i686"},
+		{Name: "wine", Version: "9.0", Arch: "aarch64"},
+		{Name: "firmware", Version: "1.0", Arch: "riscv64"},
+		{Name: "firmware", Version: "1.0", Arch: "aarch64"},
+	})
+
+	var got []string
+	for _, pkg := range selected {
+		got = append(got, indexKey(pkg.Name, pkg.Arch))
+	}
+	if want := "[curl:x86_64 docs:noarch legacy wine:i686]"; fmt.Sprint(got) != want {
+		t.Errorf("Selected %v, want %s", got, want)
+	}
+	got = nil
+	for _, pkg := range foreign {
+		got = append(got, indexKey(pkg.Name, pkg.Arch))
+	}
+	if want := "[firmware:riscv64 firmware:aarch64]"; fmt.Sprint(got) != want {
+		t.Errorf("Foreign %v, want %s", got, want)
+	}
+
+	for arch, ok := range map[string]bool{"x86_64": true, "": true, NoArch: true, "i686": true, "aarch64": false} {
+		if arches.Accepts(arch) != ok {
+			t.Errorf("Accepts(%q) = %v, want %v", arch, !ok, ok)
+		}
+	}
+}
+
+func TestMultiArchSyncAndInstall(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "tool", Version: "1.0", Arch: "aarch64"},
+		map[string]string{"/usr/bin/tool": "arm"})
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "emu", Version: "1.0", Arch: "aarch64"},
+		map[string]string{"/usr/bin/emu": "arm"})
+	writeIndex := func() {
+		t.Helper()
+		index, err := BuildIndex(repoDir)
+		if err != nil {
+			t.Fatalf("BuildIndex failed: %v", err)
+		}
+		if _, err := WriteIndexV2(repoDir, index, IndexOptions{}); err != nil {
+			t.Fatalf("WriteIndexV2 failed: %v", err)
+		}
+	}
+	writeIndex()
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "file://"+repoDir, filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+	mgr.SetArches(Arches{Native: "x86_64", Foreign: []string{"aarch64"}})
+
+	if _, err := mgr.UpdateDatabase(context.Background()); err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if pkg, err := mgr.db.GetPackage("tool"); err != nil || pkg.Arch != "aarch64" {
+		t.Fatalf("Expected the foreign tool entry, got %+v, %v", pkg, err)
+	}
+
+	// A native build appears and replaces the foreign one through a diff
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "tool", Version: "1.0", Arch: "x86_64"},
+		map[string]string{"/usr/bin/tool": "x86"})
+	writeIndex()
+	summary, err := mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if summary.Diffs != 1 {
+		t.Errorf("Expected the change to arrive as a diff, got %+v", summary)
+	}
+	if pkg, err := mgr.db.GetPackage("tool"); err != nil || pkg.Arch != "x86_64" {
+		t.Fatalf("Expected the native tool entry, got %+v, %v", pkg, err)
+	}
+
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "cache", "tool-1.0-x86_64.mixpkg")); err != nil {
+		t.Errorf("Expected the cached file to carry the arch: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "root/usr/bin/tool")); string(data) != "x86" {
+		t.Errorf("Expected the native build to be installed, got %q", data)
+	}
+
+	// Without aarch64 as a foreign arch, emu is no longer available
+	mgr.SetArches(Arches{Native: "x86_64"})
+	summary, err = mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if summary.Diffs != 0 || fmt.Sprint(summary.Removed) != "[emu]" {
+		t.Errorf("Expected a full sync dropping emu, got %+v", summary)
+	}
+
+	// but its entry is kept for installs that override the arch check
+	if _, err := mgr.ResolveDependencies([]string{"emu"}); !errors.Is(err, ErrArchMismatch) {
+		t.Errorf("Expected ErrArchMismatch resolving emu, got %v", err)
+	}
+	if err := mgr.Install(context.Background(), "emu"); !errors.Is(err, ErrArchMismatch) {
+		t.Errorf("Expected ErrArchMismatch installing emu, got %v", err)
+	}
+	mgr.SetForceArch(true)
+	if order, err := mgr.ResolveDependencies([]string{"emu"}); err != nil || fmt.Sprint(order) != "[emu]" {
+		t.Errorf("Expected a forced resolution of emu, got %v, %v", order, err)
+	}
+	if err := mgr.Install(context.Background(), "emu"); err != nil {
+		t.Fatalf("Expected a forced install of emu to succeed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "root/usr/bin/emu")); string(data) != "arm" {
+		t.Errorf("Expected the foreign emu build to be installed, got %q", data)
+	}
+	mgr.SetForceArch(false)
+
+	// A local file for another arch is refused unless forced
+	local := writeTestPackage(t, filepath.Join(tmpDir, "local"), &PackageMetadata{Name: "armonly", Version: "1.0", Arch: "aarch64"},
+		map[string]string{"/usr/bin/armonly": "arm"})
+	if _, err := mgr.AddLocalPackage(local); err != nil {
+		t.Fatalf("AddLocalPackage failed: %v", err)
+	}
+	if _, err := mgr.ResolveDependencies([]string{"armonly"}); !errors.Is(err, ErrArchMismatch) {
+		t.Errorf("Expected ErrArchMismatch from resolution, got %v", err)
+	}
+	if err := mgr.Install(context.Background(), "armonly"); !errors.Is(err, ErrArchMismatch) {
+		t.Errorf("Expected ErrArchMismatch from install, got %v", err)
+	}
+	mgr.SetForceArch(true)
+	if err := mgr.Install(context.Background(), "armonly"); err != nil {
+		t.Errorf("Expected a forced install to succeed: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultConfigPath is where mix looks for its configuration file.
+const DefaultConfigPath = "/etc/mix/config.json"
+
+// Config is the mix configuration file. Command line flags take
+// precedence over values set here.
+type Config struct {
+	// Repo is the default repository URL.
+	Repo string `json:"repo,omitempty"`
+	// Arch is the native package architecture; empty means NativeArch.
+	Arch string `json:"arch,omitempty"`
+	// ForeignArches lists other architectures whose packages the system
+	// can run, in order of preference.
+	ForeignArches []string `json:"foreign_arches,omitempty"`
+	// HTTP configures access to http(s) repositories.
+	HTTP HTTPConfig `json:"http"`
+}
+
+// LoadConfig reads the configuration file at path. A missing file yields
+// an empty configuration.
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
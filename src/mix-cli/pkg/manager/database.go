@@ -3,62 +3,45 @@ package manager
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"sort"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Database struct {
 	db *sql.DB
+	// readOnly is set while only a shared lock is held: searches then do
+	// not rebuild the full-text index.
+	readOnly bool
 }
 
+// NewDatabase opens the database at path and brings its schema up to date.
 func NewDatabase(path string) (*Database, error) {
-	db, err := sql.Open("sqlite3", path)
+	d, err := OpenDatabase(path)
 	if err != nil {
 		return nil, err
 	}
 
-	d := &Database{db: db}
-	if err := d.init(); err != nil {
-		db.Close()
+	if _, err := d.Migrate(); err != nil {
+		d.Close()
 		return nil, err
 	}
 
 	return d, nil
 }
 
-func (d *Database) init() error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS packages (
-		name TEXT PRIMARY KEY,
-		version TEXT NOT NULL,
-		description TEXT,
-		dependencies TEXT,
-		files TEXT,
-		checksum TEXT,
-		size INTEGER DEFAULT 0
-	);
-
-	CREATE TABLE IF NOT EXISTS installed (
-		name TEXT PRIMARY KEY,
-		version TEXT NOT NULL,
-		install_time DATETIME DEFAULT CURRENT_TIMESTAMP,
-		files TEXT
-	);
-
-	CREATE TABLE IF NOT EXISTS files (
-		path TEXT PRIMARY KEY,
-		package TEXT NOT NULL,
-		FOREIGN KEY (package) REFERENCES installed(name)
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_files_package ON files(package);
-	CREATE INDEX IF NOT EXISTS idx_packages_name ON packages(name);
-	`
-
-	_, err := d.db.Exec(schema)
-	return err
+// OpenDatabase opens the database at path without migrating it, for
+// inspecting the schema state.
+func OpenDatabase(path string) (*Database, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{db: db}, nil
 }
 
 func (d *Database) Close() error {
@@ -66,53 +49,474 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) AddPackage(pkg *PackageInfo) error {
-	deps, _ := json.Marshal(pkg.Dependencies)
-	files, _ := json.Marshal(pkg.Files)
+	return d.AddPackages([]PackageInfo{*pkg})
+}
+
+// AddPackages records repository packages, replacing existing entries of
+// the same name, in a single transaction.
+func (d *Database) AddPackages(pkgs []PackageInfo) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addPackages(tx, pkgs); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addPackages(tx *sql.Tx, pkgs []PackageInfo) error {
+	if err := invalidateSearchIndex(tx); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT OR REPLACE INTO packages (name, version, description, checksum, size,
+			arch, license, homepage, maintainer, installed_size, build_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, pkg := range pkgs {
+		_, err := stmt.Exec(pkg.Name, pkg.Version, pkg.Description, pkg.Checksum, pkg.Size,
+			pkg.Arch, pkg.License, pkg.Homepage, pkg.Maintainer, pkg.InstalledSize, pkg.BuildDate)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		if err := writePackageLists(tx, pkg.Name, pkg.Dependencies, pkg.Files); err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		if err := writePackageHashes(tx, pkg.Name, pkg.Hashes); err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+	}
+	return nil
+}
+
+// PackageChange is a repository package whose version changed.
+type PackageChange struct {
+	Name       string `json:"name"`
+	OldVersion string `json:"old_version"`
+	NewVersion string `json:"new_version"`
+}
 
-	_, err := d.db.Exec(`
-		INSERT OR REPLACE INTO packages (name, version, description, dependencies, files, checksum, size)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`, pkg.Name, pkg.Version, pkg.Description, string(deps), string(files), pkg.Checksum, pkg.Size)
+// IndexChanges lists how ReplacePackages changed the repository packages.
+type IndexChanges struct {
+	Added   []string
+	Updated []PackageChange
+	Removed []string
+}
+
+// SyncState is what a sync records about the repository index it came
+// from.
+type SyncState struct {
+	// Validators of the full index, for conditional requests
+	Validators Validators
+	// Generation of the v2 index, 0 for a v1 index
+	Generation int64
+	// Arches the index entries were selected for, see Arches.String
+	Arches string
+}
+
+// ReplacePackages makes pkgs the complete set of repository packages and
+// records state as the sync state of repository's index, in one
+// transaction: either the whole index is swapped in or nothing changes.
+// Packages no longer listed are pruned; installed packages are left alone.
+// foreign replaces the entries kept for packages built only for
+// architectures the system does not run.
+func (d *Database) ReplacePackages(pkgs, foreign []PackageInfo, repository string, state SyncState) (*IndexChanges, error) {
+	return d.syncPackages(pkgs, foreign, nil, true, repository, state)
+}
+
+// ApplyPackageChanges updates the repository packages from index diffs:
+// changed entries are added or replaced and removed names are pruned, and
+// state is recorded as repository's sync state, in one transaction. The
+// foreign entries of changed or removed names are replaced by foreign.
+func (d *Database) ApplyPackageChanges(changed, foreign []PackageInfo, removed []string, repository string, state SyncState) (*IndexChanges, error) {
+	return d.syncPackages(changed, foreign, removed, false, repository, state)
+}
+
+// syncPackages writes pkgs and foreign and prunes removed, or with replace
+// every package not in pkgs, and records the sync state of repository.
+func (d *Database) syncPackages(pkgs, foreign []PackageInfo, removed []string, replace bool, repository string, state SyncState) (*IndexChanges, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old := make(map[string]string)
+	rows, err := tx.Query(`SELECT name, version FROM packages`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		old[name] = version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	changes := &IndexChanges{}
+	for _, pkg := range pkgs {
+		version, ok := old[pkg.Name]
+		switch {
+		case !ok:
+			changes.Added = append(changes.Added, pkg.Name)
+		case version != pkg.Version:
+			changes.Updated = append(changes.Updated, PackageChange{Name: pkg.Name, OldVersion: version, NewVersion: pkg.Version})
+		}
+		delete(old, pkg.Name)
+	}
+	if replace {
+		for name := range old {
+			changes.Removed = append(changes.Removed, name)
+		}
+	} else {
+		for _, name := range removed {
+			if _, ok := old[name]; ok {
+				changes.Removed = append(changes.Removed, name)
+			}
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Slice(changes.Updated, func(i, j int) bool { return changes.Updated[i].Name < changes.Updated[j].Name })
+	sort.Strings(changes.Removed)
+
+	for _, name := range changes.Removed {
+		for _, query := range []string{
+			`DELETE FROM packages WHERE name = ?`,
+			`DELETE FROM package_deps WHERE package = ?`,
+			`DELETE FROM package_files WHERE package = ?`,
+			`DELETE FROM package_hashes WHERE package = ?`,
+		} {
+			if _, err := tx.Exec(query, name); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
 
+	if err := addPackages(tx, pkgs); err != nil {
+		return nil, err
+	}
+	if err := syncForeignPackages(tx, pkgs, foreign, removed, replace); err != nil {
+		return nil, err
+	}
+
+	// Only the last synced repository's state is kept: the package set
+	// belongs to it, so switching repositories must fetch in full
+	if _, err := tx.Exec(`DELETE FROM repo_sync`); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`INSERT INTO repo_sync (repository, etag, last_modified, generation, arches, synced_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		repository, state.Validators.ETag, state.Validators.LastModified, state.Generation, state.Arches,
+		time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// syncForeignPackages replaces the foreign entries of the names synced:
+// all of them with replace, else those in pkgs, foreign and removed.
+func syncForeignPackages(tx *sql.Tx, pkgs, foreign []PackageInfo, removed []string, replace bool) error {
+	if replace {
+		if _, err := tx.Exec(`DELETE FROM foreign_packages`); err != nil {
+			return err
+		}
+	} else {
+		names := append([]string(nil), removed...)
+		for _, list := range [][]PackageInfo{pkgs, foreign} {
+			for _, pkg := range list {
+				names = append(names, pkg.Name)
+			}
+		}
+		for _, name := range names {
+			if _, err := tx.Exec(`DELETE FROM foreign_packages WHERE name = ?`, name); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	for _, pkg := range foreign {
+		info, err := json.Marshal(pkg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO foreign_packages (name, arch, info) VALUES (?, ?, ?)`,
+			pkg.Name, pkg.Arch, string(info)); err != nil {
+			return fmt.Errorf("%s: %w", pkg.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetForeignPackage returns the index entry of name for an architecture
+// the system does not run, the first by architecture name if there are
+// several. It returns sql.ErrNoRows if there is none.
+func (d *Database) GetForeignPackage(name string) (*PackageInfo, error) {
+	var info string
+	err := d.db.QueryRow(`SELECT info FROM foreign_packages WHERE name = ? ORDER BY arch LIMIT 1`, name).Scan(&info)
+	if err != nil {
+		return nil, err
+	}
+	var pkg PackageInfo
+	if err := json.Unmarshal([]byte(info), &pkg); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &pkg, nil
+}
+
+// LastSync returns the state recorded by the last sync of repository, or
+// a zero SyncState if the package set did not come from it.
+func (d *Database) LastSync(repository string) (SyncState, error) {
+	var state SyncState
+	err := d.db.QueryRow(`SELECT etag, last_modified, generation, arches FROM repo_sync WHERE repository = ?`, repository).
+		Scan(&state.Validators.ETag, &state.Validators.LastModified, &state.Generation, &state.Arches)
+	if err == sql.ErrNoRows {
+		return SyncState{}, nil
+	}
+	return state, err
+}
+
+// LastSyncTime returns when the package index was last synced, or the
+// zero time if it never was.
+func (d *Database) LastSyncTime() (time.Time, error) {
+	var synced string
+	err := d.db.QueryRow(`SELECT synced_at FROM repo_sync ORDER BY synced_at DESC LIMIT 1`).Scan(&synced)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, synced)
+}
+
+// PackageDescriptions maps the names of repository and installed packages
+// to their descriptions.
+func (d *Database) PackageDescriptions() (map[string]string, error) {
+	rows, err := d.db.Query(`
+		SELECT name, COALESCE(description, '') FROM packages
+		UNION
+		SELECT name, '' FROM installed WHERE name NOT IN (SELECT name FROM packages)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	packages := make(map[string]string)
+	for rows.Next() {
+		var name, description string
+		if err := rows.Scan(&name, &description); err != nil {
+			return nil, err
+		}
+		packages[name] = description
+	}
+	return packages, rows.Err()
+}
+
+// MarkSynced records that repository's index was found unchanged.
+func (d *Database) MarkSynced(repository string) error {
+	_, err := d.db.Exec(`UPDATE repo_sync SET synced_at = ? WHERE repository = ?`,
+		time.Now().UTC().Format(time.RFC3339), repository)
 	return err
 }
 
+// CountPackages returns the number of repository packages.
+func (d *Database) CountPackages() (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM packages`).Scan(&count)
+	return count, err
+}
+
+// writePackageLists replaces the dependency and file rows of a repository
+// package.
+func writePackageLists(tx *sql.Tx, name string, deps, files []string) error {
+	if _, err := tx.Exec(`DELETE FROM package_deps WHERE package = ?`, name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM package_files WHERE package = ?`, name); err != nil {
+		return err
+	}
+
+	for i, dep := range deps {
+		_, err := tx.Exec(`INSERT INTO package_deps (package, position, spec, dep_name) VALUES (?, ?, ?, ?)`,
+			name, i, dep, parseDependency(dep))
+		if err != nil {
+			return err
+		}
+	}
+	for i, file := range files {
+		_, err := tx.Exec(`INSERT INTO package_files (package, position, path) VALUES (?, ?, ?)`, name, i, file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePackageHashes replaces the hash rows of a repository package.
+func writePackageHashes(tx *sql.Tx, name string, hashes map[string]string) error {
+	if _, err := tx.Exec(`DELETE FROM package_hashes WHERE package = ?`, name); err != nil {
+		return err
+	}
+	for alg, digest := range hashes {
+		_, err := tx.Exec(`INSERT INTO package_hashes (package, algorithm, digest) VALUES (?, ?, ?)`, name, alg, digest)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryStrings returns the single string column of a query.
+func (d *Database) queryStrings(query string, args ...interface{}) ([]string, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
 func (d *Database) GetPackage(name string) (*PackageInfo, error) {
 	var pkg PackageInfo
-	var deps, files string
 
 	err := d.db.QueryRow(`
-		SELECT name, version, description, dependencies, files, checksum, size
+		SELECT name, version, COALESCE(description, ''), COALESCE(checksum, ''), size,
+			arch, license, homepage, maintainer, installed_size, build_date
 		FROM packages WHERE name = ?
-	`, name).Scan(&pkg.Name, &pkg.Version, &pkg.Description, &deps, &files, &pkg.Checksum, &pkg.Size)
+	`, name).Scan(&pkg.Name, &pkg.Version, &pkg.Description, &pkg.Checksum, &pkg.Size,
+		&pkg.Arch, &pkg.License, &pkg.Homepage, &pkg.Maintainer, &pkg.InstalledSize, &pkg.BuildDate)
 
 	if err != nil {
 		return nil, err
 	}
 
-	json.Unmarshal([]byte(deps), &pkg.Dependencies)
-	json.Unmarshal([]byte(files), &pkg.Files)
+	pkg.Dependencies, err = d.queryStrings(`SELECT spec FROM package_deps WHERE package = ? ORDER BY position`, name)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Files, err = d.queryStrings(`SELECT path FROM package_files WHERE package = ? ORDER BY position`, name)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Hashes, err = d.packageHashes(name)
+	if err != nil {
+		return nil, err
+	}
 
 	return &pkg, nil
 }
 
+// packageHashes returns the recorded hashes of a repository package, or
+// nil if it has none.
+func (d *Database) packageHashes(name string) (map[string]string, error) {
+	rows, err := d.db.Query(`SELECT algorithm, digest FROM package_hashes WHERE package = ?`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes map[string]string
+	for rows.Next() {
+		var alg, digest string
+		if err := rows.Scan(&alg, &digest); err != nil {
+			return nil, err
+		}
+		if hashes == nil {
+			hashes = make(map[string]string)
+		}
+		hashes[alg] = digest
+	}
+	return hashes, rows.Err()
+}
+
+// RecordInstallation records name as installed at version owning files,
+// with the dependencies its repository entry lists. Any files previously
+// recorded for the package are replaced, so the same call covers both
+// fresh installs and upgrades in a single transaction.
 func (d *Database) RecordInstallation(name, version string, files []string) error {
+	deps, err := d.GetDependencies(name)
+	var unknown *UnknownPackageError
+	if err != nil && !errors.As(err, &unknown) {
+		return err
+	}
+	return d.RecordInstallationFrom(name, version, "", deps, files)
+}
+
+// RecordInstallationFrom is like RecordInstallation but also records where
+// the package came from and the dependency specs of the installed package
+// file, which need not match the repository. An empty origin means the
+// repository; otherwise it is the path of the local package file.
+func (d *Database) RecordInstallationFrom(name, version, origin string, deps, files []string) error {
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	filesJSON, _ := json.Marshal(files)
+	if err := invalidateSearchIndex(tx); err != nil {
+		return err
+	}
 
+	_, err = tx.Exec(`DELETE FROM files WHERE package = ?`, name)
+	if err != nil {
+		return err
+	}
+
+	// Reinstalls and upgrades keep the explicit flag
 	_, err = tx.Exec(`
-		INSERT OR REPLACE INTO installed (name, version, files)
+		INSERT INTO installed (name, version, origin)
 		VALUES (?, ?, ?)
-	`, name, version, string(filesJSON))
+		ON CONFLICT (name) DO UPDATE SET
+			version = excluded.version,
+			origin = excluded.origin,
+			install_time = CURRENT_TIMESTAMP
+	`, name, version, origin)
 	if err != nil {
 		return err
 	}
 
+	// The dependencies of the version being installed, which stay
+	// recorded if the package leaves the repository
+	if _, err := tx.Exec(`DELETE FROM installed_deps WHERE package = ?`, name); err != nil {
+		return err
+	}
+	for i, dep := range deps {
+		_, err := tx.Exec(`INSERT INTO installed_deps (package, position, spec, dep_name) VALUES (?, ?, ?, ?)`,
+			name, i, dep, parseDependency(dep))
+		if err != nil {
+			return err
+		}
+	}
+
 	// Record individual files
 	for _, file := range files {
 		_, err = tx.Exec(`
@@ -134,11 +538,20 @@ func (d *Database) RemoveInstallation(name string) error {
 	}
 	defer tx.Rollback()
 
+	if err := invalidateSearchIndex(tx); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(`DELETE FROM files WHERE package = ?`, name)
 	if err != nil {
 		return err
 	}
 
+	_, err = tx.Exec(`DELETE FROM installed_deps WHERE package = ?`, name)
+	if err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(`DELETE FROM installed WHERE name = ?`, name)
 	if err != nil {
 		return err
@@ -153,75 +566,137 @@ func (d *Database) IsInstalled(name string) (bool, error) {
 	return count > 0, err
 }
 
+// SetExplicit records whether the installed package name was installed
+// explicitly or as a dependency of another package.
+func (d *Database) SetExplicit(name string, explicit bool) error {
+	res, err := d.db.Exec(`UPDATE installed SET explicit = ? WHERE name = ?`, explicit, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("package %s is not installed", name)
+	}
+	return nil
+}
+
+// ExplicitPackages returns the sorted names of the packages that were
+// installed explicitly.
+func (d *Database) ExplicitPackages() ([]string, error) {
+	return d.queryStrings(`SELECT name FROM installed WHERE explicit = 1 ORDER BY name`)
+}
+
+// PackageNames returns the sorted names of repository or installed
+// packages starting with prefix, or only installed ones if installedOnly
+// is set. The prefix is matched as a range on the primary keys, so this
+// stays fast enough for shell completion.
+func (d *Database) PackageNames(prefix string, installedOnly bool) ([]string, error) {
+	// No UTF-8 string starting with prefix sorts after prefix+U+10FFFF
+	upper := prefix + "\U0010FFFF"
+	if installedOnly {
+		return d.queryStrings(`SELECT name FROM installed WHERE name >= ? AND name < ? ORDER BY name`, prefix, upper)
+	}
+	return d.queryStrings(`
+		SELECT name FROM packages WHERE name >= ?1 AND name < ?2
+		UNION
+		SELECT name FROM installed WHERE name >= ?1 AND name < ?2
+		ORDER BY name`, prefix, upper)
+}
+
 func (d *Database) GetInstalledPackage(name string) (*PackageInfo, error) {
 	var pkg PackageInfo
-	var filesJSON string
 
 	err := d.db.QueryRow(`
-		SELECT i.name, i.version, COALESCE(p.description, ''), COALESCE(p.dependencies, '[]'), i.files, COALESCE(p.checksum, ''), COALESCE(p.size, 0)
+		SELECT i.name, i.version, COALESCE(p.description, ''), COALESCE(p.checksum, ''), COALESCE(p.size, 0), COALESCE(i.origin, ''),
+			COALESCE(p.arch, ''), COALESCE(p.license, ''), COALESCE(p.homepage, ''), COALESCE(p.maintainer, ''),
+			COALESCE(p.installed_size, 0), COALESCE(p.build_date, '')
 		FROM installed i
 		LEFT JOIN packages p ON i.name = p.name
 		WHERE i.name = ?
-	`, name).Scan(&pkg.Name, &pkg.Version, &pkg.Description, &pkg.Dependencies, &filesJSON, &pkg.Checksum, &pkg.Size)
+	`, name).Scan(&pkg.Name, &pkg.Version, &pkg.Description, &pkg.Checksum, &pkg.Size, &pkg.Origin,
+		&pkg.Arch, &pkg.License, &pkg.Homepage, &pkg.Maintainer, &pkg.InstalledSize, &pkg.BuildDate)
 
 	if err != nil {
 		return nil, err
 	}
 
-	json.Unmarshal([]byte(filesJSON), &pkg.Files)
+	pkg.Dependencies, err = d.queryStrings(`SELECT spec FROM installed_deps WHERE package = ? ORDER BY position`, name)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Files, err = d.GetInstalledFiles(name)
+	if err != nil {
+		return nil, err
+	}
 	pkg.Installed = true
 
 	return &pkg, nil
 }
 
+// GetInstalledFiles returns the files owned by an installed package, sorted
+// so that directories come before their contents.
 func (d *Database) GetInstalledFiles(name string) ([]string, error) {
-	var filesJSON string
-	err := d.db.QueryRow(`SELECT files FROM installed WHERE name = ?`, name).Scan(&filesJSON)
+	installed, err := d.IsInstalled(name)
 	if err != nil {
 		return nil, err
 	}
-
-	var files []string
-	json.Unmarshal([]byte(filesJSON), &files)
-	return files, nil
+	if !installed {
+		return nil, sql.ErrNoRows
+	}
+	return d.queryStrings(`SELECT path FROM files WHERE package = ? ORDER BY path`, name)
 }
 
+// GetReverseDependencies returns the installed packages that depend on name.
 func (d *Database) GetReverseDependencies(name string) ([]string, error) {
+	return d.queryStrings(`
+		SELECT DISTINCT package
+		FROM installed_deps
+		WHERE dep_name = ?
+		ORDER BY package
+	`, name)
+}
+
+// InstalledDependencyNames returns the names of the packages the installed
+// package name depends on, in declaration order and without duplicates.
+func (d *Database) InstalledDependencyNames(name string) ([]string, error) {
+	specs, err := d.queryStrings(`SELECT dep_name FROM installed_deps WHERE package = ? ORDER BY position`, name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, dep := range specs {
+		if !seen[dep] {
+			seen[dep] = true
+			names = append(names, dep)
+		}
+	}
+	return names, nil
+}
+
+// InstalledDependencies maps each installed package to the installed
+// packages it depends on, the inverse of GetReverseDependencies.
+func (d *Database) InstalledDependencies() (map[string][]string, error) {
 	rows, err := d.db.Query(`
-		SELECT i.name, p.dependencies
-		FROM installed i
-		JOIN packages p ON i.name = p.name
+		SELECT DISTINCT d.package, d.dep_name
+		FROM installed_deps d
+		JOIN installed j ON j.name = d.dep_name
+		WHERE d.package != d.dep_name
+		ORDER BY d.package, d.dep_name
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var result []string
+	deps := make(map[string][]string)
 	for rows.Next() {
-		var pkgName, depsJSON string
-		if err := rows.Scan(&pkgName, &depsJSON); err != nil {
-			continue
-		}
-
-		var deps []string
-		json.Unmarshal([]byte(depsJSON), &deps)
-
-		for _, dep := range deps {
-			// Handle version constraints (e.g., "pkg>=1.0")
-			depName := strings.Split(dep, ">=")[0]
-			depName = strings.Split(depName, "<=")[0]
-			depName = strings.Split(depName, "=")[0]
-			depName = strings.TrimSpace(depName)
-
-			if depName == name {
-				result = append(result, pkgName)
-				break
-			}
+		var name, dep string
+		if err := rows.Scan(&name, &dep); err != nil {
+			return nil, err
 		}
+		deps[name] = append(deps[name], dep)
 	}
-
-	return result, nil
+	return deps, rows.Err()
 }
 
 func (d *Database) ListInstalled() ([]PackageInfo, error) {
@@ -276,81 +751,77 @@ func (d *Database) ListAvailable() ([]PackageInfo, error) {
 	return packages, nil
 }
 
-func (d *Database) Search(query string, installedOnly bool) ([]SearchResult, error) {
-	query = "%" + strings.ToLower(query) + "%"
-
-	var sql string
-	if installedOnly {
-		sql = `
-			SELECT i.name, i.version, COALESCE(p.description, ''), 1
-			FROM installed i
-			LEFT JOIN packages p ON i.name = p.name
-			WHERE LOWER(i.name) LIKE ? OR LOWER(COALESCE(p.description, '')) LIKE ?
-			ORDER BY i.name
-		`
-	} else {
-		sql = `
-			SELECT p.name, p.version, p.description,
-				   CASE WHEN i.name IS NOT NULL THEN 1 ELSE 0 END
-			FROM packages p
-			LEFT JOIN installed i ON p.name = i.name
-			WHERE LOWER(p.name) LIKE ? OR LOWER(p.description) LIKE ?
-			ORDER BY p.name
-		`
-	}
-
-	rows, err := d.db.Query(sql, query, query)
+func (d *Database) GetAllPackages() ([]PackageInfo, error) {
+	rows, err := d.db.Query(`
+		SELECT name, version, COALESCE(description, ''), COALESCE(checksum, '')
+		FROM packages
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []SearchResult
+	var packages []PackageInfo
 	for rows.Next() {
-		var r SearchResult
-		var installed int
-		if err := rows.Scan(&r.Name, &r.Version, &r.Description, &installed); err != nil {
+		var pkg PackageInfo
+		if err := rows.Scan(&pkg.Name, &pkg.Version, &pkg.Description, &pkg.Checksum); err != nil {
 			continue
 		}
-		r.Installed = installed == 1
-		results = append(results, r)
+		packages = append(packages, pkg)
 	}
+	rows.Close()
 
-	return results, nil
-}
-
-func (d *Database) GetAllPackages() ([]PackageInfo, error) {
-	rows, err := d.db.Query(`
-		SELECT name, version, description, dependencies, checksum
-		FROM packages
-	`)
+	deps := make(map[string][]string)
+	rows, err = d.db.Query(`SELECT package, spec FROM package_deps ORDER BY package, position`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-
-	var packages []PackageInfo
 	for rows.Next() {
-		var pkg PackageInfo
-		var depsJSON string
-		if err := rows.Scan(&pkg.Name, &pkg.Version, &pkg.Description, &depsJSON, &pkg.Checksum); err != nil {
-			continue
+		var name, spec string
+		if err := rows.Scan(&name, &spec); err != nil {
+			return nil, err
 		}
-		json.Unmarshal([]byte(depsJSON), &pkg.Dependencies)
-		packages = append(packages, pkg)
+		deps[name] = append(deps[name], spec)
 	}
 
-	return packages, nil
+	for i := range packages {
+		packages[i].Dependencies = deps[packages[i].Name]
+	}
+
+	return packages, rows.Err()
 }
 
 func (d *Database) GetDependencies(name string) ([]string, error) {
-	var depsJSON string
-	err := d.db.QueryRow(`SELECT dependencies FROM packages WHERE name = ?`, name).Scan(&depsJSON)
+	rows, err := d.db.Query(`
+		SELECT d.spec
+		FROM packages p
+		LEFT JOIN package_deps d ON d.package = p.name
+		WHERE p.name = ?
+		ORDER BY d.position
+	`, name)
 	if err != nil {
-		return nil, fmt.Errorf("package %s not found", name)
+		return nil, err
 	}
+	defer rows.Close()
 
+	found := false
 	var deps []string
-	json.Unmarshal([]byte(depsJSON), &deps)
+	for rows.Next() {
+		found = true
+		var spec sql.NullString
+		if err := rows.Scan(&spec); err != nil {
+			return nil, err
+		}
+		if spec.Valid {
+			deps = append(deps, spec.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &UnknownPackageError{Name: name}
+	}
 	return deps, nil
 }
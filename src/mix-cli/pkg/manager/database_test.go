@@ -0,0 +1,287 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPackageListsRoundTrip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.AddPackages([]PackageInfo{
+		{Name: "zlib", Version: "1.3"},
+		{Name: "openssl", Version: "3.0", Dependencies: []string{"zlib"}},
+		{Name: "curl", Version: "8.0", Dependencies: []string{"openssl>=3.0", "zlib"}, Files: []string{"/usr/bin/curl", "/usr/lib/libcurl.so"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	pkg, err := db.GetPackage("curl")
+	if err != nil {
+		t.Fatalf("GetPackage failed: %v", err)
+	}
+	if fmt.Sprint(pkg.Dependencies) != "[openssl>=3.0 zlib]" || fmt.Sprint(pkg.Files) != "[/usr/bin/curl /usr/lib/libcurl.so]" {
+		t.Errorf("Lists not preserved in order: deps=%v files=%v", pkg.Dependencies, pkg.Files)
+	}
+
+	// Replacing a package replaces its lists
+	if err := db.AddPackage(&PackageInfo{Name: "curl", Version: "8.1", Dependencies: []string{"openssl"}}); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+	deps, err := db.GetDependencies("curl")
+	if err != nil {
+		t.Fatalf("GetDependencies failed: %v", err)
+	}
+	if fmt.Sprint(deps) != "[openssl]" {
+		t.Errorf("Expected replaced dependencies, got %v", deps)
+	}
+	if _, err := db.GetDependencies("missing"); err == nil {
+		t.Error("Expected error for unknown package")
+	}
+
+	for _, name := range []string{"zlib", "openssl", "curl"} {
+		if err := db.RecordInstallation(name, "1", []string{"/usr/share/" + name}); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+
+	rdeps, err := db.GetReverseDependencies("zlib")
+	if err != nil {
+		t.Fatalf("GetReverseDependencies failed: %v", err)
+	}
+	if fmt.Sprint(rdeps) != "[openssl]" {
+		t.Errorf("Expected [openssl], got %v", rdeps)
+	}
+
+	order, err := NewResolver(db).GetRemoveOrder([]string{"zlib"})
+	if err != nil {
+		t.Fatalf("GetRemoveOrder failed: %v", err)
+	}
+	if fmt.Sprint(order) != "[curl openssl zlib]" {
+		t.Errorf("Unexpected remove order %v", order)
+	}
+}
+
+func TestPrunedPackageKeepsDependencies(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.AddPackages([]PackageInfo{
+		{Name: "zlib", Version: "1.3"},
+		{Name: "app", Version: "1.0", Dependencies: []string{"zlib>=1.2"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	for _, name := range []string{"zlib", "app"} {
+		if err := db.RecordInstallation(name, "1", nil); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+
+	// app leaves the repository but stays installed
+	if _, err := db.ReplacePackages([]PackageInfo{{Name: "zlib", Version: "1.3"}}, nil, "repo", SyncState{}); err != nil {
+		t.Fatalf("ReplacePackages failed: %v", err)
+	}
+	if rdeps, err := db.GetReverseDependencies("zlib"); err != nil || fmt.Sprint(rdeps) != "[app]" {
+		t.Errorf("Expected app to still depend on zlib, got %v, %v", rdeps, err)
+	}
+	if pkg, err := db.GetInstalledPackage("app"); err != nil || fmt.Sprint(pkg.Dependencies) != "[zlib>=1.2]" {
+		t.Errorf("Expected the installed dependencies of app, got %+v, %v", pkg, err)
+	}
+	if deps, err := db.InstalledDependencies(); err != nil || fmt.Sprint(deps) != "map[app:[zlib]]" {
+		t.Errorf("Unexpected installed dependencies %v, %v", deps, err)
+	}
+	order, err := NewResolver(db).GetRemoveOrder([]string{"zlib"})
+	if err != nil || fmt.Sprint(order) != "[app zlib]" {
+		t.Errorf("Expected app to be removed before zlib, got %v, %v", order, err)
+	}
+
+	if err := db.RemoveInstallation("app"); err != nil {
+		t.Fatalf("RemoveInstallation failed: %v", err)
+	}
+	if rdeps, err := db.GetReverseDependencies("zlib"); err != nil || len(rdeps) != 0 {
+		t.Errorf("Expected no reverse dependencies after removal, got %v, %v", rdeps, err)
+	}
+}
+
+func TestPackageNames(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.AddPackages([]PackageInfo{
+		{Name: "libssl", Version: "3.0"},
+		{Name: "libz", Version: "1.3"},
+		{Name: "lua", Version: "5.4"},
+		{Name: "vim", Version: "9.0"},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	// libfoo was installed from a local file and is not in the repository
+	for _, name := range []string{"libz", "libfoo"} {
+		if err := db.RecordInstallation(name, "1", nil); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		prefix        string
+		installedOnly bool
+		want          string
+	}{
+		{"lib", false, "[libfoo libssl libz]"},
+		{"l", false, "[libfoo libssl libz lua]"},
+		{"", false, "[libfoo libssl libz lua vim]"},
+		{"lib", true, "[libfoo libz]"},
+		{"x", false, "[]"},
+	}
+	for _, tt := range tests {
+		names, err := db.PackageNames(tt.prefix, tt.installedOnly)
+		if err != nil {
+			t.Fatalf("PackageNames(%q, %v) failed: %v", tt.prefix, tt.installedOnly, err)
+		}
+		if got := fmt.Sprint(names); got != tt.want {
+			t.Errorf("PackageNames(%q, %v) = %s, want %s", tt.prefix, tt.installedOnly, got, tt.want)
+		}
+	}
+}
+
+// benchmarkDatabase returns a database holding a 10k-package index where
+// package i depends on packages i/2 and i/3, all installed.
+func benchmarkDatabase(b *testing.B) *Database {
+	b.Helper()
+
+	tmpDir := b.TempDir()
+	db, err := NewDatabase(filepath.Join(tmpDir, "bench.db"))
+	if err != nil {
+		b.Fatalf("Failed to create database: %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	const count = 10000
+	pkgs := make([]PackageInfo, count)
+	for i := range pkgs {
+		pkgs[i] = PackageInfo{Name: fmt.Sprintf("pkg-%d", i), Version: "1.0"}
+		if i > 0 {
+			pkgs[i].Dependencies = []string{fmt.Sprintf("pkg-%d>=1.0", i/2), fmt.Sprintf("pkg-%d", i/3)}
+		}
+	}
+	if err := db.AddPackages(pkgs); err != nil {
+		b.Fatalf("AddPackages failed: %v", err)
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		b.Fatalf("Failed to begin: %v", err)
+	}
+	for _, pkg := range pkgs {
+		// Also fill the legacy JSON column for the comparison benchmark
+		deps, _ := json.Marshal(pkg.Dependencies)
+		tx.Exec(`UPDATE packages SET dependencies = ? WHERE name = ?`, string(deps), pkg.Name)
+		tx.Exec(`INSERT INTO installed (name, version) VALUES (?, '1.0')`, pkg.Name)
+		for i, dep := range pkg.Dependencies {
+			tx.Exec(`INSERT INTO installed_deps (package, position, spec, dep_name) VALUES (?, ?, ?, ?)`,
+				pkg.Name, i, dep, parseDependency(dep))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("Failed to commit: %v", err)
+	}
+	return db
+}
+
+// legacyReverseDependencies is the pre-normalization implementation, which
+// scans and decodes every installed package's JSON dependency list.
+func legacyReverseDependencies(d *Database, name string) ([]string, error) {
+	rows, err := d.db.Query(`
+		SELECT i.name, p.dependencies
+		FROM installed i
+		JOIN packages p ON i.name = p.name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var pkgName, depsJSON string
+		if err := rows.Scan(&pkgName, &depsJSON); err != nil {
+			continue
+		}
+		var deps []string
+		json.Unmarshal([]byte(depsJSON), &deps)
+		for _, dep := range deps {
+			if parseDependency(dep) == name {
+				result = append(result, pkgName)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func BenchmarkReverseDependencies(b *testing.B) {
+	db := benchmarkDatabase(b)
+
+	b.Run("normalized", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if rdeps, err := db.GetReverseDependencies("pkg-1234"); err != nil || len(rdeps) == 0 {
+				b.Fatalf("Expected reverse dependencies, got %v, %v", rdeps, err)
+			}
+		}
+	})
+
+	b.Run("json-scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if rdeps, err := legacyReverseDependencies(db, "pkg-1234"); err != nil || len(rdeps) == 0 {
+				b.Fatalf("Expected reverse dependencies, got %v, %v", rdeps, err)
+			}
+		}
+	})
+}
+
+func BenchmarkRemoveOrder(b *testing.B) {
+	db := benchmarkDatabase(b)
+	resolver := NewResolver(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if order, err := resolver.GetRemoveOrder([]string{"pkg-100"}); err != nil || len(order) < 2 {
+			b.Fatalf("Expected pkg-100 and its dependents, got %v, %v", order, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrInsufficientSpace is wrapped by SpaceError.
+var ErrInsufficientSpace = errors.New("not enough free disk space")
+
+// tmpfsMagic is the statfs type of tmpfs, which holds the root filesystem
+// in VRAM mode.
+const tmpfsMagic = 0x01021994
+
+// MountSpace is the space a transaction needs on one filesystem.
+type MountSpace struct {
+	MountPoint string `json:"mount_point"`
+	// Tmpfs is set for filesystems kept in memory
+	Tmpfs  bool  `json:"tmpfs,omitempty"`
+	Needed int64 `json:"needed"`
+	Free   int64 `json:"free"`
+}
+
+// SpaceError reports filesystems without room for a transaction.
+type SpaceError struct {
+	// Mounts lists every affected filesystem, not only the full ones.
+	Mounts []MountSpace
+}
+
+func (e *SpaceError) Error() string {
+	var short []string
+	for _, mount := range e.Mounts {
+		if mount.Needed <= mount.Free {
+			continue
+		}
+		fs := ""
+		if mount.Tmpfs {
+			fs = " (tmpfs)"
+		}
+		short = append(short, fmt.Sprintf("%s%s needs %s, %s free", mount.MountPoint, fs,
+			FormatSize(mount.Needed), FormatSize(mount.Free)))
+	}
+	return fmt.Sprintf("%v: %s", ErrInsufficientSpace, strings.Join(short, "; "))
+}
+
+func (e *SpaceError) Unwrap() error {
+	return ErrInsufficientSpace
+}
+
+// SpacePlan is the disk space a transaction needs.
+type SpacePlan struct {
+	// Download is the size of the package files not in the cache yet.
+	Download int64 `json:"download"`
+	// Installed is the installed size of the packages.
+	Installed int64 `json:"installed"`
+	// Unknown lists packages whose installed size the index does not
+	// record; they are checked once downloaded.
+	Unknown []string `json:"unknown,omitempty"`
+	// Mounts breaks the space down by filesystem.
+	Mounts []MountSpace `json:"mounts"`
+}
+
+// Check returns a SpaceError if any filesystem lacks the space the plan
+// needs.
+func (p *SpacePlan) Check() error {
+	for _, mount := range p.Mounts {
+		if mount.Needed > mount.Free {
+			return &SpaceError{Mounts: p.Mounts}
+		}
+	}
+	return nil
+}
+
+// PlanSpace works out the space needed to install or upgrade (op) the
+// packages names. Files are staged next to their targets before the old
+// ones are replaced, so an upgrade needs the full size of the new
+// version. The installed size of a package is taken from its file when
+// it is local or cached, and from the index otherwise, spread over the
+// filesystems of its file list.
+func (m *Manager) PlanSpace(op string, names []string) (*SpacePlan, error) {
+	plan := &SpacePlan{}
+	tally := newSpaceTally()
+
+	for _, name := range names {
+		pkgPath := ""
+		var info *PackageInfo
+		if local, ok := m.local[name]; ok && op == OpInstall {
+			pkgPath = local.Path
+		} else {
+			var err error
+			if info, err = m.db.GetPackage(name); err != nil {
+				return nil, m.unknownPackage(name)
+			}
+			pkgPath = filepath.Join(m.cacheDir, PackageFileName(info.Name, info.Version, info.Arch))
+		}
+
+		files, err := packageFileSizes(pkgPath)
+		if err == nil {
+			for _, f := range files {
+				plan.Installed += f.size
+				if err := tally.add(m.rootPath(f.path), f.size); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if info == nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		// Not cached, or a cached file that cannot be read and is
+		// downloaded again
+		plan.Download += info.Size
+		if err := tally.add(pkgPath, info.Size); err != nil {
+			return nil, err
+		}
+		if info.InstalledSize == 0 {
+			plan.Unknown = append(plan.Unknown, name)
+			continue
+		}
+		plan.Installed += info.InstalledSize
+		paths := info.Files
+		if len(paths) == 0 {
+			paths = []string{"/"}
+		}
+		share := info.InstalledSize / int64(len(paths))
+		for _, path := range paths {
+			if err := tally.add(m.rootPath(path), share); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	plan.Mounts = tally.mounts()
+	return plan, nil
+}
+
+// checkPackageSpace checks that the filesystems the files of pkgPath go
+// to have room for them, before anything is extracted.
+func (m *Manager) checkPackageSpace(pkgPath string) error {
+	files, err := packageFileSizes(pkgPath)
+	if err != nil {
+		return err
+	}
+	tally := newSpaceTally()
+	for _, f := range files {
+		if err := tally.add(m.rootPath(f.path), f.size); err != nil {
+			return err
+		}
+	}
+	plan := &SpacePlan{Mounts: tally.mounts()}
+	return plan.Check()
+}
+
+// spaceTally adds up the space needed per filesystem.
+type spaceTally struct {
+	byDevice map[uint64]*MountSpace
+	order    []uint64
+	// blockSize of each filesystem, sizes are rounded up to whole blocks
+	blockSize map[uint64]int64
+	// dirs caches the device of directories already looked up
+	dirs map[string]uint64
+}
+
+func newSpaceTally() *spaceTally {
+	return &spaceTally{
+		byDevice:  make(map[uint64]*MountSpace),
+		blockSize: make(map[uint64]int64),
+		dirs:      make(map[string]uint64),
+	}
+}
+
+// add records that size bytes will be written to path, which need not
+// exist yet: it is charged to the filesystem of its closest existing
+// parent directory.
+func (t *spaceTally) add(path string, size int64) error {
+	dir := filepath.Dir(filepath.Clean(path))
+	dev, ok := t.dirs[dir]
+	if !ok {
+		existing := dir
+		var st syscall.Stat_t
+		for {
+			err := syscall.Stat(existing, &st)
+			if err == nil {
+				break
+			}
+			parent := filepath.Dir(existing)
+			if parent == existing {
+				return fmt.Errorf("stat %s: %w", existing, err)
+			}
+			existing = parent
+		}
+		dev = uint64(st.Dev)
+		t.dirs[dir] = dev
+
+		if _, known := t.byDevice[dev]; !known {
+			var fs syscall.Statfs_t
+			if err := syscall.Statfs(existing, &fs); err != nil {
+				return fmt.Errorf("statfs %s: %w", existing, err)
+			}
+			t.byDevice[dev] = &MountSpace{
+				MountPoint: mountPoint(existing, dev),
+				Tmpfs:      int64(fs.Type) == tmpfsMagic,
+				Free:       int64(uint64(fs.Bavail) * uint64(fs.Bsize)),
+			}
+			t.blockSize[dev] = int64(fs.Bsize)
+			t.order = append(t.order, dev)
+		}
+	}
+
+	if bs := t.blockSize[dev]; bs > 0 && size > 0 {
+		size = (size + bs - 1) / bs * bs
+	}
+	t.byDevice[dev].Needed += size
+	return nil
+}
+
+func (t *spaceTally) mounts() []MountSpace {
+	mounts := make([]MountSpace, 0, len(t.order))
+	for _, dev := range t.order {
+		mounts = append(mounts, *t.byDevice[dev])
+	}
+	return mounts
+}
+
+// mountPoint returns the top directory of the filesystem dev that
+// contains dir.
+func mountPoint(dir string, dev uint64) string {
+	for {
+		parent := filepath.Dir(dir)
+		var st syscall.Stat_t
+		if parent == dir || syscall.Stat(parent, &st) != nil || uint64(st.Dev) != dev {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// FormatSize formats a byte count for humans, e.g. 1.5 MB.
+func FormatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlanSpace(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+
+	local := writeTestPackage(t, filepath.Join(tmpDir, "local"), &PackageMetadata{Name: "tool", Version: "1.0"},
+		map[string]string{"/usr/bin/tool": strings.Repeat("x", 5000), "/etc/tool.conf": "a=1"})
+	if _, err := mgr.AddLocalPackage(local); err != nil {
+		t.Fatalf("AddLocalPackage failed: %v", err)
+	}
+	err = mgr.db.AddPackage(&PackageInfo{Name: "big", Version: "2.0", Size: 1 << 20, InstalledSize: 3 << 20,
+		Files: []string{"/usr/lib/big.so", "/usr/share/big/data"}})
+	if err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+	if err := mgr.db.AddPackage(&PackageInfo{Name: "old", Version: "0.1", Size: 100}); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+
+	plan, err := mgr.PlanSpace(OpInstall, []string{"tool", "big", "old"})
+	if err != nil {
+		t.Fatalf("PlanSpace failed: %v", err)
+	}
+	if plan.Download != 1<<20+100 {
+		t.Errorf("Expected the repository packages to be downloaded, got %d bytes", plan.Download)
+	}
+	if plan.Installed != 3<<20+5003 {
+		t.Errorf("Expected the exact size of the local package plus the indexed size, got %d", plan.Installed)
+	}
+	if fmt.Sprint(plan.Unknown) != "[old]" {
+		t.Errorf("Expected the installed size of old to be unknown, got %v", plan.Unknown)
+	}
+	var needed int64
+	for _, mount := range plan.Mounts {
+		needed += mount.Needed
+	}
+	if len(plan.Mounts) == 0 || needed < plan.Download+plan.Installed {
+		t.Errorf("Expected the space to be charged to the filesystems, got %+v", plan.Mounts)
+	}
+	if err := plan.Check(); err != nil {
+		t.Errorf("Expected a few MB to fit: %v", err)
+	}
+
+	// A cached package is not downloaded again, unless it is unreadable
+	cached := writeTestPackage(t, filepath.Join(tmpDir, "cache"), &PackageMetadata{Name: "big", Version: "2.0"},
+		map[string]string{"/usr/lib/big.so": "elf"})
+	if plan, err = mgr.PlanSpace(OpInstall, []string{"big"}); err != nil {
+		t.Fatalf("PlanSpace failed: %v", err)
+	}
+	if plan.Download != 0 || plan.Installed != 3 {
+		t.Errorf("Expected the cached package to be measured, got %+v", plan)
+	}
+	if err := os.WriteFile(cached, []byte("truncated"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt cached file: %v", err)
+	}
+	if plan, err = mgr.PlanSpace(OpInstall, []string{"big"}); err != nil || plan.Download != 1<<20 {
+		t.Errorf("Expected a corrupt cached package to be downloaded again, got %+v, %v", plan, err)
+	}
+
+	full := &SpacePlan{Mounts: []MountSpace{
+		{MountPoint: "/", Tmpfs: true, Needed: 3 << 20, Free: 1 << 20},
+		{MountPoint: "/var/cache", Needed: 1 << 10, Free: 1 << 30},
+	}}
+	err = full.Check()
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("Expected ErrInsufficientSpace, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "/ (tmpfs) needs 3.0 MB, 1.0 MB free") || strings.Contains(msg, "/var/cache") {
+		t.Errorf("Unexpected error message %q", msg)
+	}
+}
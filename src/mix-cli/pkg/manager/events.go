@@ -0,0 +1,236 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType classifies an Event.
+type EventType string
+
+const (
+	// EventStart is emitted when an operation on a package begins.
+	EventStart EventType = "start"
+	// EventPhase is emitted when a package enters a new phase.
+	EventPhase EventType = "phase"
+	// EventProgress reports bytes processed within the current phase.
+	EventProgress EventType = "progress"
+	// EventOutput carries one line written by a package script.
+	EventOutput EventType = "output"
+	// EventWarning reports a problem that did not stop the operation.
+	EventWarning EventType = "warning"
+	// EventError reports that the operation on a package failed.
+	EventError EventType = "error"
+	// EventDone reports that the operation on a package succeeded.
+	EventDone EventType = "done"
+)
+
+// Phase is a step of a package operation.
+type Phase string
+
+const (
+	PhaseDownload Phase = "download"
+	PhaseVerify   Phase = "verify"
+	PhaseExtract  Phase = "extract"
+	PhaseScript   Phase = "script"
+	PhaseInstall  Phase = "install"
+	PhaseCleanup  Phase = "cleanup"
+	PhaseRemove   Phase = "remove"
+	PhaseIndex    Phase = "index"
+)
+
+// Operations reported in Event.Op.
+const (
+	OpInstall = "install"
+	OpRemove  = "remove"
+	OpUpgrade = "upgrade"
+	OpUpdate  = "update"
+)
+
+// Event describes something that happened during a Manager operation.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	Op      string    `json:"op"`
+	Package string    `json:"package,omitempty"`
+	// Version is the version left installed, set on EventDone for
+	// installs and upgrades
+	Version string `json:"version,omitempty"`
+	Phase   Phase  `json:"phase,omitempty"`
+	// BytesDone and BytesTotal are set on progress events; BytesTotal is
+	// 0 when the size is unknown.
+	BytesDone  int64  `json:"bytes_done,omitempty"`
+	BytesTotal int64  `json:"bytes_total,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// Err is the failure of an EventError; Message holds its text.
+	Err error `json:"-"`
+}
+
+// Subscription receives Manager events on C. Events are queued per
+// subscriber, so a slow reader never blocks the operation; consecutive
+// progress events for the same phase are merged while queued.
+type Subscription struct {
+	// C delivers events in order and is closed after Close once all
+	// queued events have been delivered.
+	C <-chan Event
+
+	c      chan Event
+	mu     sync.Mutex
+	queue  []Event
+	wake   chan struct{}
+	closed bool
+	bus    *eventBus
+}
+
+// Close stops the subscription. Events already queued are still delivered
+// before C is closed.
+func (s *Subscription) Close() {
+	s.bus.remove(s)
+
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Subscription) push(e Event) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	if n := len(s.queue); n > 0 && e.Type == EventProgress {
+		last := &s.queue[n-1]
+		if last.Type == EventProgress && last.Package == e.Package && last.Phase == e.Phase {
+			*last = e
+			s.mu.Unlock()
+			return
+		}
+	}
+	s.queue = append(s.queue, e)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// forward moves queued events to C until the subscription is closed and
+// drained.
+func (s *Subscription) forward() {
+	for {
+		s.mu.Lock()
+		queue, closed := s.queue, s.closed
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, e := range queue {
+			s.c <- e
+		}
+		if len(queue) > 0 {
+			continue
+		}
+		if closed {
+			close(s.c)
+			return
+		}
+		<-s.wake
+	}
+}
+
+// eventBus fans events out to subscribers.
+type eventBus struct {
+	mu   sync.Mutex
+	subs []*Subscription
+}
+
+func (b *eventBus) subscribe() *Subscription {
+	c := make(chan Event)
+	s := &Subscription{C: c, c: c, wake: make(chan struct{}, 1), bus: b}
+	go s.forward()
+
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return s
+}
+
+func (b *eventBus) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, sub := range b.subs {
+		if sub == s {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			return
+		}
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Err != nil && e.Message == "" {
+		e.Message = e.Err.Error()
+	}
+
+	b.mu.Lock()
+	subs := append([]*Subscription(nil), b.subs...)
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		s.push(e)
+	}
+}
+
+// Subscribe returns a new subscription to the events of all operations.
+// Callers must Close it.
+func (m *Manager) Subscribe() *Subscription {
+	return m.events.subscribe()
+}
+
+// emit publishes an event for the operation op on pkg.
+func (m *Manager) emit(op, pkg string, e Event) {
+	e.Op, e.Package = op, pkg
+	m.events.publish(e)
+}
+
+// phase announces that pkg entered a new phase of op.
+func (m *Manager) phase(op, pkg string, phase Phase, message string) {
+	m.emit(op, pkg, Event{Type: EventPhase, Phase: phase, Message: message})
+}
+
+// warn reports a non-fatal problem with pkg.
+func (m *Manager) warn(op, pkg, message string) {
+	m.emit(op, pkg, Event{Type: EventWarning, Message: message})
+}
+
+// finish reports the outcome of op on pkg, which left version installed.
+func (m *Manager) finish(op, pkg, version string, err error) {
+	if err != nil {
+		m.emit(op, pkg, Event{Type: EventError, Err: err})
+		return
+	}
+	m.emit(op, pkg, Event{Type: EventDone, Version: version})
+}
+
+// progressReporter returns a callback that publishes byte progress for a
+// phase, at most every progressInterval plus once at completion.
+func (m *Manager) progressReporter(op, pkg string, phase Phase) func(done, total int64) {
+	var last time.Time
+	return func(done, total int64) {
+		now := time.Now()
+		if now.Sub(last) < progressInterval && done != total {
+			return
+		}
+		last = now
+		m.emit(op, pkg, Event{Type: EventProgress, Phase: phase, BytesDone: done, BytesTotal: total})
+	}
+}
+
+// progressInterval limits how often byte progress is published.
+const progressInterval = 100 * time.Millisecond
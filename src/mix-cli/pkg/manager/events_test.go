@@ -0,0 +1,128 @@
+package manager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func collectEvents(sub *Subscription) []Event {
+	var events []Event
+	for e := range sub.C {
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestInstallEvents(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+
+	path := writeTestPackage(t, filepath.Join(tmpDir, "pkgs"), &PackageMetadata{
+		Name:        "tool",
+		Version:     "1.2.0",
+		PostInstall: "echo configuring\necho done >&2",
+	}, map[string]string{"/usr/bin/tool": "v1"})
+	if _, err := mgr.AddLocalPackage(path); err != nil {
+		t.Fatalf("AddLocalPackage failed: %v", err)
+	}
+
+	// Neither subscriber is read until the install has finished, so
+	// publishing must not block on them
+	first := mgr.Subscribe()
+	second := mgr.Subscribe()
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if err := mgr.Install(context.Background(), "tool"); err == nil {
+		t.Fatal("Expected second install to fail")
+	}
+	first.Close()
+	second.Close()
+
+	events := collectEvents(first)
+	if got := len(collectEvents(second)); got != len(events) {
+		t.Errorf("Subscribers saw different events: %d vs %d", len(events), got)
+	}
+
+	var output []string
+	var types []EventType
+	for _, e := range events {
+		if e.Package != "tool" || e.Op != OpInstall {
+			t.Errorf("Unexpected event %+v", e)
+		}
+		if e.Time.IsZero() {
+			t.Error("Event without timestamp")
+		}
+		types = append(types, e.Type)
+		if e.Type == EventOutput {
+			output = append(output, e.Message)
+		}
+	}
+
+	if types[0] != EventStart {
+		t.Errorf("Expected first event to be start, got %s", types[0])
+	}
+	if len(output) != 2 || output[0] != "configuring" || output[1] != "done" {
+		t.Errorf("Expected script output lines, got %q", output)
+	}
+
+	var done, failed *Event
+	for i := range events {
+		switch events[i].Type {
+		case EventDone:
+			done = &events[i]
+		case EventError:
+			failed = &events[i]
+		}
+	}
+	if done == nil || done.Version != "1.2.0" {
+		t.Errorf("Expected done event with version 1.2.0, got %+v", done)
+	}
+	if failed == nil || failed.Err == nil || failed.Message == "" {
+		t.Errorf("Expected error event for the second install, got %+v", failed)
+	}
+}
+
+func TestSubscriptionMergesProgress(t *testing.T) {
+	var bus eventBus
+	sub := bus.subscribe()
+
+	// Hold the forwarder on the first event so the rest queue up
+	bus.publish(Event{Type: EventStart, Package: "a"})
+	for i := int64(1); i <= 5; i++ {
+		bus.publish(Event{Type: EventProgress, Package: "a", Phase: PhaseDownload, BytesDone: i, BytesTotal: 5})
+	}
+	bus.publish(Event{Type: EventDone, Package: "a"})
+	sub.Close()
+
+	events := collectEvents(sub)
+	if events[0].Type != EventStart || events[len(events)-1].Type != EventDone {
+		t.Fatalf("Unexpected event order: %+v", events)
+	}
+
+	var last int64
+	for _, e := range events[1 : len(events)-1] {
+		if e.Type != EventProgress || e.BytesDone <= last {
+			t.Fatalf("Unexpected progress sequence: %+v", events)
+		}
+		last = e.BytesDone
+	}
+	if last != 5 {
+		t.Errorf("Expected final progress of 5 bytes, got %d", last)
+	}
+	if len(events) > 7 {
+		t.Errorf("Expected progress events to be merged, got %d events", len(events))
+	}
+}
@@ -0,0 +1,260 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotFound is returned by a Fetcher when the requested file does not
+// exist in the repository.
+var ErrNotFound = errors.New("not found in repository")
+
+// ErrNotModified is returned by a ConditionalFetcher when a file still
+// matches the validators of an earlier download.
+var ErrNotModified = errors.New("not modified")
+
+// Validators identify one version of a repository file for conditional
+// requests. Either field may be empty.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Fetcher retrieves files from a package repository.
+type Fetcher interface {
+	// Fetch opens name, a path relative to the repository root. The size
+	// is -1 when the transport does not know it in advance. Cancelling ctx
+	// aborts the request and any read from the returned body.
+	Fetch(ctx context.Context, name string) (io.ReadCloser, int64, error)
+	// String describes the repository location for messages.
+	String() string
+}
+
+// ConditionalFetcher is a Fetcher that can skip downloading files that
+// have not changed.
+type ConditionalFetcher interface {
+	Fetcher
+	// FetchIfModified is like Fetch, but returns ErrNotModified when name
+	// still matches prev. On success it also returns the validators of
+	// the new content.
+	FetchIfModified(ctx context.Context, name string, prev Validators) (io.ReadCloser, Validators, error)
+}
+
+// NewFetcher returns the Fetcher for a repository URL, chosen by scheme:
+// http and https are fetched over the network with client (or
+// http.DefaultClient when nil), file:// URLs and plain paths are read from
+// the local filesystem.
+func NewFetcher(repoURL string, client *http.Client) (Fetcher, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid repository URL %q: %w", repoURL, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if client == nil {
+			client = http.DefaultClient
+		}
+		return &httpFetcher{base: strings.TrimRight(repoURL, "/"), client: client}, nil
+	case "file":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("file URL %q must not name a host", repoURL)
+		}
+		return &dirFetcher{dir: u.Path}, nil
+	case "":
+		return &dirFetcher{dir: repoURL}, nil
+	default:
+		return nil, fmt.Errorf("unsupported repository scheme %q", u.Scheme)
+	}
+}
+
+// httpFetcher fetches repository files over HTTP(S).
+type httpFetcher struct {
+	base   string
+	client *http.Client
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	resp, err := f.get(ctx, name, Validators{})
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// FetchIfModified sends prev as If-None-Match and If-Modified-Since.
+func (f *httpFetcher) FetchIfModified(ctx context.Context, name string, prev Validators) (io.ReadCloser, Validators, error) {
+	resp, err := f.get(ctx, name, prev)
+	if err != nil {
+		return nil, Validators{}, err
+	}
+	v := Validators{ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	return resp.Body, v, nil
+}
+
+// get requests name, conditionally on prev, and returns the response if
+// its status is 200.
+func (f *httpFetcher) get(ctx context.Context, name string, prev Validators) (*http.Response, error) {
+	target := f.base + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusNotModified:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", target, ErrNotModified)
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", target, ErrNotFound)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: HTTP %d", target, resp.StatusCode)
+	}
+}
+
+func (f *httpFetcher) String() string {
+	return f.base
+}
+
+// dirFetcher reads repository files from a local directory.
+type dirFetcher struct {
+	dir string
+}
+
+func (f *dirFetcher) Fetch(ctx context.Context, name string) (io.ReadCloser, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	path := filepath.Join(f.dir, filepath.FromSlash(filepath.Clean("/"+name)))
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, fmt.Errorf("%s: %w", path, ErrNotFound)
+		}
+		return nil, 0, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, 0, fmt.Errorf("%s is a directory", path)
+	}
+	return file, stat.Size(), nil
+}
+
+func (f *dirFetcher) String() string {
+	return f.dir
+}
+
+// contextReader fails reads once ctx is done, so that copies from
+// transports that do not watch the context themselves stop promptly.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// progressReader reports the number of bytes read through it.
+type progressReader struct {
+	r      io.Reader
+	done   int64
+	total  int64
+	report func(done, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.done += int64(n)
+	if n > 0 || err == io.EOF {
+		r.report(r.done, r.total)
+	}
+	return n, err
+}
+
+// fetchBytes reads a whole repository file into memory.
+func fetchBytes(ctx context.Context, f Fetcher, name string) ([]byte, error) {
+	body, _, err := f.Fetch(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(&contextReader{ctx: ctx, r: body})
+}
+
+// fetchFile downloads a repository file to a temporary file next to dest,
+// verifies its checksum when one is given and renames it into place. An
+// interrupted download leaves dest untouched. progress, if not nil, is
+// called with the bytes received so far and the total (0 if unknown).
+func fetchFile(ctx context.Context, f Fetcher, name, dest, checksum string, progress func(done, total int64)) error {
+	body, size, err := f.Fetch(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".part-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	var src io.Reader = &contextReader{ctx: ctx, r: body}
+	if progress != nil {
+		if size < 0 {
+			size = 0
+		}
+		src = &progressReader{r: src, total: size, report: progress}
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if checksum != "" {
+		sum, err := fileChecksum(tmp.Name())
+		if err != nil {
+			return err
+		}
+		if sum != checksum {
+			return fmt.Errorf("checksum mismatch: expected %s, got %s", checksum, sum)
+		}
+	}
+
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), dest)
+}
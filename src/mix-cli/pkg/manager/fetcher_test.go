@@ -0,0 +1,221 @@
+package manager
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFetcherSchemes(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantDir string
+		wantErr bool
+	}{
+		{"https://repo.example.org/packages", "", false},
+		{"http://localhost:8080", "", false},
+		{"file:///srv/mirror", "/srv/mirror", false},
+		{"/srv/mirror", "/srv/mirror", false},
+		{"./artifacts/packages", "./artifacts/packages", false},
+		{"file://remote-host/srv", "", true},
+		{"ftp://repo.example.org", "", true},
+	}
+
+	for _, tt := range tests {
+		f, err := NewFetcher(tt.url, nil)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NewFetcher(%q): expected error", tt.url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewFetcher(%q) failed: %v", tt.url, err)
+			continue
+		}
+		dir, isDir := f.(*dirFetcher)
+		if tt.wantDir == "" && isDir {
+			t.Errorf("NewFetcher(%q): expected network fetcher", tt.url)
+		}
+		if tt.wantDir != "" && (!isDir || dir.dir != tt.wantDir) {
+			t.Errorf("NewFetcher(%q): expected directory %s, got %v", tt.url, tt.wantDir, f)
+		}
+	}
+}
+
+func TestFetchersReportNotFound(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	os.WriteFile(filepath.Join(tmpDir, IndexFile), []byte("[]"), 0644)
+
+	srv := httptest.NewServer(NewRepoHandler(tmpDir))
+	defer srv.Close()
+
+	for _, url := range []string{srv.URL, "file://" + tmpDir, tmpDir} {
+		f, err := NewFetcher(url, nil)
+		if err != nil {
+			t.Fatalf("NewFetcher(%q) failed: %v", url, err)
+		}
+
+		body, size, err := f.Fetch(context.Background(), IndexFile)
+		if err != nil {
+			t.Fatalf("%s: Fetch failed: %v", url, err)
+		}
+		data, _ := io.ReadAll(body)
+		body.Close()
+		if string(data) != "[]" || size != 2 {
+			t.Errorf("%s: unexpected content %q (size %d)", url, data, size)
+		}
+
+		if _, _, err := f.Fetch(context.Background(), "missing.mixpkg"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", url, err)
+		}
+	}
+}
+
+func TestUpdateDatabaseFromDirectory(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "file://"+repoDir, filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+
+	// No index.json yet: the directory is indexed on the fly
+	if _, err := mgr.UpdateDatabase(context.Background()); err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "root/usr/bin/tool")); err != nil {
+		t.Errorf("Expected tool to be installed: %v", err)
+	}
+}
+
+func TestUpdateDatabaseReportsNetworkErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	if _, err := mgr.UpdateDatabase(context.Background()); err == nil {
+		t.Error("Expected unreachable repository to be reported")
+	}
+}
+
+func TestUpdateDatabaseSync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var index string
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A v1-only repository: the v2 index is probed for and not found
+		if r.URL.Path != "/"+IndexFile {
+			http.NotFound(w, r)
+			return
+		}
+		requests++
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(index)))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		io.WriteString(w, index)
+	}))
+	defer srv.Close()
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), srv.URL, filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	index = `[{"name":"curl","version":"8.0"},{"name":"vim","version":"9.0"},{"name":"zlib","version":"1.3"}]`
+	summary, err := mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if fmt.Sprint(summary.Added) != "[curl vim zlib]" || summary.Packages != 3 {
+		t.Errorf("Unexpected first sync summary %+v", summary)
+	}
+	if err := mgr.db.RecordInstallation("curl", "8.0", nil); err != nil {
+		t.Fatalf("RecordInstallation failed: %v", err)
+	}
+
+	// Unchanged index: the server answers 304
+	summary, err = mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if !summary.NotModified || notModified != 1 || summary.Packages != 3 {
+		t.Errorf("Expected a not-modified sync, got %+v (%d 304s)", summary, notModified)
+	}
+
+	// vim left the repository, curl was updated, lua is new
+	index = `[{"name":"curl","version":"8.1"},{"name":"lua","version":"5.4"},{"name":"zlib","version":"1.3"}]`
+	summary, err = mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if fmt.Sprint(summary.Added) != "[lua]" || fmt.Sprint(summary.Removed) != "[vim]" ||
+		len(summary.Updated) != 1 || summary.Updated[0] != (PackageChange{Name: "curl", OldVersion: "8.0", NewVersion: "8.1"}) {
+		t.Errorf("Unexpected change summary %+v", summary)
+	}
+	if summary.Upgradable != 1 {
+		t.Errorf("Expected curl to be upgradable, got %d", summary.Upgradable)
+	}
+	if _, err := mgr.db.GetPackage("vim"); err == nil {
+		t.Error("Expected vim to be pruned")
+	}
+
+	// An invalid index leaves the database as it was
+	index = `[{"name":"curl","version":"8.2"},{"name":"curl","version":"8.3"}]`
+	if _, err := mgr.UpdateDatabase(context.Background()); err == nil {
+		t.Fatal("Expected duplicate entries to be rejected")
+	}
+	pkg, err := mgr.db.GetPackage("curl")
+	if err != nil || pkg.Version != "8.1" {
+		t.Errorf("Expected curl 8.1 to survive a failed sync, got %+v, %v", pkg, err)
+	}
+	if _, err := mgr.db.GetPackage("lua"); err != nil {
+		t.Errorf("Expected lua to survive a failed sync: %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("Expected 4 index requests, got %d", requests)
+	}
+}
@@ -0,0 +1,273 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// DependencyNode is a package in a dependency tree.
+type DependencyNode struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Installed is set for installed packages, whose installed version
+	// is reported.
+	Installed bool `json:"installed"`
+	// Missing marks a dependency that is neither installed nor available.
+	Missing bool `json:"missing,omitempty"`
+	// Repeated marks a package already shown earlier in the tree, or a
+	// dependency cycle; its children are left out.
+	Repeated bool              `json:"repeated,omitempty"`
+	Children []*DependencyNode `json:"children,omitempty"`
+}
+
+// DependencyGraph is a set of packages and the dependencies between them.
+type DependencyGraph struct {
+	Packages []GraphPackage `json:"packages"`
+	Edges    []GraphEdge    `json:"edges"`
+}
+
+// GraphPackage is a node of a DependencyGraph.
+type GraphPackage struct {
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	Installed bool   `json:"installed"`
+	// Explicit is set for packages that were installed explicitly rather
+	// than as a dependency.
+	Explicit bool `json:"explicit,omitempty"`
+	Missing  bool `json:"missing,omitempty"`
+}
+
+// GraphEdge records that From depends on To.
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// newResolver returns a resolver that knows the local packages.
+func (m *Manager) newResolver() *Resolver {
+	resolver := NewResolver(m.db)
+	for name, local := range m.local {
+		resolver.AddLocal(name, local.Metadata.Dependencies)
+	}
+	return resolver
+}
+
+// dependencyNames returns the dependencies of name: those recorded when
+// it was installed, otherwise those of the local or repository package,
+// or none if name is not known.
+func (m *Manager) dependencyNames(resolver *Resolver, name string) ([]string, error) {
+	installed, err := m.db.IsInstalled(name)
+	if err != nil {
+		return nil, err
+	}
+	if installed {
+		return m.db.InstalledDependencyNames(name)
+	}
+
+	deps, err := resolver.Dependencies(name)
+	var unknown *UnknownPackageError
+	if errors.As(err, &unknown) {
+		return nil, nil
+	}
+	return deps, err
+}
+
+// describePackage returns the node for name: the installed version if it
+// is installed, otherwise the available version, or a missing node.
+func (m *Manager) describePackage(name string) *DependencyNode {
+	node := &DependencyNode{Name: name}
+	if info, err := m.db.GetInstalledPackage(name); err == nil {
+		node.Version, node.Installed = info.Version, true
+	} else if local, ok := m.local[name]; ok {
+		node.Version = local.Metadata.Version
+	} else if info, err := m.db.GetPackage(name); err == nil {
+		node.Version = info.Version
+	} else {
+		node.Missing = true
+	}
+	return node
+}
+
+// DependencyTree returns the tree of packages name depends on, or with
+// reverse, the tree of installed packages depending on it. Each package
+// is expanded once; later occurrences are marked Repeated.
+func (m *Manager) DependencyTree(name string, reverse bool) (*DependencyNode, error) {
+	root := m.describePackage(name)
+	if root.Missing {
+		return nil, m.unknownPackage(name)
+	}
+
+	resolver := m.newResolver()
+	expanded := map[string]bool{name: true}
+	var expand func(node *DependencyNode) error
+	expand = func(node *DependencyNode) error {
+		var next []string
+		var err error
+		if reverse {
+			next, err = m.db.GetReverseDependencies(node.Name)
+		} else {
+			next, err = m.dependencyNames(resolver, node.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, dep := range next {
+			child := m.describePackage(dep)
+			node.Children = append(node.Children, child)
+			if expanded[dep] {
+				child.Repeated = true
+				continue
+			}
+			expanded[dep] = true
+			if err := expand(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// Why returns the shortest chain of installed packages leading from an
+// explicitly installed package to name, each depending on the next. The
+// chain is just name if it was installed explicitly, and nil if no
+// explicitly installed package needs it.
+func (m *Manager) Why(name string) ([]string, error) {
+	installed, err := m.db.IsInstalled(name)
+	if err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, fmt.Errorf("package %s is not installed", name)
+	}
+	explicit, err := m.db.ExplicitPackages()
+	if err != nil {
+		return nil, err
+	}
+	isExplicit := make(map[string]bool, len(explicit))
+	for _, pkg := range explicit {
+		isExplicit[pkg] = true
+	}
+
+	// Search breadth first from name up its reverse dependencies, so the
+	// first explicit package found is the closest one
+	next := map[string]string{name: ""}
+	queue := []string{name}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if isExplicit[pkg] {
+			var chain []string
+			for p := pkg; p != ""; p = next[p] {
+				chain = append(chain, p)
+			}
+			return chain, nil
+		}
+
+		dependents, err := m.db.GetReverseDependencies(pkg)
+		if err != nil {
+			return nil, err
+		}
+		for _, dependent := range dependents {
+			if _, seen := next[dependent]; !seen {
+				next[dependent] = pkg
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	return nil, nil
+}
+
+// DependencyGraph returns the graph of roots and everything they depend
+// on. Without roots it covers the installed packages, or with available
+// every package in the database as well.
+func (m *Manager) DependencyGraph(roots []string, available bool) (*DependencyGraph, error) {
+	if len(roots) == 0 {
+		list := m.db.ListInstalled
+		if available {
+			list = m.db.ListAvailable
+		}
+		pkgs, err := list()
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			roots = append(roots, pkg.Name)
+		}
+		if available {
+			installed, err := m.db.ListInstalled()
+			if err != nil {
+				return nil, err
+			}
+			for _, pkg := range installed {
+				roots = append(roots, pkg.Name)
+			}
+		}
+	} else {
+		for _, name := range roots {
+			if m.describePackage(name).Missing {
+				return nil, m.unknownPackage(name)
+			}
+		}
+	}
+
+	explicit, err := m.db.ExplicitPackages()
+	if err != nil {
+		return nil, err
+	}
+	isExplicit := make(map[string]bool, len(explicit))
+	for _, pkg := range explicit {
+		isExplicit[pkg] = true
+	}
+
+	resolver := m.newResolver()
+	graph := &DependencyGraph{Packages: []GraphPackage{}, Edges: []GraphEdge{}}
+	visited := make(map[string]bool)
+	queue := append([]string(nil), roots...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		node := m.describePackage(name)
+		graph.Packages = append(graph.Packages, GraphPackage{
+			Name:      name,
+			Version:   node.Version,
+			Installed: node.Installed,
+			Explicit:  node.Installed && isExplicit[name],
+			Missing:   node.Missing,
+		})
+
+		deps, err := m.dependencyNames(resolver, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range deps {
+			graph.Edges = append(graph.Edges, GraphEdge{From: name, To: dep})
+			queue = append(queue, dep)
+		}
+	}
+
+	sort.Slice(graph.Packages, func(i, j int) bool { return graph.Packages[i].Name < graph.Packages[j].Name })
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		return graph.Edges[i].To < graph.Edges[j].To
+	})
+	return graph, nil
+}
+
+// SetExplicit records whether the installed package name was installed
+// explicitly or as a dependency, see Why.
+func (m *Manager) SetExplicit(name string, explicit bool) error {
+	return m.db.SetExplicit(name, explicit)
+}
@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDependencyTools(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	err = mgr.db.AddPackages([]PackageInfo{
+		{Name: "app", Version: "2.0", Dependencies: []string{"lib>=1.0", "base"}},
+		{Name: "lib", Version: "1.1", Dependencies: []string{"base", "ghost"}},
+		{Name: "base", Version: "1.0"},
+		{Name: "tool", Version: "0.3", Dependencies: []string{"base"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	for _, name := range []string{"base", "lib", "app"} {
+		if err := mgr.db.RecordInstallation(name, "1.0", nil); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+	for _, name := range []string{"base", "lib"} {
+		if err := mgr.SetExplicit(name, false); err != nil {
+			t.Fatalf("SetExplicit failed: %v", err)
+		}
+	}
+
+	tree, err := mgr.DependencyTree("app", false)
+	if err != nil {
+		t.Fatalf("DependencyTree failed: %v", err)
+	}
+	var render func(n *DependencyNode) string
+	render = func(n *DependencyNode) string {
+		s := n.Name
+		if n.Missing {
+			s += "?"
+		}
+		if n.Repeated {
+			s += "^"
+		}
+		if len(n.Children) > 0 {
+			s += fmt.Sprint(renderAll(n.Children, render))
+		}
+		return s
+	}
+	if got, want := render(tree), "app[lib[base ghost?] base^]"; got != want {
+		t.Errorf("Dependency tree %s, want %s", got, want)
+	}
+
+	tree, err = mgr.DependencyTree("base", true)
+	if err != nil {
+		t.Fatalf("DependencyTree failed: %v", err)
+	}
+	if got, want := render(tree), "base[app lib[app^]]"; got != want {
+		t.Errorf("Reverse dependency tree %s, want %s", got, want)
+	}
+	if _, err := mgr.DependencyTree("nope", false); err == nil {
+		t.Error("Expected an error for an unknown package")
+	}
+
+	// Reinstalling keeps the flag; the shortest chain wins
+	if err := mgr.db.RecordInstallation("base", "1.0", nil); err != nil {
+		t.Fatalf("RecordInstallation failed: %v", err)
+	}
+	for name, want := range map[string]string{"app": "[app]", "lib": "[app lib]", "base": "[app base]"} {
+		chain, err := mgr.Why(name)
+		if err != nil {
+			t.Fatalf("Why(%s) failed: %v", name, err)
+		}
+		if fmt.Sprint(chain) != want {
+			t.Errorf("Why(%s) = %v, want %s", name, chain, want)
+		}
+	}
+	if _, err := mgr.Why("tool"); err == nil {
+		t.Error("Expected an error for a package that is not installed")
+	}
+	if err := mgr.SetExplicit("app", false); err != nil {
+		t.Fatalf("SetExplicit failed: %v", err)
+	}
+	if chain, err := mgr.Why("base"); err != nil || chain != nil {
+		t.Errorf("Expected no chain for an orphan, got %v, %v", chain, err)
+	}
+
+	graph, err := mgr.DependencyGraph([]string{"tool"}, false)
+	if err != nil {
+		t.Fatalf("DependencyGraph failed: %v", err)
+	}
+	if fmt.Sprint(graph.Edges) != "[{tool base}]" || len(graph.Packages) != 2 || !graph.Packages[0].Installed || graph.Packages[1].Installed {
+		t.Errorf("Unexpected graph of tool: %+v", graph)
+	}
+
+	graph, err = mgr.DependencyGraph(nil, false)
+	if err != nil {
+		t.Fatalf("DependencyGraph failed: %v", err)
+	}
+	var names []string
+	for _, pkg := range graph.Packages {
+		names = append(names, pkg.Name)
+	}
+	if fmt.Sprint(names) != "[app base ghost lib]" || len(graph.Edges) != 4 {
+		t.Errorf("Unexpected graph of the installed packages: %+v", graph)
+	}
+}
+
+func renderAll(nodes []*DependencyNode, render func(*DependencyNode) string) []string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, render(n))
+	}
+	return out
+}
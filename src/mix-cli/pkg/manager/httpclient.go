@@ -0,0 +1,256 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HTTPConfig configures the client used for http(s) repositories.
+type HTTPConfig struct {
+	// Proxy is the proxy URL for all requests. When empty the standard
+	// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables apply.
+	Proxy string `json:"proxy,omitempty"`
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string `json:"ca_file,omitempty"`
+	// ClientCert and ClientKey are a PEM certificate and key presented for
+	// mutual TLS.
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+	// Timeout bounds each step of a request: connecting, the TLS
+	// handshake, waiting for the response headers, and any stall while
+	// sending or receiving data. A download that keeps making progress
+	// may take longer.
+	Timeout Duration `json:"timeout,omitempty"`
+	// UserAgent is sent with every request.
+	UserAgent string `json:"user_agent,omitempty"`
+	// Repos holds per-repository settings keyed by URL prefix. The longest
+	// matching prefix wins.
+	Repos map[string]RepoConfig `json:"repos,omitempty"`
+}
+
+// RepoConfig holds settings that only apply to one repository.
+type RepoConfig struct {
+	// Header is the request header carrying Token; defaults to
+	// Authorization, in which case a bare token is sent as a bearer token.
+	Header string `json:"header,omitempty"`
+	// Token, or the contents of TokenFile, is the credential sent in Header.
+	Token     string `json:"token,omitempty"`
+	TokenFile string `json:"token_file,omitempty"`
+	// CAFile, ClientCert and ClientKey override the global TLS settings.
+	CAFile     string `json:"ca_file,omitempty"`
+	ClientCert string `json:"client_cert,omitempty"`
+	ClientKey  string `json:"client_key,omitempty"`
+}
+
+// Duration is a time.Duration that reads from JSON as either a Go duration
+// string ("30s") or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*d = Duration(v)
+		return nil
+	}
+
+	var secs float64
+	if err := json.Unmarshal(data, &secs); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(secs * float64(time.Second))
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// NewHTTPClient builds an http.Client from cfg.
+func NewHTTPClient(cfg HTTPConfig) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", cfg.Proxy, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	timeout := time.Duration(cfg.Timeout)
+	base, err := newTransport(proxy, timeout, cfg.CAFile, cfg.ClientCert, cfg.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &repoTransport{base: base, userAgent: cfg.UserAgent}
+	for prefix, repo := range cfg.Repos {
+		route := repoRoute{prefix: strings.TrimRight(prefix, "/"), transport: base}
+
+		if repo.CAFile != "" || repo.ClientCert != "" || repo.ClientKey != "" {
+			caFile, cert, key := cfg.CAFile, cfg.ClientCert, cfg.ClientKey
+			if repo.CAFile != "" {
+				caFile = repo.CAFile
+			}
+			if repo.ClientCert != "" || repo.ClientKey != "" {
+				cert, key = repo.ClientCert, repo.ClientKey
+			}
+			route.transport, err = newTransport(proxy, timeout, caFile, cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("repository %s: %w", prefix, err)
+			}
+		}
+
+		token := repo.Token
+		if repo.TokenFile != "" {
+			data, err := os.ReadFile(repo.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("repository %s: %w", prefix, err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token != "" {
+			route.header = repo.Header
+			if route.header == "" {
+				route.header = "Authorization"
+			}
+			route.value = token
+			if strings.EqualFold(route.header, "Authorization") && !strings.Contains(token, " ") {
+				route.value = "Bearer " + token
+			}
+		}
+
+		rt.routes = append(rt.routes, route)
+	}
+	sort.Slice(rt.routes, func(i, j int) bool { return len(rt.routes[i].prefix) > len(rt.routes[j].prefix) })
+
+	return &http.Client{Transport: rt}, nil
+}
+
+func newTransport(proxy func(*http.Request) (*url.URL, error), timeout time.Duration, caFile, certFile, keyFile string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+
+	if timeout > 0 {
+		dialer := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return &idleTimeoutConn{Conn: conn, timeout: timeout}, nil
+		}
+		t.TLSHandshakeTimeout = timeout
+		t.ResponseHeaderTimeout = timeout
+	}
+
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return t, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	t.TLSClientConfig = tlsConfig
+	return t, nil
+}
+
+// idleTimeoutConn fails a read or write that makes no progress for
+// timeout, rather than bounding the whole transfer.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// repoRoute is the transport and credential used for URLs under prefix.
+type repoRoute struct {
+	prefix    string
+	transport http.RoundTripper
+	header    string
+	value     string
+}
+
+// repoTransport dispatches requests to per-repository transports and adds
+// the user agent and credentials configured for the matching repository.
+type repoTransport struct {
+	base      http.RoundTripper
+	routes    []repoRoute
+	userAgent string
+}
+
+func (t *repoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target := req.URL.String()
+	transport := t.base
+	var header, value string
+	for _, route := range t.routes {
+		if target == route.prefix || strings.HasPrefix(target, route.prefix+"/") {
+			transport, header, value = route.transport, route.header, route.value
+			break
+		}
+	}
+
+	if t.userAgent == "" && header == "" {
+		return transport.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	return transport.RoundTrip(req)
+}
@@ -0,0 +1,234 @@
+package manager
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert creates a self-signed client certificate and key in dir
+// and returns their paths and the parsed certificate.
+func writeClientCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mix-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath, cert
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	certPath, keyPath, clientCert := writeClientCert(t, tmpDir)
+
+	var gotAuth, gotAgent string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotAgent = r.Header.Get("User-Agent")
+		io.WriteString(w, "[]")
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caPath := filepath.Join(tmpDir, "ca.pem")
+	os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644)
+
+	// Without the private CA the server is not trusted
+	client, err := NewHTTPClient(HTTPConfig{})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Error("Expected untrusted server certificate to be rejected")
+	}
+
+	// Without a client certificate the server rejects the handshake
+	client, _ = NewHTTPClient(HTTPConfig{CAFile: caPath})
+	if _, err := client.Get(srv.URL); err == nil {
+		t.Error("Expected server to require a client certificate")
+	}
+
+	client, err = NewHTTPClient(HTTPConfig{
+		Timeout:   Duration(5 * time.Second),
+		UserAgent: "mix/test",
+		Repos: map[string]RepoConfig{
+			srv.URL + "/packages": {
+				CAFile:     caPath,
+				ClientCert: certPath,
+				ClientKey:  keyPath,
+				Token:      "s3cret",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+
+	fetcher, _ := NewFetcher(srv.URL+"/packages", client)
+	body, _, err := fetcher.Fetch(context.Background(), IndexFile)
+	if err != nil {
+		t.Fatalf("Fetch over mutual TLS failed: %v", err)
+	}
+	body.Close()
+
+	if gotAuth != "Bearer s3cret" {
+		t.Errorf("Expected bearer token, got %q", gotAuth)
+	}
+	if gotAgent != "mix/test" {
+		t.Errorf("Expected user agent mix/test, got %q", gotAgent)
+	}
+}
+
+func TestHTTPClientTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow-headers":
+			time.Sleep(300 * time.Millisecond)
+		case "/stall":
+			io.WriteString(w, "partial")
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		case "/trickle":
+			// Longer than the timeout in total, but never idle for long
+			for i := 0; i < 8; i++ {
+				io.WriteString(w, "chunk")
+				w.(http.Flusher).Flush()
+				time.Sleep(25 * time.Millisecond)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{Timeout: Duration(100 * time.Millisecond)})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	get := func(path string) error {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get("/trickle"); err != nil {
+		t.Errorf("Expected a download that keeps making progress to finish, got %v", err)
+	}
+	if err := get("/slow-headers"); err == nil {
+		t.Error("Expected a timeout waiting for the response headers")
+	}
+	if err := get("/stall"); err == nil {
+		t.Error("Expected a timeout when the body stalls")
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "[]")
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(HTTPConfig{
+		Proxy: proxy.URL,
+		Repos: map[string]RepoConfig{
+			"http://repo.internal": {Header: "X-Repo-Token", Token: "abc"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://repo.internal/index.json", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request through proxy failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if proxied != "http://repo.internal/index.json" {
+		t.Errorf("Expected request to go through proxy, got %q", proxied)
+	}
+	if req.Header.Get("X-Repo-Token") != "" {
+		t.Error("Transport must not modify the caller's request")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cfg, err := LoadConfig(filepath.Join(tmpDir, "missing.json"))
+	if err != nil || cfg.Repo != "" {
+		t.Fatalf("Expected empty config for missing file, got %+v (%v)", cfg, err)
+	}
+
+	path := filepath.Join(tmpDir, "config.json")
+	os.WriteFile(path, []byte(`{
+		"repo": "https://repo.internal/packages",
+		"http": {"proxy": "http://proxy:3128", "timeout": "45s",
+			"repos": {"https://repo.internal": {"token_file": "/etc/mix/token"}}}
+	}`), 0644)
+
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.Repo != "https://repo.internal/packages" || cfg.HTTP.Proxy != "http://proxy:3128" {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+	if time.Duration(cfg.HTTP.Timeout) != 45*time.Second {
+		t.Errorf("Expected 45s timeout, got %v", time.Duration(cfg.HTTP.Timeout))
+	}
+	if cfg.HTTP.Repos["https://repo.internal"].TokenFile != "/etc/mix/token" {
+		t.Errorf("Expected per-repo token file, got %+v", cfg.HTTP.Repos)
+	}
+}
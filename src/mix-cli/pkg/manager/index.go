@@ -0,0 +1,264 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Index format v2 is a gzip-compressed JSON document: a header naming the
+// format, the generation and the signing key, followed by the packages.
+// Every time the package set changes the generation is incremented and a
+// diff from the previous generation is written, so clients that are a few
+// generations behind download only what changed. Repositories keep writing
+// the v1 index.json for older clients.
+const (
+	// IndexV2File is the v2 index at the root of a repository.
+	IndexV2File = "index.v2.json.gz"
+	// IndexV2SignatureFile holds the detached ed25519 signature of the
+	// compressed IndexV2File.
+	IndexV2SignatureFile = IndexV2File + ".sig"
+	// IndexDiffDir holds the diffs between consecutive generations.
+	IndexDiffDir = "index.v2.diffs"
+	// IndexFormat is the format version written in v2 headers.
+	IndexFormat = 2
+
+	// indexDiffRetention is how many generations of diffs are kept.
+	indexDiffRetention = 10
+)
+
+// IndexHeader identifies one generation of a v2 index.
+type IndexHeader struct {
+	Format     int       `json:"format"`
+	Generation int64     `json:"generation"`
+	Generated  time.Time `json:"generated"`
+	Repository string    `json:"repository,omitempty"`
+	// KeyID identifies the key the index is signed with, see SigningKeyID.
+	KeyID string `json:"key_id,omitempty"`
+}
+
+// IndexV2 is a complete v2 index.
+type IndexV2 struct {
+	IndexHeader
+	Packages []PackageInfo `json:"packages"`
+}
+
+// IndexDiff turns generation From of an index into the generation named
+// in its header. Diffs work per package: when any entry of a package
+// changes, Changed lists all of its entries, one per architecture, and
+// Removed names the packages that have no entries left.
+type IndexDiff struct {
+	IndexHeader
+	From    int64         `json:"from"`
+	Changed []PackageInfo `json:"changed"`
+	Removed []string      `json:"removed"`
+}
+
+// IndexOptions controls WriteIndexV2.
+type IndexOptions struct {
+	// Repository is a name for the repository recorded in the header.
+	Repository string
+	// Key, when set, signs the index and its diffs.
+	Key ed25519.PrivateKey
+}
+
+// IndexDiffFile returns the repository path of the diff from generation
+// from to the next one.
+func IndexDiffFile(from int64) string {
+	return fmt.Sprintf("%s/%d.json.gz", IndexDiffDir, from)
+}
+
+// SigningKeyID returns a short identifier for a signing key: the first 16
+// hex digits of the SHA-256 of the public key.
+func SigningKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// WriteIndexV2 writes packages as the v2 index of dir. If the previous
+// generation has the same packages, repository name and key, nothing is
+// written and its header is returned; otherwise the generation is
+// incremented and a diff from the previous generation is written, and
+// diffs older than the retention window are removed.
+func WriteIndexV2(dir string, packages []PackageInfo, opts IndexOptions) (*IndexHeader, error) {
+	path := filepath.Join(dir, IndexV2File)
+	var prev *IndexV2
+	if data, err := os.ReadFile(path); err == nil {
+		if prev, err = DecodeIndexV2(data); err != nil {
+			return nil, fmt.Errorf("previous %s: %w", IndexV2File, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	header := IndexHeader{
+		Format:     IndexFormat,
+		Generation: 1,
+		Generated:  time.Now().UTC().Truncate(time.Second),
+		Repository: opts.Repository,
+	}
+	if opts.Key != nil {
+		header.KeyID = SigningKeyID(opts.Key.Public().(ed25519.PublicKey))
+	}
+
+	if prev != nil {
+		diff, err := diffIndex(prev.Packages, packages)
+		if err != nil {
+			return nil, err
+		}
+		if len(diff.Changed) == 0 && len(diff.Removed) == 0 &&
+			prev.Repository == header.Repository && prev.KeyID == header.KeyID {
+			return &prev.IndexHeader, nil
+		}
+
+		header.Generation = prev.Generation + 1
+		diff.IndexHeader, diff.From = header, prev.Generation
+		if err := os.MkdirAll(filepath.Join(dir, IndexDiffDir), 0755); err != nil {
+			return nil, err
+		}
+		if err := writeIndexDocument(filepath.Join(dir, filepath.FromSlash(IndexDiffFile(prev.Generation))), diff, opts.Key); err != nil {
+			return nil, err
+		}
+		pruneIndexDiffs(dir, header.Generation-indexDiffRetention)
+	}
+
+	index := &IndexV2{IndexHeader: header, Packages: packages}
+	if index.Packages == nil {
+		index.Packages = []PackageInfo{}
+	}
+	if err := writeIndexDocument(path, index, opts.Key); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// diffIndex returns every entry of the packages whose entries differ
+// between prev and next, and the names of the packages next no longer
+// lists.
+func diffIndex(prev, next []PackageInfo) (*IndexDiff, error) {
+	old, err := groupIndex(prev)
+	if err != nil {
+		return nil, err
+	}
+	current, err := groupIndex(next)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &IndexDiff{Changed: []PackageInfo{}, Removed: []string{}}
+	for _, pkg := range next {
+		if !bytes.Equal(old[pkg.Name], current[pkg.Name]) {
+			diff.Changed = append(diff.Changed, pkg)
+		}
+	}
+	for name := range old {
+		if _, ok := current[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+	sort.Strings(diff.Removed)
+	return diff, nil
+}
+
+// groupIndex encodes the entries of each package in an index, so that two
+// indexes can be compared package by package.
+func groupIndex(index []PackageInfo) (map[string][]byte, error) {
+	groups := make(map[string][]byte)
+	for _, pkg := range index {
+		data, err := json.Marshal(pkg)
+		if err != nil {
+			return nil, err
+		}
+		groups[pkg.Name] = append(groups[pkg.Name], data...)
+	}
+	return groups, nil
+}
+
+// pruneIndexDiffs removes the diffs from generations before oldest.
+func pruneIndexDiffs(dir string, oldest int64) {
+	files, _ := filepath.Glob(filepath.Join(dir, IndexDiffDir, "*.json.gz"))
+	for _, file := range files {
+		from, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(file), ".json.gz"), 10, 64)
+		if err == nil && from < oldest {
+			os.Remove(file)
+			os.Remove(file + ".sig")
+		}
+	}
+}
+
+// writeIndexDocument writes v as compressed JSON to path, with a detached
+// signature in path.sig when key is set.
+func writeIndexDocument(path string, v interface{}, key ed25519.PrivateKey) error {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(zw).Encode(v); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return err
+	}
+	if key == nil {
+		os.Remove(path + ".sig")
+		return nil
+	}
+	return writeFileAtomic(path+".sig", ed25519.Sign(key, data), 0644)
+}
+
+// DecodeIndexV2 parses a compressed v2 index.
+func DecodeIndexV2(data []byte) (*IndexV2, error) {
+	var index IndexV2
+	if err := decodeIndexDocument(data, &index); err != nil {
+		return nil, err
+	}
+	if index.Format != IndexFormat {
+		return nil, fmt.Errorf("unsupported index format %d", index.Format)
+	}
+	return &index, nil
+}
+
+// DecodeIndexDiff parses a compressed v2 index diff.
+func DecodeIndexDiff(data []byte) (*IndexDiff, error) {
+	var diff IndexDiff
+	if err := decodeIndexDocument(data, &diff); err != nil {
+		return nil, err
+	}
+	if diff.Format != IndexFormat {
+		return nil, fmt.Errorf("unsupported index format %d", diff.Format)
+	}
+	return &diff, nil
+}
+
+func decodeIndexDocument(data []byte, v interface{}) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	dec := json.NewDecoder(zr)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// Read to the end so gzip verifies its checksum
+	_, err = io.Copy(io.Discard, zr)
+	return err
+}
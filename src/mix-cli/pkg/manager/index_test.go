@@ -0,0 +1,147 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexV2Generations(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir := filepath.Join(tmpDir, "repo")
+	old := writeTestPackage(t, repoDir, &PackageMetadata{Name: "tool", Version: "1.0.0", License: "MIT", Arch: "x86_64"},
+		map[string]string{"/usr/bin/tool": "v1", "/usr/share/doc/tool": "docs"})
+	base := writeTestPackage(t, repoDir, &PackageMetadata{Name: "base", Version: "1.0.0"},
+		map[string]string{"/etc/os-release": "mixos"})
+
+	writeIndex := func() *IndexHeader {
+		t.Helper()
+		index, err := BuildIndex(repoDir)
+		if err != nil {
+			t.Fatalf("BuildIndex failed: %v", err)
+		}
+		header, err := WriteIndexV2(repoDir, index, IndexOptions{Repository: "test"})
+		if err != nil {
+			t.Fatalf("WriteIndexV2 failed: %v", err)
+		}
+		return header
+	}
+
+	if header := writeIndex(); header.Generation != 1 || header.Format != IndexFormat || header.Repository != "test" {
+		t.Errorf("Unexpected first header %+v", header)
+	}
+	// Nothing changed: the generation stays
+	if header := writeIndex(); header.Generation != 1 {
+		t.Errorf("Expected an unchanged index to keep generation 1, got %d", header.Generation)
+	}
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "file://"+repoDir, filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+	mgr.SetArches(Arches{Native: "x86_64"})
+
+	summary, err := mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if summary.Generation != 1 || summary.Diffs != 0 || summary.Packages != 2 {
+		t.Errorf("Unexpected full sync summary %+v", summary)
+	}
+	tool, err := mgr.db.GetPackage("tool")
+	if err != nil {
+		t.Fatalf("GetPackage failed: %v", err)
+	}
+	if tool.License != "MIT" || tool.Arch != "x86_64" || tool.InstalledSize != int64(len("v1")+len("docs")) {
+		t.Errorf("Extended metadata not recorded: %+v", tool)
+	}
+	if len(tool.Hashes["sha512"]) != 128 || tool.Hashes["sha256"] != tool.Checksum {
+		t.Errorf("Expected sha256 and sha512 hashes, got %v", tool.Hashes)
+	}
+
+	// Two more generations: tool is upgraded and base removed, then extra added
+	os.Remove(old)
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "tool", Version: "1.1.0"},
+		map[string]string{"/usr/bin/tool": "v1.1"})
+	os.Remove(base)
+	if header := writeIndex(); header.Generation != 2 {
+		t.Errorf("Expected generation 2, got %d", header.Generation)
+	}
+	writeTestPackage(t, repoDir, &PackageMetadata{Name: "extra", Version: "0.1"},
+		map[string]string{"/usr/bin/extra": "x"})
+	writeIndex()
+
+	data, err := os.ReadFile(filepath.Join(repoDir, IndexDiffFile(1)))
+	if err != nil {
+		t.Fatalf("Expected a diff from generation 1: %v", err)
+	}
+	diff, err := DecodeIndexDiff(data)
+	if err != nil {
+		t.Fatalf("DecodeIndexDiff failed: %v", err)
+	}
+	if diff.From != 1 || diff.Generation != 2 || len(diff.Changed) != 1 || fmt.Sprint(diff.Removed) != "[base]" {
+		t.Errorf("Unexpected diff %+v", diff)
+	}
+
+	summary, err = mgr.UpdateDatabase(context.Background())
+	if err != nil {
+		t.Fatalf("UpdateDatabase failed: %v", err)
+	}
+	if summary.Generation != 3 || summary.Diffs != 2 || fmt.Sprint(summary.Added) != "[extra]" ||
+		fmt.Sprint(summary.Removed) != "[base]" || len(summary.Updated) != 1 || summary.Packages != 2 {
+		t.Errorf("Unexpected diff sync summary %+v", summary)
+	}
+
+	// Every listed hash is verified on install
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	extra, err := mgr.db.GetPackage("extra")
+	if err != nil {
+		t.Fatalf("GetPackage failed: %v", err)
+	}
+	extra.Hashes["sha512"] = strings.Repeat("0", 128)
+	if err := mgr.db.AddPackage(extra); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+	if err := mgr.Install(context.Background(), "extra"); err == nil || !strings.Contains(err.Error(), "sha512 checksum mismatch") {
+		t.Errorf("Expected a sha512 mismatch, got %v", err)
+	}
+}
+
+func TestIndexV2PrunesDiffs(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var header *IndexHeader
+	for i := 0; i < indexDiffRetention+3; i++ {
+		index := []PackageInfo{{Name: "tool", Version: fmt.Sprintf("1.%d", i)}}
+		if header, err = WriteIndexV2(tmpDir, index, IndexOptions{}); err != nil {
+			t.Fatalf("WriteIndexV2 failed: %v", err)
+		}
+	}
+
+	diffs, _ := filepath.Glob(filepath.Join(tmpDir, IndexDiffDir, "*.json.gz"))
+	if len(diffs) != indexDiffRetention {
+		t.Errorf("Expected %d diffs to be kept, got %d", indexDiffRetention, len(diffs))
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, IndexDiffFile(header.Generation-1))); err != nil {
+		t.Errorf("Expected the newest diff to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, IndexDiffFile(1))); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest diff to be pruned, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LocalPackage is a .mixpkg file on disk that can be installed without
+// going through the repository.
+type LocalPackage struct {
+	Path     string
+	Metadata *PackageMetadata
+}
+
+// AddLocalPackage reads the metadata of a .mixpkg file and makes it
+// available to ResolveDependencies and Install under its package name.
+// A local file shadows the repository entry of the same name; if several
+// files provide the same package, the one for the architecture Arches
+// prefers wins, then the highest version.
+func (m *Manager) AddLocalPackage(path string) (*LocalPackage, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := m.readPackageMetadata(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if metadata.Name == "" || metadata.Version == "" {
+		return nil, fmt.Errorf("%s: metadata.json lacks a name or version", path)
+	}
+
+	if m.local == nil {
+		m.local = make(map[string]*LocalPackage)
+	}
+	if existing, ok := m.local[metadata.Name]; ok && !m.preferLocal(metadata, existing.Metadata) {
+		return existing, nil
+	}
+
+	pkg := &LocalPackage{Path: abs, Metadata: metadata}
+	m.local[metadata.Name] = pkg
+	return pkg, nil
+}
+
+// preferLocal reports whether the package file described by candidate is
+// a better choice than current for the same package.
+func (m *Manager) preferLocal(candidate, current *PackageMetadata) bool {
+	// Architectures the system does not run rank last
+	rank := func(arch string) int {
+		if r := m.arches.rank(arch); r >= 0 {
+			return r
+		}
+		return len(m.arches.Foreign) + 2
+	}
+	if c, e := rank(candidate.Arch), rank(current.Arch); c != e {
+		return c < e
+	}
+	return compareVersions(candidate.Version, current.Version) > 0
+}
+
+// AddLocalDir registers every .mixpkg file in dir with AddLocalPackage and
+// returns the names of the packages found.
+func (m *Manager) AddLocalDir(dir string) ([]string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.mixpkg"))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, file := range files {
+		pkg, err := m.AddLocalPackage(file)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[pkg.Metadata.Name] {
+			seen[pkg.Metadata.Name] = true
+			names = append(names, pkg.Metadata.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// LocalPackages returns the local package files registered so far.
+func (m *Manager) LocalPackages() map[string]*LocalPackage {
+	return m.local
+}
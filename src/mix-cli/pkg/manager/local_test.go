@@ -0,0 +1,161 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInstallFromLocalDir(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	artifacts := filepath.Join(tmpDir, "artifacts")
+	rootDir := filepath.Join(tmpDir, "root")
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(rootDir)
+
+	writeTestPackage(t, artifacts, &PackageMetadata{Name: "lib", Version: "0.9.0"},
+		map[string]string{"/usr/lib/libfoo.so": "old"})
+	writeTestPackage(t, artifacts, &PackageMetadata{Name: "lib", Version: "1.0.0"},
+		map[string]string{"/usr/lib/libfoo.so": "new"})
+	appPath := writeTestPackage(t, artifacts, &PackageMetadata{
+		Name:         "app",
+		Version:      "1.0.0",
+		Dependencies: []string{"lib>=1.0"},
+	}, map[string]string{"/usr/bin/app": "app"})
+
+	names, err := mgr.AddLocalDir(artifacts)
+	if err != nil {
+		t.Fatalf("AddLocalDir failed: %v", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("Expected 2 local packages, got %v", names)
+	}
+
+	order, err := mgr.ResolveDependencies([]string{"app"})
+	if err != nil {
+		t.Fatalf("ResolveDependencies failed: %v", err)
+	}
+	if len(order) != 2 || order[0] != "lib" || order[1] != "app" {
+		t.Fatalf("Expected [lib app], got %v", order)
+	}
+
+	for _, pkg := range order {
+		if err := mgr.Install(context.Background(), pkg); err != nil {
+			t.Fatalf("Install %s failed: %v", pkg, err)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(rootDir, "usr/lib/libfoo.so")); string(data) != "new" {
+		t.Errorf("Expected highest local version to be installed, got %q", data)
+	}
+
+	info, err := mgr.db.GetInstalledPackage("app")
+	if err != nil {
+		t.Fatalf("GetInstalledPackage failed: %v", err)
+	}
+	if info.Origin != appPath {
+		t.Errorf("Expected origin %s, got %s", appPath, info.Origin)
+	}
+}
+
+func TestAddLocalPackageRejectsInvalidFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	bogus := filepath.Join(tmpDir, "bogus.mixpkg")
+	os.WriteFile(bogus, []byte("not a package"), 0644)
+
+	if _, err := mgr.AddLocalPackage(bogus); err == nil {
+		t.Error("Expected error for invalid package file")
+	}
+}
+
+func TestLocalPackageDependenciesRecorded(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "test.db")
+	mgr, err := New(dbPath, "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+
+	if err := mgr.db.AddPackages([]PackageInfo{{Name: "zlib", Version: "1.3"}}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	if err := mgr.db.RecordInstallation("zlib", "1.3", nil); err != nil {
+		t.Fatalf("RecordInstallation failed: %v", err)
+	}
+	if err := mgr.db.SetExplicit("zlib", false); err != nil {
+		t.Fatalf("SetExplicit failed: %v", err)
+	}
+
+	appPath := writeTestPackage(t, filepath.Join(tmpDir, "artifacts"), &PackageMetadata{
+		Name:         "app",
+		Version:      "1.0.0",
+		Dependencies: []string{"zlib>=1.2"},
+	}, map[string]string{"/usr/bin/app": "app"})
+	if _, err := mgr.AddLocalPackage(appPath); err != nil {
+		t.Fatalf("AddLocalPackage failed: %v", err)
+	}
+	if err := mgr.Install(context.Background(), "app"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+	mgr.Close()
+
+	// The local package file is no longer known to a new manager, but the
+	// dependencies recorded at install time are
+	mgr, err = New(dbPath, "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	if rdeps, err := mgr.GetReverseDependencies("zlib"); err != nil || fmt.Sprint(rdeps) != "[app]" {
+		t.Errorf("Expected app to depend on zlib, got %v, %v", rdeps, err)
+	}
+	if chain, err := mgr.Why("zlib"); err != nil || fmt.Sprint(chain) != "[app zlib]" {
+		t.Errorf("Expected zlib to be needed by app, got %v, %v", chain, err)
+	}
+
+	worldPath := filepath.Join(tmpDir, "world")
+	if err := os.WriteFile(worldPath, []byte("app\n"), 0644); err != nil {
+		t.Fatalf("Failed to write world file: %v", err)
+	}
+	world, err := mgr.LoadWorld(worldPath)
+	if err != nil {
+		t.Fatalf("LoadWorld failed: %v", err)
+	}
+	plan, err := mgr.PlanSync(world)
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if !plan.Empty() {
+		t.Errorf("Expected nothing to change, got %+v", plan)
+	}
+}
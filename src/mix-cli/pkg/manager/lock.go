@@ -0,0 +1,117 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// LockMode selects whether a Lock excludes other holders.
+type LockMode int
+
+const (
+	// SharedLock is held by commands that only read state; any number of
+	// them may run together.
+	SharedLock LockMode = iota
+	// ExclusiveLock is held by commands that change the database, the
+	// package cache or the install root.
+	ExclusiveLock
+)
+
+// lockPollInterval is how often a blocked AcquireLock retries.
+const lockPollInterval = 100 * time.Millisecond
+
+// LockedError is returned when the lock is held by another process.
+type LockedError struct {
+	Path string
+	// PID of the exclusive holder, or 0 when the lock is held shared or
+	// the holder is unknown.
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	if e.PID > 0 {
+		return fmt.Sprintf("%s is locked by another mix process (PID %d)", e.Path, e.PID)
+	}
+	return fmt.Sprintf("%s is locked by another mix process", e.Path)
+}
+
+// Lock is an advisory flock(2) lock on a file, serializing mix processes
+// that share a package database.
+type Lock struct {
+	file *os.File
+	mode LockMode
+}
+
+// AcquireLock takes the lock at path, creating the file if needed. If the
+// lock is busy it retries until timeout has passed; a negative timeout
+// waits indefinitely. The exclusive holder records its PID in the file so
+// that blocked processes can name it.
+func AcquireLock(path string, mode LockMode, timeout time.Duration) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	how := syscall.LOCK_SH
+	if mode == ExclusiveLock {
+		how = syscall.LOCK_EX
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			file.Close()
+			return nil, &LockedError{Path: path, PID: lockHolder(path)}
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	if mode == ExclusiveLock {
+		file.Truncate(0)
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &Lock{file: file, mode: mode}, nil
+}
+
+// lockHolder returns the PID recorded by the exclusive holder of path, or
+// 0 if none is recorded.
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Release drops the lock. It is safe to call on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	if l.mode == ExclusiveLock {
+		l.file.Truncate(0)
+	}
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockModes(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "lock")
+
+	// Readers share the lock
+	r1, err := AcquireLock(path, SharedLock, 0)
+	if err != nil {
+		t.Fatalf("Failed to take shared lock: %v", err)
+	}
+	r2, err := AcquireLock(path, SharedLock, 0)
+	if err != nil {
+		t.Fatalf("Failed to take second shared lock: %v", err)
+	}
+
+	var locked *LockedError
+	if _, err := AcquireLock(path, ExclusiveLock, 0); !errors.As(err, &locked) {
+		t.Fatalf("Expected LockedError while readers hold the lock, got %v", err)
+	}
+	if locked.PID != 0 {
+		t.Errorf("Expected no PID for shared holders, got %d", locked.PID)
+	}
+	r1.Release()
+	r2.Release()
+
+	w, err := AcquireLock(path, ExclusiveLock, 0)
+	if err != nil {
+		t.Fatalf("Failed to take exclusive lock: %v", err)
+	}
+
+	_, err = AcquireLock(path, SharedLock, 0)
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected LockedError while writer holds the lock, got %v", err)
+	}
+	if locked.PID != os.Getpid() {
+		t.Errorf("Expected holder PID %d, got %d", os.Getpid(), locked.PID)
+	}
+
+	// A waiting caller gets the lock once it is released
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		w.Release()
+	}()
+	w2, err := AcquireLock(path, ExclusiveLock, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Expected to acquire lock after release, got %v", err)
+	}
+	defer w2.Release()
+
+	start := time.Now()
+	if _, err := AcquireLock(path, ExclusiveLock, 3*lockPollInterval); !errors.As(err, &locked) {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+	if time.Since(start) < 3*lockPollInterval {
+		t.Error("AcquireLock returned before the timeout")
+	}
+}
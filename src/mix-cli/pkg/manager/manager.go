@@ -2,33 +2,44 @@ package manager
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 )
 
 type Manager struct {
 	db       *Database
 	repoURL  string
+	fetcher  Fetcher
 	cacheDir string
-	// optional progress channel for UI consumers
-	progressChan chan<- ProgressUpdate
-}
-
-// ProgressUpdate represents a status update emitted by Manager operations.
-type ProgressUpdate struct {
-	Stage   string  // e.g. download, verify, extract, install
-	Percent float64 // 0.0 - 1.0
-	Message string  // human readable message
+	// root is the directory package files are installed under ("/" by default)
+	root string
+	// subscribers to operation events
+	events eventBus
+	// local package files registered with AddLocalPackage, by name
+	local map[string]*LocalPackage
+	// architectures packages are installed for; forceArch installs
+	// packages for other architectures anyway
+	arches    Arches
+	forceArch bool
+	// process lock released by Close
+	lock *Lock
 }
 
 type PackageInfo struct {
@@ -39,22 +50,35 @@ type PackageInfo struct {
 	Files        []string `json:"files"`
 	Checksum     string   `json:"checksum"`
 	Size         int64    `json:"size"`
-	Installed    bool     `json:"-"`
+	Installed    bool     `json:"installed,omitempty"`
+	Origin       string   `json:"origin,omitempty"`
 	PreRemove    string   `json:"pre_remove,omitempty"`
 	PostRemove   string   `json:"post_remove,omitempty"`
+
+	Arch          string `json:"arch,omitempty"`
+	License       string `json:"license,omitempty"`
+	Homepage      string `json:"homepage,omitempty"`
+	Maintainer    string `json:"maintainer,omitempty"`
+	InstalledSize int64  `json:"installed_size,omitempty"`
+	BuildDate     string `json:"build_date,omitempty"`
+	// Hashes maps hash algorithms (sha256, sha512) to hex digests of the
+	// package file
+	Hashes map[string]string `json:"hashes,omitempty"`
 }
 
 type PackageUpgrade struct {
-	Name           string
-	CurrentVersion string
-	NewVersion     string
+	Name           string `json:"name"`
+	CurrentVersion string `json:"current_version"`
+	NewVersion     string `json:"new_version"`
 }
 
 type SearchResult struct {
-	Name        string
-	Version     string
-	Description string
-	Installed   bool
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Installed   bool   `json:"installed"`
+	// Files are the paths matching the file: filters of the query.
+	Files []string `json:"files,omitempty"`
 }
 
 type PackageMetadata struct {
@@ -68,32 +92,160 @@ type PackageMetadata struct {
 	PostInstall  string   `json:"post_install,omitempty"`
 	PreRemove    string   `json:"pre_remove,omitempty"`
 	PostRemove   string   `json:"post_remove,omitempty"`
+	PreUpgrade   string   `json:"pre_upgrade,omitempty"`
+	PostUpgrade  string   `json:"post_upgrade,omitempty"`
+	Arch         string   `json:"arch,omitempty"`
+	License      string   `json:"license,omitempty"`
+	Homepage     string   `json:"homepage,omitempty"`
+	Maintainer   string   `json:"maintainer,omitempty"`
+	BuildDate    string   `json:"build_date,omitempty"`
 }
 
 func New(dbPath, repoURL, cacheDir string) (*Manager, error) {
+	fetcher, err := NewFetcher(repoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := NewDatabase(dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	return newManager(db, fetcher, repoURL, cacheDir), nil
+}
+
+// Open is New without migrating the database, for readers that must not
+// write it. It fails with an error wrapping ErrMigrationsPending when the
+// schema is out of date.
+func Open(dbPath, repoURL, cacheDir string) (*Manager, error) {
+	fetcher, err := NewFetcher(repoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.checkSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return newManager(db, fetcher, repoURL, cacheDir), nil
+}
 
+func newManager(db *Database, fetcher Fetcher, repoURL, cacheDir string) *Manager {
 	return &Manager{
 		db:       db,
 		repoURL:  repoURL,
+		fetcher:  fetcher,
 		cacheDir: cacheDir,
-	}, nil
+		root:     "/",
+		arches:   Arches{Native: NativeArch()},
+	}
+}
+
+// SetFetcher replaces the transport used to reach the repository.
+func (m *Manager) SetFetcher(f Fetcher) {
+	m.fetcher = f
+}
+
+// SetHTTPClient makes http(s) repositories use client, e.g. one built by
+// NewHTTPClient with proxy and TLS settings.
+func (m *Manager) SetHTTPClient(client *http.Client) error {
+	fetcher, err := NewFetcher(m.repoURL, client)
+	if err != nil {
+		return err
+	}
+	m.fetcher = fetcher
+	return nil
+}
+
+// SetRoot changes the directory package files are installed under.
+// Paths recorded in the database stay relative to this root.
+func (m *Manager) SetRoot(root string) {
+	if root == "" {
+		root = "/"
+	}
+	m.root = root
+}
+
+// SetArches sets the architectures packages are installed for. The
+// native architecture defaults to NativeArch; an index that lists a
+// package for several architectures is reduced to the entry preferred by
+// Arches on UpdateDatabase.
+func (m *Manager) SetArches(a Arches) {
+	if a.Native == "" {
+		a.Native = NativeArch()
+	}
+	m.arches = a
+}
+
+// SetForceArch makes Install and Upgrade accept packages built for an
+// architecture the system does not run.
+func (m *Manager) SetForceArch(force bool) {
+	m.forceArch = force
+}
+
+// checkArch refuses a package built for an architecture the system does
+// not run, unless SetForceArch was used.
+func (m *Manager) checkArch(name, arch string) error {
+	if m.forceArch {
+		return nil
+	}
+	return m.arches.check(name, arch)
+}
+
+// repoPackage returns the repository entry of name, falling back to an
+// entry for an architecture the system does not run; checkArch decides
+// whether that one may be installed.
+func (m *Manager) repoPackage(name string) (*PackageInfo, error) {
+	info, err := m.db.GetPackage(name)
+	if err != nil {
+		if foreign, ferr := m.db.GetForeignPackage(name); ferr == nil {
+			return foreign, nil
+		}
+	}
+	return info, err
+}
+
+// checkPackageFileArch makes sure a downloaded package file is built for
+// the architecture its index entry lists.
+func checkPackageFileArch(name, indexArch, fileArch string) error {
+	if indexArch != "" && fileArch != indexArch {
+		return fmt.Errorf("%s: package file is built for %q, the index lists %s", name, fileArch, indexArch)
+	}
+	return nil
 }
 
-// SetProgressChan registers a channel to receive ProgressUpdate events.
-// Pass nil to disable progress reporting.
-func (m *Manager) SetProgressChan(ch chan<- ProgressUpdate) {
-	m.progressChan = ch
+// SetLock hands a lock taken with AcquireLock to the manager, which
+// releases it on Close.
+func (m *Manager) SetLock(l *Lock) {
+	m.lock = l
+	m.db.readOnly = l != nil && l.mode == SharedLock
 }
 
+// Close closes the database and releases the lock. An exclusive holder
+// first rebuilds the full-text search index if its writes invalidated it,
+// as shared holders may not.
 func (m *Manager) Close() error {
-	return m.db.Close()
+	if m.lock != nil && m.lock.mode == ExclusiveLock {
+		// Builds without FTS5 cannot build the index; search falls back
+		m.db.refreshSearchIndex()
+	}
+	err := m.db.Close()
+	m.lock.Release()
+	return err
 }
 
-func (m *Manager) Install(pkgName string) error {
+// Install downloads and installs a package. Cancelling ctx stops the
+// download or the staging of files and discards what was written so far;
+// once files are being moved into place the installation is completed.
+func (m *Manager) Install(ctx context.Context, pkgName string) (err error) {
+	var version string
+	m.emit(OpInstall, pkgName, Event{Type: EventStart})
+	defer func() { m.finish(OpInstall, pkgName, version, err) }()
+
 	// Check if already installed
 	installed, err := m.IsInstalled(pkgName)
 	if err != nil {
@@ -103,79 +255,95 @@ func (m *Manager) Install(pkgName string) error {
 		return fmt.Errorf("package %s is already installed", pkgName)
 	}
 
-	// Get package info from database
-	info, err := m.db.GetPackage(pkgName)
-	if err != nil {
-		return fmt.Errorf("package %s not found in database", pkgName)
-	}
-
-	// Download package
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "start", Percent: 0.0, Message: "Starting installation"}
-	}
-	pkgPath, err := m.downloadPackage(pkgName, info.Version)
-	if err != nil {
-		return fmt.Errorf("failed to download package: %w", err)
-	}
+	// Local package files take precedence over the repository
+	var pkgPath, origin, indexArch string
+	if local, ok := m.local[pkgName]; ok {
+		version, pkgPath, origin = local.Metadata.Version, local.Path, local.Path
+		if err := m.checkArch(pkgName, local.Metadata.Arch); err != nil {
+			return err
+		}
+	} else {
+		// Get package info from database
+		info, err := m.repoPackage(pkgName)
+		if err != nil {
+			return m.unknownPackage(pkgName)
+		}
+		version, indexArch = info.Version, info.Arch
+		if err := m.checkArch(pkgName, info.Arch); err != nil {
+			return err
+		}
 
-	// Verify checksum
-	if info.Checksum != "" {
-		if m.progressChan != nil {
-			m.progressChan <- ProgressUpdate{Stage: "verify", Percent: 0.25, Message: "Verifying checksum"}
+		// Download package
+		pkgPath, err = m.downloadPackage(ctx, OpInstall, info)
+		if err != nil {
+			return fmt.Errorf("failed to download package: %w", err)
 		}
-		if err := m.verifyChecksum(pkgPath, info.Checksum); err != nil {
-			os.Remove(pkgPath)
-			return fmt.Errorf("checksum verification failed: %w", err)
+
+		// Verify checksum
+		if info.Checksum != "" || len(info.Hashes) > 0 {
+			m.phase(OpInstall, pkgName, PhaseVerify, "Verifying checksum")
+			if err := m.verifyPackage(pkgPath, info); err != nil {
+				os.Remove(pkgPath)
+				return fmt.Errorf("checksum verification failed: %w", err)
+			}
 		}
 	}
 
 	// Extract and install package
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "extract", Percent: 0.5, Message: "Extracting package"}
-	}
+	m.phase(OpInstall, pkgName, PhaseExtract, "Reading package metadata")
 	metadata, err := m.extractPackage(pkgPath)
 	if err != nil {
 		return fmt.Errorf("failed to extract package: %w", err)
 	}
+	if err := checkPackageFileArch(pkgName, indexArch, metadata.Arch); err != nil {
+		return err
+	}
+	if err := m.checkPackageSpace(pkgPath); err != nil {
+		return err
+	}
+
+	// Last point at which nothing on the system has changed
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// Run pre-install script
 	if metadata.PreInstall != "" {
-		if err := m.runScript(metadata.PreInstall, "pre-install"); err != nil {
+		if err := m.runScript(OpInstall, pkgName, metadata.PreInstall, "pre-install"); err != nil {
 			return fmt.Errorf("pre-install script failed: %w", err)
 		}
 	}
 
 	// Install files
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "install", Percent: 0.75, Message: "Installing files"}
-	}
-	installedFiles, err := m.installFiles(pkgPath)
+	m.phase(OpInstall, pkgName, PhaseInstall, "Installing files")
+	installedFiles, err := m.installFiles(ctx, pkgPath)
 	if err != nil {
 		return fmt.Errorf("failed to install files: %w", err)
 	}
 
 	// Run post-install script
 	if metadata.PostInstall != "" {
-		if err := m.runScript(metadata.PostInstall, "post-install"); err != nil {
+		if err := m.runScript(OpInstall, pkgName, metadata.PostInstall, "post-install"); err != nil {
 			// Rollback on failure
-			m.removeFiles(installedFiles)
+			m.removeFiles(OpInstall, pkgName, installedFiles)
 			return fmt.Errorf("post-install script failed: %w", err)
 		}
 	}
 
 	// Record installation in database
-	if err := m.db.RecordInstallation(pkgName, info.Version, installedFiles); err != nil {
+	if err := m.db.RecordInstallationFrom(pkgName, version, origin, metadata.Dependencies, installedFiles); err != nil {
 		return fmt.Errorf("failed to record installation: %w", err)
 	}
 
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "done", Percent: 1.0, Message: "Installation complete"}
-	}
-
 	return nil
 }
 
-func (m *Manager) Remove(pkgName string, purge bool) error {
+// Remove uninstalls a package. Cancelling ctx only has an effect before
+// the first script runs or file is deleted.
+func (m *Manager) Remove(ctx context.Context, pkgName string, purge bool) (err error) {
+	m.emit(OpRemove, pkgName, Event{Type: EventStart})
+	defer func() { m.finish(OpRemove, pkgName, "", err) }()
+
 	// Check if installed
 	installed, err := m.IsInstalled(pkgName)
 	if err != nil {
@@ -194,35 +362,24 @@ func (m *Manager) Remove(pkgName string, purge bool) error {
 	// Get package metadata for scripts
 	info, _ := m.db.GetInstalledPackage(pkgName)
 
-	// Emit start
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "start", Percent: 0.0, Message: "Starting removal"}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
 	// Run pre-remove script if available
 	if info != nil && info.PreRemove != "" {
-		if m.progressChan != nil {
-			m.progressChan <- ProgressUpdate{Stage: "pre-remove", Percent: 0.1, Message: "Running pre-remove script"}
-		}
-		if err := m.runScript(info.PreRemove, "pre-remove"); err != nil {
+		if err := m.runScript(OpRemove, pkgName, info.PreRemove, "pre-remove"); err != nil {
 			return fmt.Errorf("pre-remove script failed: %w", err)
 		}
 	}
 
 	// Remove files
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "remove-files", Percent: 0.5, Message: "Removing files"}
-	}
-	if err := m.removeFiles(files); err != nil {
-		return fmt.Errorf("failed to remove files: %w", err)
-	}
+	m.phase(OpRemove, pkgName, PhaseRemove, "Removing files")
+	m.removeFiles(OpRemove, pkgName, files)
 
 	// Run post-remove script if available
 	if info != nil && info.PostRemove != "" {
-		if m.progressChan != nil {
-			m.progressChan <- ProgressUpdate{Stage: "post-remove", Percent: 0.8, Message: "Running post-remove script"}
-		}
-		if err := m.runScript(info.PostRemove, "post-remove"); err != nil {
+		if err := m.runScript(OpRemove, pkgName, info.PostRemove, "post-remove"); err != nil {
 			return fmt.Errorf("post-remove script failed: %w", err)
 		}
 	}
@@ -232,91 +389,442 @@ func (m *Manager) Remove(pkgName string, purge bool) error {
 		return fmt.Errorf("failed to update database: %w", err)
 	}
 
-	if m.progressChan != nil {
-		m.progressChan <- ProgressUpdate{Stage: "done", Percent: 1.0, Message: "Removal complete"}
-	}
-
 	return nil
 }
 
-func (m *Manager) Upgrade(pkgName string) error {
-	// Remove old version
-	if err := m.Remove(pkgName, false); err != nil {
+// Upgrade replaces an installed package with the version currently in the
+// database. The new package is downloaded and verified before anything on
+// disk is touched, its files are staged next to the old ones and renamed
+// into place, and only files that no longer ship with the new version are
+// deleted. Remove scripts are not run; pre_upgrade and post_upgrade scripts
+// receive the old and new version as arguments. Cancelling ctx before the
+// swap leaves the old version in place.
+func (m *Manager) Upgrade(ctx context.Context, pkgName string) (err error) {
+	var version string
+	m.emit(OpUpgrade, pkgName, Event{Type: EventStart})
+	defer func() { m.finish(OpUpgrade, pkgName, version, err) }()
+
+	current, err := m.db.GetInstalledPackage(pkgName)
+	if err != nil {
+		return fmt.Errorf("package %s is not installed", pkgName)
+	}
+
+	info, err := m.repoPackage(pkgName)
+	if err != nil {
+		return fmt.Errorf("package %s not found in database", pkgName)
+	}
+
+	if err := m.checkArch(pkgName, info.Arch); err != nil {
+		return err
+	}
+
+	pkgPath, err := m.downloadPackage(ctx, OpUpgrade, info)
+	if err != nil {
+		return fmt.Errorf("failed to download package: %w", err)
+	}
+
+	if info.Checksum != "" || len(info.Hashes) > 0 {
+		m.phase(OpUpgrade, pkgName, PhaseVerify, "Verifying checksum")
+		if err := m.verifyPackage(pkgPath, info); err != nil {
+			os.Remove(pkgPath)
+			return fmt.Errorf("checksum verification failed: %w", err)
+		}
+	}
+
+	m.phase(OpUpgrade, pkgName, PhaseExtract, "Reading package metadata")
+	metadata, err := m.extractPackage(pkgPath)
+	if err != nil {
+		return fmt.Errorf("failed to extract package: %w", err)
+	}
+	if err := checkPackageFileArch(pkgName, info.Arch, metadata.Arch); err != nil {
+		return err
+	}
+	if err := m.checkPackageSpace(pkgPath); err != nil {
 		return err
 	}
 
-	// Install new version
-	return m.Install(pkgName)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if metadata.PreUpgrade != "" {
+		if err := m.runScript(OpUpgrade, pkgName, metadata.PreUpgrade, "pre-upgrade", current.Version, info.Version); err != nil {
+			return fmt.Errorf("pre-upgrade script failed: %w", err)
+		}
+	}
+
+	// Stage new files next to the old ones, then swap them in with renames
+	m.phase(OpUpgrade, pkgName, PhaseInstall, "Installing files")
+	newFiles, err := m.installFiles(ctx, pkgPath)
+	if err != nil {
+		return fmt.Errorf("failed to install files: %w", err)
+	}
+
+	// Delete files that disappeared from the new version
+	m.phase(OpUpgrade, pkgName, PhaseCleanup, "Removing obsolete files")
+	shipped := make(map[string]bool, len(newFiles))
+	for _, f := range newFiles {
+		shipped[f] = true
+	}
+	var obsolete []string
+	for _, f := range current.Files {
+		if !shipped[f] {
+			obsolete = append(obsolete, f)
+		}
+	}
+	m.removeFiles(OpUpgrade, pkgName, obsolete)
+
+	if err := m.db.RecordInstallationFrom(pkgName, info.Version, "", metadata.Dependencies, newFiles); err != nil {
+		return fmt.Errorf("failed to record installation: %w", err)
+	}
+	version = info.Version
+
+	if metadata.PostUpgrade != "" {
+		if err := m.runScript(OpUpgrade, pkgName, metadata.PostUpgrade, "post-upgrade", current.Version, info.Version); err != nil {
+			return fmt.Errorf("post-upgrade script failed: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func (m *Manager) IsInstalled(pkgName string) (bool, error) {
 	return m.db.IsInstalled(pkgName)
 }
 
+// ResolveDependencies returns the packages to install for packages, in
+// installation order. Packages built for an architecture the system does
+// not run are refused unless SetForceArch was used.
 func (m *Manager) ResolveDependencies(packages []string) ([]string, error) {
-	resolver := NewResolver(m.db)
-	return resolver.Resolve(packages)
+	order, err := m.newResolver().Resolve(packages)
+	var unknown *UnknownPackageError
+	if errors.As(err, &unknown) {
+		m.explainUnknown(unknown)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range order {
+		var arch string
+		if local, ok := m.local[name]; ok {
+			arch = local.Metadata.Arch
+		} else if info, err := m.repoPackage(name); err == nil {
+			arch = info.Arch
+		}
+		if err := m.checkArch(name, arch); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
 }
 
 func (m *Manager) GetReverseDependencies(pkgName string) ([]string, error) {
 	return m.db.GetReverseDependencies(pkgName)
 }
 
-func (m *Manager) UpdateDatabase() error {
-	// Download package index from repository
-	indexURL := m.repoURL + "/index.json"
-	resp, err := http.Get(indexURL)
+// SyncSummary describes what UpdateDatabase changed.
+type SyncSummary struct {
+	Repository string `json:"repository"`
+	// NotModified is set when the repository reported that the index has
+	// not changed since the last sync, and nothing was downloaded.
+	NotModified bool `json:"not_modified"`
+	// Generation is the v2 index generation now recorded, 0 for a v1 index.
+	Generation int64 `json:"generation,omitempty"`
+	// Diffs counts the v2 index diffs applied instead of a full download.
+	Diffs    int             `json:"diffs,omitempty"`
+	Packages int             `json:"packages"`
+	Added    []string        `json:"added"`
+	Updated  []PackageChange `json:"updated"`
+	Removed  []string        `json:"removed"`
+	// Upgradable counts installed packages with a newer version available.
+	Upgradable int `json:"upgradable"`
+}
+
+// fetchedIndex is a downloaded repository index: either the complete
+// package set or the changes since the generation last synced.
+type fetchedIndex struct {
+	packages   []PackageInfo
+	validators Validators
+	generation int64
+
+	// diffs is set when only diffs were downloaded
+	diffs   []*IndexDiff
+	changed []PackageInfo
+	removed []string
+}
+
+// UpdateDatabase downloads the repository index and makes it the set of
+// available packages, keeping for each package the entry for the
+// architecture Arches prefers. The v2 index is preferred: when the repository has
+// diffs from the generation last synced only those are downloaded,
+// otherwise the full index, falling back to the v1 index.json. The index
+// is parsed and validated before the database is touched and then applied
+// with one transaction, so a failed sync leaves the previous index in
+// place; packages that left the repository are pruned. HTTP repositories
+// are asked for the full index only if it changed since the last sync. A
+// local directory repository without an index is indexed on the fly.
+// Transport errors are returned rather than papered over.
+func (m *Manager) UpdateDatabase(ctx context.Context) (summary *SyncSummary, err error) {
+	m.emit(OpUpdate, "", Event{Type: EventStart})
+	defer func() { m.finish(OpUpdate, "", "", err) }()
+
+	repo := m.fetcher.String()
+	summary = &SyncSummary{Repository: repo}
+
+	m.phase(OpUpdate, "", PhaseDownload, "Fetching package index from "+repo)
+	index, err := m.fetchIndex(ctx)
+	if errors.Is(err, ErrNotModified) {
+		summary.NotModified = true
+		if err := m.db.MarkSynced(repo); err != nil {
+			return nil, err
+		}
+		state, err := m.db.LastSync(repo)
+		if err != nil {
+			return nil, err
+		}
+		summary.Generation = state.Generation
+		return summary, m.summarizeSync(summary)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := SyncState{Validators: index.validators, Generation: index.generation, Arches: m.arches.String()}
+	var changes *IndexChanges
+	if index.diffs != nil {
+		if err := validateIndex(index.changed); err != nil {
+			return nil, fmt.Errorf("invalid package index diff: %w", err)
+		}
+		// Packages left with no entry this system runs are removed too,
+		// their entries kept as foreign
+		changed, foreign := m.arches.selectArches(index.changed)
+		removed := index.removed
+		selected := make(map[string]bool, len(changed))
+		for _, pkg := range changed {
+			selected[pkg.Name] = true
+		}
+		for _, pkg := range index.changed {
+			if !selected[pkg.Name] {
+				removed = append(removed, pkg.Name)
+				selected[pkg.Name] = true
+			}
+		}
+		m.phase(OpUpdate, "", PhaseIndex, fmt.Sprintf("Applying %d index diffs", len(index.diffs)))
+		changes, err = m.db.ApplyPackageChanges(changed, foreign, removed, repo, state)
+	} else {
+		if err := validateIndex(index.packages); err != nil {
+			return nil, fmt.Errorf("invalid package index: %w", err)
+		}
+		packages, foreign := m.arches.selectArches(index.packages)
+		m.phase(OpUpdate, "", PhaseIndex, fmt.Sprintf("Recording %d packages", len(packages)))
+		changes, err = m.db.ReplacePackages(packages, foreign, repo, state)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record package index: %w", err)
+	}
+	summary.Generation, summary.Diffs = index.generation, len(index.diffs)
+	summary.Added, summary.Updated, summary.Removed = changes.Added, changes.Updated, changes.Removed
+
+	return summary, m.summarizeSync(summary)
+}
+
+// fetchIndex downloads and parses the repository index: the v2 diffs from
+// the generation last synced if there are any, else the full v2 index, else
+// the v1 index. Full indexes are fetched conditionally on the validators
+// of the last sync when the fetcher supports it.
+func (m *Manager) fetchIndex(ctx context.Context) (*fetchedIndex, error) {
+	state, err := m.db.LastSync(m.fetcher.String())
 	if err != nil {
-		// If network fails, try to use local packages
-		return m.scanLocalPackages()
+		return nil, err
+	}
+	// The packages were selected for other architectures: start over
+	if state.Arches != m.arches.String() {
+		state = SyncState{}
+	}
+	prev := state.Validators
+
+	if state.Generation > 0 {
+		index, err := m.fetchIndexDiffs(ctx, state.Generation)
+		if err != nil || index != nil {
+			return index, err
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return m.scanLocalPackages()
+	data, validators, err := m.fetchIndexFile(ctx, IndexV2File, prev)
+	if err == nil {
+		v2, err := DecodeIndexV2(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package index: %w", err)
+		}
+		return &fetchedIndex{packages: v2.Packages, validators: validators, generation: v2.Generation}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
 	}
 
-	var packages []PackageInfo
-	if err := json.NewDecoder(resp.Body).Decode(&packages); err != nil {
-		return fmt.Errorf("failed to parse package index: %w", err)
+	data, validators, err = m.fetchIndexFile(ctx, IndexFile, prev)
+	index := &fetchedIndex{validators: validators}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		dir, ok := m.fetcher.(*dirFetcher)
+		if !ok {
+			return nil, err
+		}
+		index.packages, err = BuildIndex(dir.dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index %s: %w", dir.dir, err)
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &index.packages); err != nil {
+			return nil, fmt.Errorf("failed to parse package index: %w", err)
+		}
 	}
+	return index, nil
+}
 
-	// Update database
-	for _, pkg := range packages {
-		if err := m.db.AddPackage(&pkg); err != nil {
-			return fmt.Errorf("failed to add package %s: %w", pkg.Name, err)
+// fetchIndexFile downloads one index file, conditionally on prev when the
+// fetcher supports it. ErrNotFound and ErrNotModified are returned as is
+// for the caller to act on.
+func (m *Manager) fetchIndexFile(ctx context.Context, name string, prev Validators) ([]byte, Validators, error) {
+	var data []byte
+	var validators Validators
+	var err error
+
+	if cf, ok := m.fetcher.(ConditionalFetcher); ok {
+		var body io.ReadCloser
+		body, validators, err = cf.FetchIfModified(ctx, name, prev)
+		if err == nil {
+			data, err = io.ReadAll(&contextReader{ctx: ctx, r: body})
+			body.Close()
 		}
+	} else {
+		data, err = fetchBytes(ctx, m.fetcher, name)
 	}
 
-	return nil
+	if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotModified) {
+		return nil, Validators{}, fmt.Errorf("failed to fetch package index from %s: %w", m.fetcher, err)
+	}
+	return data, validators, err
+}
+
+// fetchIndexDiffs downloads the chain of v2 index diffs starting at
+// generation and folds them into one set of changes. It returns nil when
+// the repository has no diff from generation, because the index is
+// unchanged or the diff has been pruned.
+func (m *Manager) fetchIndexDiffs(ctx context.Context, generation int64) (*fetchedIndex, error) {
+	var diffs []*IndexDiff
+	for {
+		data, err := fetchBytes(ctx, m.fetcher, IndexDiffFile(generation))
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch package index diff from %s: %w", m.fetcher, err)
+		}
+		diff, err := DecodeIndexDiff(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse package index diff %d: %w", generation, err)
+		}
+		if diff.From != generation || diff.Generation <= generation {
+			return nil, fmt.Errorf("package index diff %d goes from generation %d to %d", generation, diff.From, diff.Generation)
+		}
+		diffs = append(diffs, diff)
+		generation = diff.Generation
+	}
+	if len(diffs) == 0 {
+		return nil, nil
+	}
+
+	// A diff lists all entries of each package it touches, so the last
+	// diff touching a package has its current entries
+	changed := make(map[string][]PackageInfo)
+	removed := make(map[string]bool)
+	for _, diff := range diffs {
+		entries := make(map[string][]PackageInfo)
+		for _, pkg := range diff.Changed {
+			entries[pkg.Name] = append(entries[pkg.Name], pkg)
+		}
+		for name, pkgs := range entries {
+			changed[name] = pkgs
+			delete(removed, name)
+		}
+		for _, name := range diff.Removed {
+			delete(changed, name)
+			removed[name] = true
+		}
+	}
+
+	index := &fetchedIndex{diffs: diffs, generation: generation, changed: []PackageInfo{}}
+	for _, pkgs := range changed {
+		index.changed = append(index.changed, pkgs...)
+	}
+	for name := range removed {
+		index.removed = append(index.removed, name)
+	}
+	sort.Slice(index.changed, func(i, j int) bool {
+		if index.changed[i].Name != index.changed[j].Name {
+			return index.changed[i].Name < index.changed[j].Name
+		}
+		return index.changed[i].Arch < index.changed[j].Arch
+	})
+	sort.Strings(index.removed)
+	return index, nil
 }
 
-func (m *Manager) scanLocalPackages() error {
-	// Scan cache directory for local packages
-	pattern := filepath.Join(m.cacheDir, "*.mixpkg")
-	files, err := filepath.Glob(pattern)
+// summarizeSync fills in the package and upgrade counts of summary.
+func (m *Manager) summarizeSync(summary *SyncSummary) error {
+	count, err := m.db.CountPackages()
 	if err != nil {
 		return err
 	}
+	upgrades, err := m.GetUpgradablePackages()
+	if err != nil {
+		return err
+	}
+	summary.Packages, summary.Upgradable = count, len(upgrades)
+	return nil
+}
 
-	for _, file := range files {
-		metadata, err := m.readPackageMetadata(file)
-		if err != nil {
-			continue
+// validateIndex rejects an index with an entry that lacks a usable name or
+// version, lists a package twice for the same architecture or has an
+// empty dependency. Name, version and arch make up the package file name,
+// so none of them may hold a path separator.
+func validateIndex(packages []PackageInfo) error {
+	seen := make(map[string]bool, len(packages))
+	for i, pkg := range packages {
+		switch {
+		case pkg.Name == "":
+			return fmt.Errorf("entry %d has no name", i+1)
+		case strings.ContainsAny(pkg.Name, "/\\ \t\n"):
+			return fmt.Errorf("entry %d has an invalid name %q", i+1, pkg.Name)
+		case pkg.Version == "":
+			return fmt.Errorf("%s has no version", pkg.Name)
+		case strings.ContainsAny(pkg.Version, "/\\ \t\n") || strings.Contains(pkg.Version, ".."):
+			return fmt.Errorf("%s has an invalid version %q", pkg.Name, pkg.Version)
+		case strings.ContainsAny(pkg.Arch, "/\\: \t\n"):
+			return fmt.Errorf("%s has an invalid arch %q", pkg.Name, pkg.Arch)
 		}
 
-		info := &PackageInfo{
-			Name:         metadata.Name,
-			Version:      metadata.Version,
-			Description:  metadata.Description,
-			Dependencies: metadata.Dependencies,
-			Files:        metadata.Files,
-			Checksum:     metadata.Checksum,
+		// An entry without an arch counts as noarch
+		arch := pkg.Arch
+		if arch == "" {
+			arch = NoArch
 		}
+		key := indexKey(pkg.Name, arch)
+		if seen[key] {
+			return fmt.Errorf("%s (%s) is listed more than once", pkg.Name, arch)
+		}
+		seen[key] = true
 
-		m.db.AddPackage(info)
+		for _, dep := range pkg.Dependencies {
+			if parseDependency(dep) == "" {
+				return fmt.Errorf("%s has an empty dependency", pkg.Name)
+			}
+		}
 	}
-
 	return nil
 }
 
@@ -382,7 +890,7 @@ func (m *Manager) GetPackageInfo(pkgName string) (*PackageInfo, error) {
 	// Try available packages
 	info, err = m.db.GetPackage(pkgName)
 	if err != nil {
-		return nil, fmt.Errorf("package %s not found", pkgName)
+		return nil, m.unknownPackage(pkgName)
 	}
 
 	return info, nil
@@ -392,8 +900,11 @@ func (m *Manager) GetPackageFiles(pkgName string) ([]string, error) {
 	return m.db.GetInstalledFiles(pkgName)
 }
 
-func (m *Manager) downloadPackage(name, version string) (string, error) {
-	pkgFile := fmt.Sprintf("%s-%s.mixpkg", name, version)
+// downloadPackage fetches the package file of a repository entry into the
+// cache, unless it is already there, and returns its path.
+func (m *Manager) downloadPackage(ctx context.Context, op string, info *PackageInfo) (string, error) {
+	name := info.Name
+	pkgFile := PackageFileName(info.Name, info.Version, info.Arch)
 	pkgPath := filepath.Join(m.cacheDir, pkgFile)
 
 	// Check if already cached
@@ -401,60 +912,93 @@ func (m *Manager) downloadPackage(name, version string) (string, error) {
 		return pkgPath, nil
 	}
 
-	// Download from repository
-	url := fmt.Sprintf("%s/%s", m.repoURL, pkgFile)
-	resp, err := http.Get(url)
-	if err != nil {
-		// Try local package
-		localPath := filepath.Join(m.cacheDir, pkgFile)
-		if _, err := os.Stat(localPath); err == nil {
-			return localPath, nil
-		}
-		return "", fmt.Errorf("failed to download package: %w", err)
+	if err := os.MkdirAll(m.cacheDir, 0755); err != nil {
+		return "", err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("package not found in repository (HTTP %d)", resp.StatusCode)
+	m.phase(op, name, PhaseDownload, "Downloading "+pkgFile)
+	if err := fetchFile(ctx, m.fetcher, pkgFile, pkgPath, "", m.progressReporter(op, name, PhaseDownload)); err != nil {
+		return "", err
 	}
 
-	// Create cache directory
-	os.MkdirAll(m.cacheDir, 0755)
+	return pkgPath, nil
+}
+
+// hashAlgorithms are the hash algorithms package files can be verified
+// with; index entries may list others, which are ignored.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// verifyPackage checks a downloaded package against its checksum and every
+// hash of a supported algorithm in its index entry.
+func (m *Manager) verifyPackage(path string, info *PackageInfo) error {
+	expected := make(map[string]string)
+	for alg, digest := range info.Hashes {
+		if _, ok := hashAlgorithms[alg]; ok {
+			expected[alg] = digest
+		}
+	}
+	if info.Checksum != "" {
+		expected["sha256"] = info.Checksum
+	}
+	if len(expected) == 0 {
+		return nil
+	}
 
-	// Save to cache
-	out, err := os.Create(pkgPath)
+	actual, err := fileHashes(path)
 	if err != nil {
-		return "", err
+		return err
+	}
+	algs := make([]string, 0, len(expected))
+	for alg := range expected {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+	for _, alg := range algs {
+		if actual[alg] != expected[alg] {
+			return fmt.Errorf("%s checksum mismatch: expected %s, got %s", alg, expected[alg], actual[alg])
+		}
 	}
-	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
+	return nil
+}
+
+// fileChecksum returns the hex encoded SHA-256 digest of a file.
+func fileChecksum(path string) (string, error) {
+	hashes, err := fileHashes(path)
 	if err != nil {
-		os.Remove(pkgPath)
 		return "", err
 	}
-
-	return pkgPath, nil
+	return hashes["sha256"], nil
 }
 
-func (m *Manager) verifyChecksum(path, expected string) error {
+// fileHashes returns the hex encoded digests of a file for every supported
+// hash algorithm, reading it once.
+func fileHashes(path string) (map[string]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return err
+	hashers := make(map[string]hash.Hash, len(hashAlgorithms))
+	writers := make([]io.Writer, 0, len(hashAlgorithms))
+	for alg, newHash := range hashAlgorithms {
+		h := newHash()
+		hashers[alg] = h
+		writers = append(writers, h)
 	}
-
-	actual := hex.EncodeToString(h.Sum(nil))
-	if actual != expected {
-		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
 	}
 
-	return nil
+	digests := make(map[string]string, len(hashers))
+	for alg, h := range hashers {
+		digests[alg] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, nil
 }
 
 func (m *Manager) extractPackage(pkgPath string) (*PackageMetadata, error) {
@@ -497,7 +1041,23 @@ func (m *Manager) readPackageMetadata(pkgPath string) (*PackageMetadata, error)
 	return nil, fmt.Errorf("metadata.json not found in package")
 }
 
-func (m *Manager) installFiles(pkgPath string) ([]string, error) {
+// stagedFile is a package file written under a temporary name next to its
+// final location, waiting to be renamed over it.
+type stagedFile struct {
+	tmp    string
+	target string
+}
+
+// installFiles extracts the payload of a package under the install root and
+// returns the installed paths relative to that root. Regular files and
+// symlinks are first written to temporary names in their target directory
+// and only renamed into place once the whole archive has been unpacked, so
+// a broken archive or cancelled ctx leaves existing files untouched and
+// running executables are replaced instead of overwritten. The files they
+// replace are kept aside until every rename has succeeded; if one fails,
+// they are put back and the new files removed, so the install root is
+// left as it was.
+func (m *Manager) installFiles(ctx context.Context, pkgPath string) ([]string, error) {
 	f, err := os.Open(pkgPath)
 	if err != nil {
 		return nil, err
@@ -511,85 +1071,191 @@ func (m *Manager) installFiles(pkgPath string) ([]string, error) {
 	defer gzr.Close()
 
 	tr := tar.NewReader(gzr)
+	var staged []stagedFile
 	var installedFiles []string
 
+	discard := func() {
+		for _, s := range staged {
+			os.Remove(s.tmp)
+		}
+	}
+
 	for {
+		if err := ctx.Err(); err != nil {
+			discard()
+			return nil, err
+		}
+
 		header, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return installedFiles, err
-		}
-
-		// Skip metadata and scripts
-		if header.Name == "metadata.json" || strings.HasPrefix(header.Name, "scripts/") {
-			continue
-		}
-
-		// Handle files/ prefix
-		name := header.Name
-		if strings.HasPrefix(name, "files/") {
-			name = strings.TrimPrefix(name, "files/")
-		}
-		if strings.HasPrefix(name, "./files/") {
-			name = strings.TrimPrefix(name, "./files/")
+			discard()
+			return nil, err
 		}
 
-		if name == "" || name == "." {
+		name := packagePath(header.Name)
+		if name == "" {
 			continue
 		}
 
-		target := "/" + name
+		target := m.rootPath(name)
 
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
-				return installedFiles, err
+				discard()
+				return nil, err
 			}
 		case tar.TypeReg:
 			dir := filepath.Dir(target)
 			if err := os.MkdirAll(dir, 0755); err != nil {
-				return installedFiles, err
+				discard()
+				return nil, err
 			}
 
-			outFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
+			tmp := stagingPath(target)
+			outFile, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode))
 			if err != nil {
-				return installedFiles, err
+				discard()
+				return nil, err
 			}
+			staged = append(staged, stagedFile{tmp: tmp, target: target})
 
-			if _, err := io.Copy(outFile, tr); err != nil {
+			if _, err := io.Copy(outFile, &contextReader{ctx: ctx, r: tr}); err != nil {
 				outFile.Close()
-				return installedFiles, err
+				discard()
+				return nil, err
 			}
 			outFile.Close()
-			installedFiles = append(installedFiles, target)
+			// OpenFile applies the umask; restore the mode from the archive
+			os.Chmod(tmp, os.FileMode(header.Mode))
+			installedFiles = append(installedFiles, name)
 
 		case tar.TypeSymlink:
-			os.Remove(target)
-			if err := os.Symlink(header.Linkname, target); err != nil {
-				return installedFiles, err
+			dir := filepath.Dir(target)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				discard()
+				return nil, err
 			}
-			installedFiles = append(installedFiles, target)
+
+			tmp := stagingPath(target)
+			os.Remove(tmp)
+			if err := os.Symlink(header.Linkname, tmp); err != nil {
+				discard()
+				return nil, err
+			}
+			staged = append(staged, stagedFile{tmp: tmp, target: target})
+			installedFiles = append(installedFiles, name)
 		}
 	}
 
+	// backups[i] is the file staged[i] replaced, or "" if there was none
+	backups := make([]string, 0, len(staged))
+	rollback := func(err error) ([]string, error) {
+		restoreFiles(staged[:len(backups)], backups)
+		discard()
+		return nil, err
+	}
+	for _, s := range staged {
+		backup := ""
+		if fi, err := os.Lstat(s.target); err == nil && !fi.IsDir() {
+			backup = backupPath(s.target)
+			if err := os.Rename(s.target, backup); err != nil {
+				return rollback(err)
+			}
+		}
+		backups = append(backups, backup)
+		if err := os.Rename(s.tmp, s.target); err != nil {
+			return rollback(err)
+		}
+	}
+
+	for _, backup := range backups {
+		if backup != "" {
+			os.Remove(backup)
+		}
+	}
 	return installedFiles, nil
 }
 
-func (m *Manager) removeFiles(files []string) error {
+// restoreFiles undoes the renames of installFiles: each target is removed
+// and the file it replaced, if any, is moved back.
+func restoreFiles(staged []stagedFile, backups []string) {
+	for i := len(staged) - 1; i >= 0; i-- {
+		if backups[i] == "" {
+			if _, err := os.Lstat(staged[i].tmp); os.IsNotExist(err) {
+				os.Remove(staged[i].target)
+			}
+			continue
+		}
+		os.Rename(backups[i], staged[i].target)
+	}
+}
+
+// packagePath maps a tar entry name to its absolute install path, or ""
+// for entries that are not part of the payload (metadata, scripts, the
+// files/ directory itself, or names escaping the root).
+func packagePath(entry string) string {
+	name := strings.TrimPrefix(entry, "./")
+	if name == "metadata.json" || strings.HasPrefix(name, "scripts/") {
+		return ""
+	}
+
+	name = strings.TrimPrefix(name, "files/")
+	if name == "files" {
+		return ""
+	}
+
+	name = filepath.Clean("/" + name)
+	if name == "/" {
+		return ""
+	}
+	return name
+}
+
+// stagingPath returns the temporary name a file is written to before it is
+// renamed over target. It lives in the same directory so the rename stays
+// on one filesystem.
+func stagingPath(target string) string {
+	return filepath.Join(filepath.Dir(target), ".mix-new-"+filepath.Base(target))
+}
+
+// backupPath returns the name the file at target is moved to while a new
+// version is renamed into its place.
+func backupPath(target string) string {
+	return filepath.Join(filepath.Dir(target), ".mix-old-"+filepath.Base(target))
+}
+
+// rootPath maps a recorded package path to its location on disk.
+func (m *Manager) rootPath(path string) string {
+	if m.root == "" || m.root == "/" {
+		return path
+	}
+	return filepath.Join(m.root, path)
+}
+
+// removeFiles deletes files under the install root, deepest first. It is
+// best effort: a file that cannot be deleted is reported as a warning and
+// left behind.
+func (m *Manager) removeFiles(op, pkg string, files []string) {
 	// Remove files in reverse order (deepest first)
 	for i := len(files) - 1; i >= 0; i-- {
-		path := files[i]
+		path := m.rootPath(files[i])
 		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			m.warn(op, pkg, fmt.Sprintf("failed to remove %s: %v", files[i], err))
 			// Try to remove directory if empty
 			os.Remove(filepath.Dir(path))
 		}
 	}
-	return nil
 }
 
-func (m *Manager) runScript(script, name string) error {
+// runScript runs a package script with /bin/sh, passing args as positional
+// parameters. Its output is published line by line as EventOutput.
+func (m *Manager) runScript(op, pkg, script, name string, args ...string) error {
+	m.phase(op, pkg, PhaseScript, "Running "+name+" script")
+
 	// include name in temp filename pattern to avoid unused param warnings
 	pattern := "mix-script-"
 	if name != "" {
@@ -608,11 +1274,47 @@ func (m *Manager) runScript(script, name string) error {
 
 	os.Chmod(tmpFile.Name(), 0755)
 
-	cmd := exec.Command("/bin/sh", tmpFile.Name())
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd := exec.Command("/bin/sh", append([]string{tmpFile.Name()}, args...)...)
+	// The same writer for both streams keeps their lines in order
+	output := &lineWriter{emit: func(line string) {
+		m.emit(op, pkg, Event{Type: EventOutput, Phase: PhaseScript, Message: line})
+	}}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Keep a terminal Ctrl-C from killing the script halfway; mix stops at
+	// the next safe point after it finishes
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Run()
+	output.Flush()
+	return err
+}
 
-	return cmd.Run()
+// lineWriter passes each complete line written to it to emit.
+type lineWriter struct {
+	buf  []byte
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emits a final line that was not terminated by a newline.
+func (w *lineWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
 }
 
 func compareVersions(v1, v2 string) int {
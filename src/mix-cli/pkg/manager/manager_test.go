@@ -1,6 +1,10 @@
 package manager
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -50,6 +54,33 @@ func TestCompareVersions(t *testing.T) {
 	}
 }
 
+func TestValidateIndex(t *testing.T) {
+	if err := validateIndex([]PackageInfo{
+		{Name: "zlib", Version: "1.3.1-r2", Arch: "x86_64"},
+		{Name: "zlib", Version: "1.3.1-r2", Arch: "aarch64"},
+	}); err != nil {
+		t.Errorf("Expected a valid index, got %v", err)
+	}
+
+	invalid := []PackageInfo{
+		{Version: "1.0"},
+		{Name: "../etc", Version: "1.0"},
+		{Name: `..\x`, Version: "1.0"},
+		{Name: "zlib"},
+		{Name: "zlib", Version: "../../x"},
+		{Name: "zlib", Version: `..\x`},
+		{Name: "zlib", Version: "1.0 beta"},
+		{Name: "zlib", Version: "1..0"},
+		{Name: "zlib", Version: "1.0", Arch: "x86_64/../.."},
+		{Name: "zlib", Version: "1.0", Dependencies: []string{">=1.0"}},
+	}
+	for _, pkg := range invalid {
+		if err := validateIndex([]PackageInfo{pkg}); err == nil {
+			t.Errorf("Expected %+v to be rejected", pkg)
+		}
+	}
+}
+
 func TestIsInstalled(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "mix-test-*")
 	if err != nil {
@@ -141,3 +172,288 @@ func TestSearch(t *testing.T) {
 		t.Errorf("Expected test-package, got %s", results[0].Name)
 	}
 }
+
+// writeTestPackage builds name-version.mixpkg in dir from files, a map of
+// install paths to contents, and returns the package path.
+func writeTestPackage(t *testing.T, dir string, metadata *PackageMetadata, files map[string]string) string {
+	t.Helper()
+
+	srcDir, err := os.MkdirTemp("", "mix-pkg-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	for path, content := range files {
+		full := filepath.Join(srcDir, "files", path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0755); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		metadata.Files = append(metadata.Files, path)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	pkgPath := filepath.Join(dir, PackageFileName(metadata.Name, metadata.Version, metadata.Arch))
+	if err := CreatePackage(srcDir, pkgPath, metadata); err != nil {
+		t.Fatalf("Failed to create package: %v", err)
+	}
+	return pkgPath
+}
+
+func TestUpgradeSwapsFiles(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	rootDir := filepath.Join(tmpDir, "root")
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(rootDir)
+
+	marker := filepath.Join(tmpDir, "scripts.log")
+	writeTestPackage(t, cacheDir, &PackageMetadata{
+		Name:      "tool",
+		Version:   "1.0.0",
+		PreRemove: "echo pre-remove >> " + marker,
+	}, map[string]string{
+		"/usr/bin/tool":     "v1",
+		"/usr/share/tool/a": "old",
+	})
+	writeTestPackage(t, cacheDir, &PackageMetadata{
+		Name:        "tool",
+		Version:     "2.0.0",
+		PreUpgrade:  "echo pre-upgrade $1 $2 >> " + marker,
+		PostUpgrade: "echo post-upgrade >> " + marker,
+	}, map[string]string{
+		"/usr/bin/tool":     "v2",
+		"/usr/share/tool/b": "new",
+	})
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "1.0.0"})
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "2.0.0"})
+	if err := mgr.Upgrade(context.Background(), "tool"); err != nil {
+		t.Fatalf("Upgrade failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(rootDir, "usr/bin/tool"))
+	if err != nil || string(data) != "v2" {
+		t.Errorf("Expected upgraded binary, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "usr/share/tool/a")); !os.IsNotExist(err) {
+		t.Error("Expected obsolete file to be removed")
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "usr/share/tool/b")); err != nil {
+		t.Errorf("Expected new file to be installed: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(rootDir, "usr/bin/.mix-new-*")); len(leftovers) != 0 {
+		t.Errorf("Expected no staging files, got %v", leftovers)
+	}
+
+	info, err := mgr.db.GetInstalledPackage("tool")
+	if err != nil {
+		t.Fatalf("GetInstalledPackage failed: %v", err)
+	}
+	if info.Version != "2.0.0" {
+		t.Errorf("Expected version 2.0.0, got %s", info.Version)
+	}
+	if len(info.Files) != 2 {
+		t.Errorf("Expected 2 recorded files, got %v", info.Files)
+	}
+
+	log, _ := os.ReadFile(marker)
+	if string(log) != "pre-upgrade 1.0.0 2.0.0\npost-upgrade\n" {
+		t.Errorf("Unexpected script log: %q", log)
+	}
+}
+
+func TestUpgradeRestoresFilesOnSwapFailure(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	rootDir := filepath.Join(tmpDir, "root")
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(rootDir)
+
+	writeTestPackage(t, cacheDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1", "/usr/lib/libtool.so": "v1"})
+	// Files are swapped in archive order, so /usr/share/tool comes last
+	writeTestPackage(t, cacheDir, &PackageMetadata{Name: "tool", Version: "2.0.0"},
+		map[string]string{"/usr/bin/tool": "v2", "/usr/lib/libtool.so": "v2", "/usr/lib/new.so": "v2", "/usr/share/tool": "v2"})
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "1.0.0"})
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	// A directory in the way of the last file makes its rename fail
+	if err := os.MkdirAll(filepath.Join(rootDir, "usr/share/tool/data"), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "2.0.0"})
+	if err := mgr.Upgrade(context.Background(), "tool"); err == nil {
+		t.Fatal("Expected upgrade to fail")
+	}
+
+	for path, want := range map[string]string{"usr/bin/tool": "v1", "usr/lib/libtool.so": "v1"} {
+		if data, err := os.ReadFile(filepath.Join(rootDir, path)); err != nil || string(data) != want {
+			t.Errorf("Expected %s to be restored, got %q (%v)", path, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "usr/lib/new.so")); !os.IsNotExist(err) {
+		t.Error("Expected the new file to be removed")
+	}
+	for _, dir := range []string{"usr/bin", "usr/lib", "usr/share"} {
+		if leftovers, _ := filepath.Glob(filepath.Join(rootDir, dir, ".mix-*")); len(leftovers) != 0 {
+			t.Errorf("Expected no staging or backup files, got %v", leftovers)
+		}
+	}
+	if info, err := mgr.db.GetInstalledPackage("tool"); err != nil || info.Version != "1.0.0" {
+		t.Errorf("Expected tool 1.0.0 to stay installed, got %+v, %v", info, err)
+	}
+}
+
+func TestUpgradeKeepsOldVersionOnDownloadFailure(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	rootDir := filepath.Join(tmpDir, "root")
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://127.0.0.1:1", cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(rootDir)
+
+	writeTestPackage(t, cacheDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "1.0.0"})
+	if err := mgr.Install(context.Background(), "tool"); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "2.0.0"})
+	if err := mgr.Upgrade(context.Background(), "tool"); err == nil {
+		t.Fatal("Expected upgrade to fail without the new package")
+	}
+
+	if data, err := os.ReadFile(filepath.Join(rootDir, "usr/bin/tool")); err != nil || string(data) != "v1" {
+		t.Errorf("Expected old binary to remain, got %q (%v)", data, err)
+	}
+	installed, _ := mgr.IsInstalled("tool")
+	if !installed {
+		t.Error("Expected package to stay installed")
+	}
+}
+
+func TestInstallCancelledDuringDownload(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Send part of the package, cancel, then stall until the client leaves
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1048576")
+		w.Write(make([]byte, 4096))
+		w.(http.Flusher).Flush()
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), srv.URL, cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(filepath.Join(tmpDir, "root"))
+
+	mgr.db.AddPackage(&PackageInfo{Name: "tool", Version: "1.0.0"})
+	err = mgr.Install(ctx, "tool")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(cacheDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected no partial download in cache, found %d entries", len(entries))
+	}
+	if installed, _ := mgr.IsInstalled("tool"); installed {
+		t.Error("Cancelled package must not be recorded as installed")
+	}
+}
+
+func TestInstallCancelledBeforeChanges(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rootDir := filepath.Join(tmpDir, "root")
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://127.0.0.1:1", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	mgr.SetRoot(rootDir)
+
+	path := writeTestPackage(t, filepath.Join(tmpDir, "pkgs"), &PackageMetadata{
+		Name:       "tool",
+		Version:    "1.0.0",
+		PreInstall: "touch " + filepath.Join(tmpDir, "ran"),
+	}, map[string]string{"/usr/bin/tool": "v1"})
+	if _, err := mgr.AddLocalPackage(path); err != nil {
+		t.Fatalf("AddLocalPackage failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := mgr.Install(ctx, "tool"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "ran")); err == nil {
+		t.Error("pre-install script ran after cancellation")
+	}
+	if _, err := os.Stat(rootDir); err == nil {
+		t.Error("Expected install root to be untouched")
+	}
+}
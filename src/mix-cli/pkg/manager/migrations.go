@@ -0,0 +1,470 @@
+package manager
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// migration is one step of the database schema history. Migrations are
+// applied in version order, each in its own transaction, and are never
+// edited once released: schema changes always add a new migration.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+// migrations is the ordered schema history. Version 1 is the schema mix
+// shipped before versioning existed, written so that it is a no-op on
+// databases that already have it.
+var migrations = []migration{
+	{1, "initial schema", migrateInitialSchema},
+	{2, "record package origin", migrateInstalledOrigin},
+	{3, "normalize dependencies and files", migrateNormalizeLists},
+	{4, "record repository sync state", migrateSyncState},
+	{5, "record extended package metadata", migrateExtendedMetadata},
+	{6, "record architectures of the synced index", migrateSyncArches},
+	{7, "track the full-text search index", migrateSearchIndex},
+	{8, "record explicitly installed packages", migrateExplicit},
+	{9, "record dependencies of installed packages", migrateInstalledDeps},
+	{10, "record index entries for other architectures", migrateForeignPackages},
+}
+
+// SchemaVersion is the schema version this build of mix expects.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// MigrationStatus describes the schema state of a database.
+type MigrationStatus struct {
+	Current int
+	Latest  int
+	Pending []string
+}
+
+// UpToDate reports whether no migrations are pending.
+func (s *MigrationStatus) UpToDate() bool {
+	return s.Current == s.Latest
+}
+
+func migrateInitialSchema(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS packages (
+		name TEXT PRIMARY KEY,
+		version TEXT NOT NULL,
+		description TEXT,
+		dependencies TEXT,
+		files TEXT,
+		checksum TEXT,
+		size INTEGER DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS installed (
+		name TEXT PRIMARY KEY,
+		version TEXT NOT NULL,
+		install_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+		files TEXT
+	);
+
+	CREATE TABLE IF NOT EXISTS files (
+		path TEXT PRIMARY KEY,
+		package TEXT NOT NULL,
+		FOREIGN KEY (package) REFERENCES installed(name)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_files_package ON files(package);
+	CREATE INDEX IF NOT EXISTS idx_packages_name ON packages(name);
+	`)
+	return err
+}
+
+func migrateInstalledOrigin(tx *sql.Tx) error {
+	// Unversioned databases from the first origin-tracking builds already
+	// have the column
+	return addColumnIfMissing(tx, "installed", "origin", "TEXT DEFAULT ''")
+}
+
+// migrateNormalizeLists moves the JSON dependency and file lists into
+// indexed tables. The JSON columns are cleared but not dropped, so older
+// builds can still open the database.
+func migrateNormalizeLists(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS package_deps (
+		package TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		spec TEXT NOT NULL,
+		dep_name TEXT NOT NULL,
+		PRIMARY KEY (package, position)
+	);
+
+	CREATE TABLE IF NOT EXISTS package_files (
+		package TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		PRIMARY KEY (package, position)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_package_deps_name ON package_deps(dep_name);
+	CREATE INDEX IF NOT EXISTS idx_package_files_path ON package_files(path);
+	`)
+	if err != nil {
+		return err
+	}
+
+	type lists struct {
+		name        string
+		deps, files []string
+	}
+	var packages []lists
+
+	rows, err := tx.Query(`SELECT name, COALESCE(dependencies, ''), COALESCE(files, '') FROM packages`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l lists
+		var deps, files string
+		if err := rows.Scan(&l.name, &deps, &files); err != nil {
+			rows.Close()
+			return err
+		}
+		json.Unmarshal([]byte(deps), &l.deps)
+		json.Unmarshal([]byte(files), &l.files)
+		packages = append(packages, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range packages {
+		if err := writePackageLists(tx, l.name, l.deps, l.files); err != nil {
+			return err
+		}
+	}
+
+	// Installed files already have a table; fill in any rows that only
+	// exist in the JSON column
+	var installed []lists
+	rows, err = tx.Query(`SELECT name, COALESCE(files, '') FROM installed`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var l lists
+		var files string
+		if err := rows.Scan(&l.name, &files); err != nil {
+			rows.Close()
+			return err
+		}
+		json.Unmarshal([]byte(files), &l.files)
+		installed = append(installed, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, l := range installed {
+		for _, path := range l.files {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO files (path, package) VALUES (?, ?)`, path, l.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`
+	UPDATE packages SET dependencies = NULL, files = NULL;
+	UPDATE installed SET files = NULL;
+	`)
+	return err
+}
+
+// migrateSyncState adds the validators of the last index download, used
+// for conditional requests by UpdateDatabase.
+func migrateSyncState(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS repo_sync (
+		repository TEXT PRIMARY KEY,
+		etag TEXT NOT NULL DEFAULT '',
+		last_modified TEXT NOT NULL DEFAULT '',
+		synced_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// migrateExtendedMetadata adds the fields of index format v2: the extra
+// package columns, the package hashes and the synced index generation.
+func migrateExtendedMetadata(tx *sql.Tx) error {
+	columns := []struct{ name, decl string }{
+		{"arch", "TEXT NOT NULL DEFAULT ''"},
+		{"license", "TEXT NOT NULL DEFAULT ''"},
+		{"homepage", "TEXT NOT NULL DEFAULT ''"},
+		{"maintainer", "TEXT NOT NULL DEFAULT ''"},
+		{"installed_size", "INTEGER NOT NULL DEFAULT 0"},
+		{"build_date", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range columns {
+		if err := addColumnIfMissing(tx, "packages", col.name, col.decl); err != nil {
+			return err
+		}
+	}
+	if err := addColumnIfMissing(tx, "repo_sync", "generation", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS package_hashes (
+		package TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		digest TEXT NOT NULL,
+		PRIMARY KEY (package, algorithm)
+	)`)
+	return err
+}
+
+// migrateSyncArches records which architectures the synced index was
+// reduced to, so that changing them forces a full sync.
+func migrateSyncArches(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "repo_sync", "arches", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateSearchIndex adds the table recording that the full-text search
+// index is up to date. The index itself is created on first search, as
+// only builds with FTS5 can create it.
+func migrateSearchIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS search_index (built_at DATETIME NOT NULL)`)
+	return err
+}
+
+// migrateExplicit records whether a package was installed explicitly or
+// pulled in as a dependency. Earlier builds did not track it, so installed
+// packages another installed package depends on are taken to be
+// dependencies.
+func migrateExplicit(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "installed", "explicit", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+	UPDATE installed SET explicit = 0
+	WHERE name IN (
+		SELECT d.dep_name
+		FROM package_deps d
+		JOIN installed i ON i.name = d.package
+	)`)
+	return err
+}
+
+// migrateInstalledDeps keeps the dependencies of installed packages apart
+// from the repository's, so that they survive the package leaving the
+// index. They are taken from the index for packages already installed.
+func migrateInstalledDeps(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS installed_deps (
+		package TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		spec TEXT NOT NULL,
+		dep_name TEXT NOT NULL,
+		PRIMARY KEY (package, position)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_installed_deps_name ON installed_deps(dep_name);
+
+	INSERT OR IGNORE INTO installed_deps (package, position, spec, dep_name)
+	SELECT d.package, d.position, d.spec, d.dep_name
+	FROM package_deps d
+	JOIN installed i ON i.name = d.package;
+	`)
+	return err
+}
+
+// migrateForeignPackages adds the table keeping the index entries of
+// packages that have no build the system runs, so that they can still be
+// installed with --force-arch. Clearing the synced architectures makes
+// the next sync fetch the full index to fill it in.
+func migrateForeignPackages(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS foreign_packages (
+		name TEXT NOT NULL,
+		arch TEXT NOT NULL,
+		info TEXT NOT NULL,
+		PRIMARY KEY (name, arch)
+	);
+
+	UPDATE repo_sync SET arches = '';
+	`)
+	return err
+}
+
+func addColumnIfMissing(tx *sql.Tx, table, column, decl string) error {
+	rows, err := tx.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
+	return err
+}
+
+// ErrMigrationsPending is returned when a database is opened without
+// migrating it and its schema is out of date.
+var ErrMigrationsPending = errors.New("database migrations are pending")
+
+// schemaVersion returns the highest applied migration. A database without
+// the schema_version table is version 0. It does not write, so it is safe
+// under a shared lock.
+func (d *Database) schemaVersion() (int, error) {
+	var tables int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'`).Scan(&tables)
+	if err != nil || tables == 0 {
+		return 0, err
+	}
+
+	var version int
+	err = d.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, err
+}
+
+// checkSchema fails if the database needs migrating, wrapping
+// ErrMigrationsPending, or was written by a newer mix.
+func (d *Database) checkSchema() error {
+	current, err := d.schemaVersion()
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > SchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d; upgrade mix", current, SchemaVersion())
+	}
+	if current < SchemaVersion() {
+		return fmt.Errorf("%w (schema version %d, latest %d)", ErrMigrationsPending, current, SchemaVersion())
+	}
+	return nil
+}
+
+// MigrationStatus reports the schema version of the database and the
+// migrations that Migrate would apply.
+func (d *Database) MigrationStatus() (*MigrationStatus, error) {
+	current, err := d.schemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MigrationStatus{Current: current, Latest: SchemaVersion()}
+	for _, m := range migrations {
+		if m.version > current {
+			status.Pending = append(status.Pending, fmt.Sprintf("%d: %s", m.version, m.description))
+		}
+	}
+	return status, nil
+}
+
+// Migrate applies all pending migrations and returns the descriptions of
+// those it applied. It refuses to touch a database written by a newer mix.
+func (d *Database) Migrate() ([]string, error) {
+	_, err := d.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := d.schemaVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if current > SchemaVersion() {
+		return nil, fmt.Errorf("database schema version %d is newer than supported version %d; upgrade mix", current, SchemaVersion())
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return applied, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		applied = append(applied, fmt.Sprintf("%d: %s", m.version, m.description))
+	}
+	return applied, nil
+}
+
+func (d *Database) applyMigration(m migration) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.description, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Check runs SQLite's integrity and foreign key checks and returns the
+// problems found.
+func (d *Database) Check() ([]string, error) {
+	var problems []string
+
+	rows, err := d.db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	rows.Close()
+
+	rows, err = d.db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return nil, err
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d references missing %s", table, rowid.Int64, parent))
+	}
+	return problems, rows.Err()
+}
@@ -0,0 +1,203 @@
+package manager
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacySchema is the unversioned schema created by mix before migrations
+// were introduced.
+const legacySchema = `
+CREATE TABLE IF NOT EXISTS packages (
+	name TEXT PRIMARY KEY,
+	version TEXT NOT NULL,
+	description TEXT,
+	dependencies TEXT,
+	files TEXT,
+	checksum TEXT,
+	size INTEGER DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS installed (
+	name TEXT PRIMARY KEY,
+	version TEXT NOT NULL,
+	install_time DATETIME DEFAULT CURRENT_TIMESTAMP,
+	files TEXT
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	path TEXT PRIMARY KEY,
+	package TEXT NOT NULL,
+	FOREIGN KEY (package) REFERENCES installed(name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_files_package ON files(package);
+CREATE INDEX IF NOT EXISTS idx_packages_name ON packages(name);
+
+INSERT INTO packages (name, version, description, dependencies, files, checksum, size)
+VALUES ('curl', '8.0.0', 'URL transfer tool', '["openssl>=3.0","zlib"]', '["/usr/bin/curl"]', 'abc', 1024);
+INSERT INTO installed (name, version, files) VALUES ('curl', '8.0.0', '["/usr/bin/curl"]');
+INSERT INTO files (path, package) VALUES ('/usr/bin/curl', 'curl');
+`
+
+func createLegacyDatabase(t *testing.T, path string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to create fixture database: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatalf("Failed to create fixture schema: %v", err)
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "packages.db")
+	createLegacyDatabase(t, dbPath)
+
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	status, err := db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	if status.Current != 0 || status.UpToDate() || len(status.Pending) != len(migrations) {
+		t.Errorf("Expected all migrations pending on legacy database, got %+v", status)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer db.Close()
+
+	status, err = db.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus failed: %v", err)
+	}
+	if !status.UpToDate() || status.Current != SchemaVersion() {
+		t.Errorf("Expected schema version %d after migration, got %+v", SchemaVersion(), status)
+	}
+
+	pkg, err := db.GetInstalledPackage("curl")
+	if err != nil {
+		t.Fatalf("GetInstalledPackage failed after migration: %v", err)
+	}
+	if pkg.Version != "8.0.0" || len(pkg.Dependencies) != 2 || pkg.Dependencies[0] != "openssl>=3.0" {
+		t.Errorf("Installed package not preserved: %+v", pkg)
+	}
+	if len(pkg.Files) != 1 || pkg.Files[0] != "/usr/bin/curl" {
+		t.Errorf("Installed files not preserved: %v", pkg.Files)
+	}
+
+	rdeps, err := db.GetReverseDependencies("openssl")
+	if err != nil {
+		t.Fatalf("GetReverseDependencies failed after migration: %v", err)
+	}
+	if len(rdeps) != 1 || rdeps[0] != "curl" {
+		t.Errorf("Expected curl to depend on openssl, got %v", rdeps)
+	}
+
+	// New columns are usable on the migrated database
+	if err := db.RecordInstallationFrom("curl", "8.1.0", "/tmp/curl-8.1.0.mixpkg", pkg.Dependencies, []string{"/usr/bin/curl"}); err != nil {
+		t.Fatalf("RecordInstallationFrom failed: %v", err)
+	}
+	pkg, _ = db.GetInstalledPackage("curl")
+	if pkg.Origin != "/tmp/curl-8.1.0.mixpkg" {
+		t.Errorf("Expected origin to be recorded, got %q", pkg.Origin)
+	}
+
+	problems, err := db.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+
+	applied, err := db.Migrate()
+	if err != nil {
+		t.Fatalf("Second Migrate failed: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no migrations on up-to-date database, got %v", applied)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "packages.db")
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	_, err = db.db.Exec(`INSERT INTO schema_version (version, description, applied_at) VALUES (?, 'future', '')`, SchemaVersion()+1)
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to bump schema version: %v", err)
+	}
+
+	if _, err := NewDatabase(dbPath); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected newer schema to be rejected, got %v", err)
+	}
+}
+
+func TestOpenWithoutMigrating(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "packages.db")
+	createLegacyDatabase(t, dbPath)
+
+	if _, err := Open(dbPath, "http://localhost:8080", filepath.Join(tmpDir, "cache")); !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("Expected ErrMigrationsPending, got %v", err)
+	}
+	db, err := OpenDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	status, err := db.MigrationStatus()
+	db.Close()
+	if err != nil || status.Current != 0 {
+		t.Fatalf("Expected the database to be left unversioned, got %+v, %v", status, err)
+	}
+
+	mgr, err := New(dbPath, "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	mgr.Close()
+
+	mgr, err = Open(dbPath, "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Open failed after migrating: %v", err)
+	}
+	defer mgr.Close()
+	if installed, err := mgr.IsInstalled("curl"); err != nil || !installed {
+		t.Errorf("Expected curl to be installed, got %v, %v", installed, err)
+	}
+}
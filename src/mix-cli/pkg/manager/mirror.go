@@ -0,0 +1,275 @@
+package manager
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// MirrorOptions controls which packages MirrorRepository copies and how
+// the result is verified.
+type MirrorOptions struct {
+	// Include limits the mirror to packages whose names match one of these
+	// glob patterns. Empty means all packages.
+	Include []string
+	// Exclude drops packages whose names match one of these glob patterns.
+	Exclude []string
+	// WithDeps adds the dependencies of included packages to the mirror.
+	WithDeps bool
+	// PublicKey, when set, is used to verify the source index signature.
+	PublicKey ed25519.PublicKey
+	// SignKey, when set, re-signs a filtered index and signs the v2 index.
+	SignKey ed25519.PrivateKey
+	// Prune deletes package files in the destination that are no longer
+	// part of the mirrored index.
+	Prune bool
+	// DryRun computes the plan without touching the destination.
+	DryRun bool
+	// Client is used for http(s) sources; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// MirrorPlan describes the work a mirror sync does (or would do).
+type MirrorPlan struct {
+	Packages   []PackageInfo
+	Fetch      []PackageInfo
+	FetchBytes int64
+	Prune      []string
+	PruneBytes int64
+}
+
+// MirrorRepository copies the repository at srcURL (any URL NewFetcher
+// accepts) into destDir so that it
+// can be used as a self-contained repository. The source may publish a v1
+// or a v2 index; the mirror gets both, with v2 generations of its own.
+// Packages already present with
+// a matching checksum are not downloaded again, every download is verified
+// against the index, and the index is only replaced once all packages are
+// in place, so cancelling ctx leaves the previous mirror usable.
+func MirrorRepository(ctx context.Context, srcURL, destDir string, opts MirrorOptions) (*MirrorPlan, error) {
+	src, err := NewFetcher(srcURL, opts.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	index, indexData, sig, err := fetchMirrorIndex(ctx, src, opts.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateIndex(index); err != nil {
+		return nil, fmt.Errorf("invalid package index: %w", err)
+	}
+
+	selected, err := selectMirrorPackages(index, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &MirrorPlan{Packages: selected}
+	wanted := make(map[string]bool, len(selected))
+	for _, pkg := range selected {
+		file := PackageFileName(pkg.Name, pkg.Version, pkg.Arch)
+		path := filepath.Join(destDir, file)
+		if filepath.Dir(path) != filepath.Clean(destDir) {
+			return nil, fmt.Errorf("package file %q is outside %s", file, destDir)
+		}
+		wanted[file] = true
+
+		if pkg.Checksum != "" {
+			if sum, err := fileChecksum(path); err == nil && sum == pkg.Checksum {
+				continue
+			}
+		} else if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		plan.Fetch = append(plan.Fetch, pkg)
+		plan.FetchBytes += pkg.Size
+	}
+
+	if opts.Prune {
+		existing, _ := filepath.Glob(filepath.Join(destDir, "*.mixpkg"))
+		for _, file := range existing {
+			if wanted[filepath.Base(file)] {
+				continue
+			}
+			plan.Prune = append(plan.Prune, filepath.Base(file))
+			if stat, err := os.Stat(file); err == nil {
+				plan.PruneBytes += stat.Size()
+			}
+		}
+	}
+
+	if opts.DryRun {
+		return plan, nil
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range plan.Fetch {
+		file := PackageFileName(pkg.Name, pkg.Version, pkg.Arch)
+		if err := fetchFile(ctx, src, file, filepath.Join(destDir, file), pkg.Checksum, nil); err != nil {
+			return nil, fmt.Errorf("failed to mirror %s: %w", file, err)
+		}
+	}
+
+	// Publish the index only after every package it references is present.
+	// A full mirror of a v1 source keeps the index and signature as is.
+	sigPath := filepath.Join(destDir, IndexSignatureFile)
+	if indexData != nil && len(selected) == len(index) {
+		if err := writeFileAtomic(filepath.Join(destDir, IndexFile), indexData, 0644); err != nil {
+			return nil, err
+		}
+		if sig != nil {
+			if err := writeFileAtomic(sigPath, sig, 0644); err != nil {
+				return nil, err
+			}
+		} else {
+			os.Remove(sigPath)
+		}
+	} else if err := WriteIndex(destDir, selected, opts.SignKey); err != nil {
+		return nil, err
+	}
+	// The v2 index keeps the mirror's own generations, so its clients get
+	// diffs between mirror syncs
+	if _, err := WriteIndexV2(destDir, selected, IndexOptions{Key: opts.SignKey}); err != nil {
+		return nil, err
+	}
+
+	for _, file := range plan.Prune {
+		os.Remove(filepath.Join(destDir, file))
+	}
+
+	return plan, nil
+}
+
+// fetchMirrorIndex downloads the index of src: the v2 index if there is
+// one, else index.json, verifying its signature when key is set. For a v1
+// index it also returns the raw index and signature (nil if there is
+// none), so a full mirror can publish them unchanged.
+func fetchMirrorIndex(ctx context.Context, src Fetcher, key ed25519.PublicKey) ([]PackageInfo, []byte, []byte, error) {
+	data, err := fetchBytes(ctx, src, IndexV2File)
+	if err == nil {
+		if key != nil {
+			sig, err := fetchBytes(ctx, src, IndexV2SignatureFile)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to fetch index signature: %w", err)
+			}
+			if err := VerifyIndex(data, sig, key); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		v2, err := DecodeIndexV2(data)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to parse package index: %w", err)
+		}
+		return v2.Packages, nil, nil, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, nil, nil, fmt.Errorf("failed to fetch index: %w", err)
+	}
+
+	data, err = fetchBytes(ctx, src, IndexFile)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to fetch index: %w", err)
+	}
+	sig, sigErr := fetchBytes(ctx, src, IndexSignatureFile)
+	if sigErr != nil {
+		if key != nil {
+			return nil, nil, nil, fmt.Errorf("failed to fetch index signature: %w", sigErr)
+		}
+		sig = nil
+	} else if key != nil {
+		if err := VerifyIndex(data, sig, key); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var index []PackageInfo
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse package index: %w", err)
+	}
+	return index, data, sig, nil
+}
+
+// selectMirrorPackages applies the include/exclude filters to index and,
+// if requested, pulls in the dependencies of the selected packages. A
+// dependency pulls in the package for every architecture in the index.
+func selectMirrorPackages(index []PackageInfo, opts MirrorOptions) ([]PackageInfo, error) {
+	byKey := make(map[string]PackageInfo, len(index))
+	byName := make(map[string][]string)
+	for _, pkg := range index {
+		key := indexKey(pkg.Name, pkg.Arch)
+		byKey[key] = pkg
+		byName[pkg.Name] = append(byName[pkg.Name], key)
+	}
+
+	matches := func(patterns []string, name string) (bool, error) {
+		for _, pattern := range patterns {
+			ok, err := filepath.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	selected := make(map[string]bool)
+	var queue []string
+	for _, pkg := range index {
+		if len(opts.Include) > 0 {
+			ok, err := matches(opts.Include, pkg.Name)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+		excluded, err := matches(opts.Exclude, pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if excluded {
+			continue
+		}
+		key := indexKey(pkg.Name, pkg.Arch)
+		selected[key] = true
+		queue = append(queue, key)
+	}
+
+	for opts.WithDeps && len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+		for _, dep := range byKey[key].Dependencies {
+			for _, depKey := range byName[parseDependency(dep)] {
+				if !selected[depKey] {
+					selected[depKey] = true
+					queue = append(queue, depKey)
+				}
+			}
+		}
+	}
+
+	var result []PackageInfo
+	for key := range selected {
+		result = append(result, byKey[key])
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Arch < result[j].Arch
+	})
+	return result, nil
+}
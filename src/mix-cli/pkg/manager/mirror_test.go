@@ -0,0 +1,242 @@
+package manager
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMirrorRepository(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "mirror")
+
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "base", Version: "1.0.0"},
+		map[string]string{"/etc/os-release": "mixos"})
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "app", Version: "1.0.0", Dependencies: []string{"base"}},
+		map[string]string{"/usr/bin/app": "app"})
+	oldTool := writeTestPackage(t, srcDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	index, _ := BuildIndex(srcDir)
+	WriteIndex(srcDir, index, nil)
+
+	srv := httptest.NewServer(NewRepoHandler(srcDir))
+	defer srv.Close()
+
+	// Filtered sync pulls in dependencies
+	plan, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Include: []string{"app"}, WithDeps: true, Prune: true})
+	if err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	if len(plan.Packages) != 2 || len(plan.Fetch) != 2 {
+		t.Fatalf("Expected app and base to be fetched, got %+v", plan)
+	}
+	mirrored, err := BuildIndex(destDir)
+	if err != nil || len(mirrored) != 2 {
+		t.Fatalf("Expected usable 2-package mirror, got %v (%v)", mirrored, err)
+	}
+
+	// Full sync only fetches what is missing
+	plan, err = MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	if len(plan.Fetch) != 1 || plan.Fetch[0].Name != "tool" {
+		t.Fatalf("Expected only tool to be fetched, got %+v", plan.Fetch)
+	}
+
+	// A new upstream version replaces the old file
+	os.Remove(oldTool)
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "tool", Version: "2.0.0"},
+		map[string]string{"/usr/bin/tool": "v2"})
+	index, _ = BuildIndex(srcDir)
+	WriteIndex(srcDir, index, nil)
+
+	plan, err = MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Prune: true, DryRun: true})
+	if err != nil {
+		t.Fatalf("MirrorRepository dry run failed: %v", err)
+	}
+	if len(plan.Fetch) != 1 || len(plan.Prune) != 1 || plan.FetchBytes == 0 {
+		t.Fatalf("Unexpected dry-run plan: %+v", plan)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "tool-1.0.0.mixpkg")); err != nil {
+		t.Fatal("Dry run must not prune files")
+	}
+
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Prune: true}); err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "tool-1.0.0.mixpkg")); !os.IsNotExist(err) {
+		t.Error("Expected old version to be pruned")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, "tool-2.0.0.mixpkg")); err != nil {
+		t.Errorf("Expected new version to be mirrored: %v", err)
+	}
+}
+
+func TestMirrorRepositoryVerifies(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "mirror")
+
+	pkgPath := writeTestPackage(t, srcDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	keyPrefix := filepath.Join(tmpDir, "repo")
+	GenerateSigningKey(keyPrefix)
+	priv, _ := LoadSigningKey(keyPrefix + ".key")
+	index, _ := BuildIndex(srcDir)
+	WriteIndex(srcDir, index, priv)
+
+	srv := httptest.NewServer(NewRepoHandler(srcDir))
+	defer srv.Close()
+
+	otherPrefix := filepath.Join(tmpDir, "other")
+	GenerateSigningKey(otherPrefix)
+	other, _ := LoadPublicKey(otherPrefix + ".pub")
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{PublicKey: other}); err == nil {
+		t.Error("Expected signature verification to fail with the wrong key")
+	}
+
+	os.WriteFile(pkgPath, []byte("corrupted"), 0644)
+	pub, _ := LoadPublicKey(keyPrefix + ".pub")
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{PublicKey: pub}); err == nil {
+		t.Error("Expected checksum verification to fail")
+	}
+	if _, err := os.Stat(filepath.Join(destDir, IndexFile)); !os.IsNotExist(err) {
+		t.Error("Index must not be published when a package fails to verify")
+	}
+}
+
+func TestMirrorRepositoryMultiArch(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "mirror")
+
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		writeTestPackage(t, srcDir, &PackageMetadata{Name: "libc", Version: "1.0.0", Arch: arch},
+			map[string]string{"/usr/lib/libc.so": arch})
+	}
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "app", Version: "1.0.0", Arch: "x86_64", Dependencies: []string{"libc"}},
+		map[string]string{"/usr/bin/app": "app"})
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "tool", Version: "1.0.0", Arch: "aarch64"},
+		map[string]string{"/usr/bin/tool": "tool"})
+	index, _ := BuildIndex(srcDir)
+	WriteIndex(srcDir, index, nil)
+
+	srv := httptest.NewServer(NewRepoHandler(srcDir))
+	defer srv.Close()
+
+	// A dependency pulls in every architecture of the package
+	plan, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Include: []string{"app"}, WithDeps: true})
+	if err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	if len(plan.Packages) != 3 || len(plan.Fetch) != 3 {
+		t.Fatalf("Expected app and both libc builds to be fetched, got %+v", plan)
+	}
+	for _, file := range []string{"libc-1.0.0-x86_64.mixpkg", "libc-1.0.0-aarch64.mixpkg"} {
+		if _, err := os.Stat(filepath.Join(destDir, file)); err != nil {
+			t.Errorf("Expected %s to be mirrored: %v", file, err)
+		}
+	}
+
+	// A full sync keeps both architectures and publishes the upstream index
+	plan, err = MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	if len(plan.Packages) != 4 || len(plan.Fetch) != 1 || len(plan.Prune) != 0 {
+		t.Fatalf("Expected only tool to be fetched, got %+v", plan)
+	}
+	mirrored, err := BuildIndex(destDir)
+	if err != nil || len(mirrored) != 4 {
+		t.Fatalf("Expected usable 4-package mirror, got %v (%v)", mirrored, err)
+	}
+}
+
+func TestMirrorRepositoryV2Source(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "mirror")
+
+	writeTestPackage(t, srcDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	keyPrefix := filepath.Join(tmpDir, "repo")
+	GenerateSigningKey(keyPrefix)
+	priv, _ := LoadSigningKey(keyPrefix + ".key")
+	pub, _ := LoadPublicKey(keyPrefix + ".pub")
+	index, _ := BuildIndex(srcDir)
+	if _, err := WriteIndexV2(srcDir, index, IndexOptions{Key: priv}); err != nil {
+		t.Fatalf("WriteIndexV2 failed: %v", err)
+	}
+
+	srv := httptest.NewServer(NewRepoHandler(srcDir))
+	defer srv.Close()
+
+	// The source has no index.json, only the signed v2 index
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{PublicKey: pub}); err != nil {
+		t.Fatalf("MirrorRepository failed: %v", err)
+	}
+	for _, file := range []string{IndexFile, IndexV2File, "tool-1.0.0.mixpkg"} {
+		if _, err := os.Stat(filepath.Join(destDir, file)); err != nil {
+			t.Errorf("Expected %s in the mirror: %v", file, err)
+		}
+	}
+
+	other := filepath.Join(tmpDir, "other")
+	GenerateSigningKey(other)
+	otherPub, _ := LoadPublicKey(other + ".pub")
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{PublicKey: otherPub}); err == nil {
+		t.Error("Expected the v2 index signature to be verified")
+	}
+}
+
+func TestMirrorRepositoryRejectsUnsafeIndex(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	srcDir := filepath.Join(tmpDir, "src")
+	destDir := filepath.Join(tmpDir, "mirror")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	index := `[{"name":"evil","version":"../../escaped"}]`
+	if err := os.WriteFile(filepath.Join(srcDir, IndexFile), []byte(index), 0644); err != nil {
+		t.Fatalf("Failed to write index: %v", err)
+	}
+
+	srv := httptest.NewServer(NewRepoHandler(srcDir))
+	defer srv.Close()
+
+	if _, err := MirrorRepository(context.Background(), srv.URL, destDir, MirrorOptions{}); err == nil {
+		t.Fatal("Expected an index with a path in a version to be rejected")
+	}
+	if _, err := os.Stat(destDir); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be written for a rejected index")
+	}
+}
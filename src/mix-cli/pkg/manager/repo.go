@@ -0,0 +1,349 @@
+package manager
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// IndexFile is the name of the package index at the root of a repository.
+	IndexFile = "index.json"
+	// IndexSignatureFile holds the detached ed25519 signature of IndexFile.
+	IndexSignatureFile = "index.json.sig"
+)
+
+// PackageFileName returns the file name a package is stored under in a
+// repository and in the cache: <name>-<version>-<arch>.mixpkg, or
+// <name>-<version>.mixpkg for packages that do not declare an arch.
+func PackageFileName(name, version, arch string) string {
+	if arch == "" {
+		return fmt.Sprintf("%s-%s.mixpkg", name, version)
+	}
+	return fmt.Sprintf("%s-%s-%s.mixpkg", name, version, arch)
+}
+
+// BuildIndex scans dir for .mixpkg files and returns an index entry for
+// the newest version of each package and architecture, sorted by name and
+// architecture. Checksums, hashes
+// and sizes are computed from the files themselves.
+func BuildIndex(dir string) ([]PackageInfo, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.mixpkg"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{}
+	newest := make(map[string]*PackageInfo)
+	for _, file := range files {
+		metadata, err := m.readPackageMetadata(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+		if metadata.Name == "" || metadata.Version == "" {
+			return nil, fmt.Errorf("%s: metadata.json lacks a name or version", filepath.Base(file))
+		}
+		if want := PackageFileName(metadata.Name, metadata.Version, metadata.Arch); filepath.Base(file) != want {
+			return nil, fmt.Errorf("%s: file name does not match metadata (expected %s)", filepath.Base(file), want)
+		}
+
+		key := indexKey(metadata.Name, metadata.Arch)
+		if existing, ok := newest[key]; ok && compareVersions(existing.Version, metadata.Version) >= 0 {
+			continue
+		}
+
+		hashes, err := fileHashes(file)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		installedSize, err := packageInstalledSize(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+
+		deps := metadata.Dependencies
+		if deps == nil {
+			deps = []string{}
+		}
+		newest[key] = &PackageInfo{
+			Name:          metadata.Name,
+			Version:       metadata.Version,
+			Description:   metadata.Description,
+			Dependencies:  deps,
+			Files:         metadata.Files,
+			Checksum:      hashes["sha256"],
+			Size:          stat.Size(),
+			Arch:          metadata.Arch,
+			License:       metadata.License,
+			Homepage:      metadata.Homepage,
+			Maintainer:    metadata.Maintainer,
+			InstalledSize: installedSize,
+			BuildDate:     metadata.BuildDate,
+			Hashes:        hashes,
+		}
+	}
+
+	index := make([]PackageInfo, 0, len(newest))
+	for _, pkg := range newest {
+		index = append(index, *pkg)
+	}
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].Name != index[j].Name {
+			return index[i].Name < index[j].Name
+		}
+		return index[i].Arch < index[j].Arch
+	})
+
+	return index, nil
+}
+
+// indexKey identifies an index entry: a package may be listed once per
+// architecture.
+func indexKey(name, arch string) string {
+	if arch == "" {
+		return name
+	}
+	return name + ":" + arch
+}
+
+// packageFile is a regular file in a package payload.
+type packageFile struct {
+	path string
+	size int64
+}
+
+// packageFileSizes lists the regular files of the package payload in
+// pkgPath with their sizes.
+func packageFileSizes(pkgPath string) ([]packageFile, error) {
+	f, err := os.Open(pkgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	var files []packageFile
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path := packagePath(header.Name); header.Typeflag == tar.TypeReg && path != "" {
+			files = append(files, packageFile{path: path, size: header.Size})
+		}
+	}
+}
+
+// packageInstalledSize returns the total size of the regular files a
+// package installs.
+func packageInstalledSize(pkgPath string) (int64, error) {
+	files, err := packageFileSizes(pkgPath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		size += f.size
+	}
+	return size, nil
+}
+
+// WriteIndex writes index to dir/index.json. When key is non-nil the index
+// is also signed and the signature written to dir/index.json.sig.
+func WriteIndex(dir string, index []PackageInfo, key ed25519.PrivateKey) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if err := writeFileAtomic(filepath.Join(dir, IndexFile), data, 0644); err != nil {
+		return err
+	}
+
+	sigPath := filepath.Join(dir, IndexSignatureFile)
+	if key == nil {
+		os.Remove(sigPath)
+		return nil
+	}
+	return writeFileAtomic(sigPath, ed25519.Sign(key, data), 0644)
+}
+
+// VerifyIndex checks a detached index signature against a public key.
+func VerifyIndex(data, sig []byte, key ed25519.PublicKey) error {
+	if !ed25519.Verify(key, data, sig) {
+		return fmt.Errorf("index signature verification failed")
+	}
+	return nil
+}
+
+// GenerateSigningKey creates an ed25519 key pair and writes it as PEM to
+// prefix.key (private, mode 0600) and prefix.pub (public).
+func GenerateSigningKey(prefix string) error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(prefix+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(prefix+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644)
+}
+
+// LoadSigningKey reads a PEM encoded ed25519 private key.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads a PEM encoded ed25519 public key.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+	return pub, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// repoContentTypes maps repository file extensions to their MIME types.
+var repoContentTypes = map[string]string{
+	".json":   "application/json",
+	".sig":    "application/octet-stream",
+	".mixpkg": "application/gzip",
+	".gz":     "application/gzip",
+	".pub":    "application/x-pem-file",
+}
+
+// NewRepoHandler returns an http.Handler serving the repository in dir.
+// Responses carry an ETag derived from size and modification time, and
+// conditional and Range requests are handled by http.ServeContent.
+// Directory listings and dotfiles are not served.
+func NewRepoHandler(dir string) http.Handler {
+	root := http.Dir(dir)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := path.Clean("/" + r.URL.Path)
+		for _, part := range strings.Split(name, "/") {
+			if strings.HasPrefix(part, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		f, err := root.Open(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+
+		stat, err := f.Stat()
+		if err != nil || stat.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		ctype, ok := repoContentTypes[path.Ext(name)]
+		if !ok {
+			ctype = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()))
+		w.Header().Set("Cache-Control", "no-cache")
+
+		http.ServeContent(w, r, name, stat.ModTime(), f)
+	})
+}
@@ -0,0 +1,159 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildIndex(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestPackage(t, tmpDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	newest := writeTestPackage(t, tmpDir, &PackageMetadata{Name: "tool", Version: "1.2.0"},
+		map[string]string{"/usr/bin/tool": "v1.2"})
+	writeTestPackage(t, tmpDir, &PackageMetadata{Name: "base", Version: "1.0.0"},
+		map[string]string{"/etc/os-release": "mixos"})
+
+	index, err := BuildIndex(tmpDir)
+	if err != nil {
+		t.Fatalf("BuildIndex failed: %v", err)
+	}
+	if len(index) != 2 || index[0].Name != "base" || index[1].Name != "tool" {
+		t.Fatalf("Unexpected index: %+v", index)
+	}
+
+	tool := index[1]
+	if tool.Version != "1.2.0" {
+		t.Errorf("Expected newest version 1.2.0, got %s", tool.Version)
+	}
+	stat, _ := os.Stat(newest)
+	if tool.Size != stat.Size() {
+		t.Errorf("Expected size %d, got %d", stat.Size(), tool.Size)
+	}
+	sum, _ := fileChecksum(newest)
+	if tool.Checksum != sum {
+		t.Errorf("Expected checksum %s, got %s", sum, tool.Checksum)
+	}
+
+	keyPrefix := filepath.Join(tmpDir, "repo")
+	if err := GenerateSigningKey(keyPrefix); err != nil {
+		t.Fatalf("GenerateSigningKey failed: %v", err)
+	}
+	priv, err := LoadSigningKey(keyPrefix + ".key")
+	if err != nil {
+		t.Fatalf("LoadSigningKey failed: %v", err)
+	}
+	pub, err := LoadPublicKey(keyPrefix + ".pub")
+	if err != nil {
+		t.Fatalf("LoadPublicKey failed: %v", err)
+	}
+
+	if err := WriteIndex(tmpDir, index, priv); err != nil {
+		t.Fatalf("WriteIndex failed: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(tmpDir, IndexFile))
+	sig, _ := os.ReadFile(filepath.Join(tmpDir, IndexSignatureFile))
+	if err := VerifyIndex(data, sig, pub); err != nil {
+		t.Errorf("VerifyIndex failed: %v", err)
+	}
+	var decoded []PackageInfo
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded) != 2 {
+		t.Errorf("Expected readable index, got %v (%v)", decoded, err)
+	}
+
+	data[0] = ' '
+	if err := VerifyIndex(data, sig, pub); err == nil {
+		t.Error("Expected tampered index to fail verification")
+	}
+}
+
+func TestBuildIndexRejectsMisnamedFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := writeTestPackage(t, tmpDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	os.Rename(path, filepath.Join(tmpDir, "tool-latest.mixpkg"))
+
+	if _, err := BuildIndex(tmpDir); err == nil {
+		t.Error("Expected error for misnamed package file")
+	}
+}
+
+func TestRepoHandler(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	writeTestPackage(t, tmpDir, &PackageMetadata{Name: "tool", Version: "1.0.0"},
+		map[string]string{"/usr/bin/tool": "v1"})
+	index, _ := BuildIndex(tmpDir)
+	WriteIndex(tmpDir, index, nil)
+	os.WriteFile(filepath.Join(tmpDir, ".secret"), []byte("x"), 0644)
+
+	srv := httptest.NewServer(NewRepoHandler(tmpDir))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/" + IndexFile)
+	if err != nil {
+		t.Fatalf("GET index failed: %v", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected application/json, got %s", ct)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected ETag header")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/"+IndexFile, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Conditional GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/tool-1.0.0.mixpkg", nil)
+	req.Header.Set("Range", "bytes=0-9")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Range GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || resp.ContentLength != 10 {
+		t.Errorf("Expected 206 with 10 bytes, got %d with %d", resp.StatusCode, resp.ContentLength)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Expected application/gzip, got %s", ct)
+	}
+
+	for _, p := range []string{"/.secret", "/", "/missing.mixpkg"} {
+		resp, err = http.Get(srv.URL + p)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", p, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s: expected 404, got %d", p, resp.StatusCode)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
@@ -11,6 +12,8 @@ type Resolver struct {
 	resolved  map[string]bool
 	unresolved map[string]bool
 	order     []string
+	// dependencies of local package files, which shadow database entries
+	local map[string][]string
 }
 
 func NewResolver(db *Database) *Resolver {
@@ -18,9 +21,51 @@ func NewResolver(db *Database) *Resolver {
 		db:         db,
 		resolved:   make(map[string]bool),
 		unresolved: make(map[string]bool),
+		local:      make(map[string][]string),
 	}
 }
 
+// AddLocal makes a package that is not (or not only) in the database
+// available to the resolver, e.g. a .mixpkg file given on the command line.
+func (r *Resolver) AddLocal(name string, deps []string) {
+	r.local[name] = deps
+}
+
+// dependencies returns the declared dependencies of pkg, preferring local
+// package files over the database, and repository entries over those for
+// architectures the system does not run.
+func (r *Resolver) dependencies(pkg string) ([]string, error) {
+	if deps, ok := r.local[pkg]; ok {
+		return deps, nil
+	}
+	deps, err := r.db.GetDependencies(pkg)
+	var unknown *UnknownPackageError
+	if errors.As(err, &unknown) {
+		if foreign, ferr := r.db.GetForeignPackage(pkg); ferr == nil {
+			return foreign.Dependencies, nil
+		}
+	}
+	return deps, err
+}
+
+// Dependencies returns the names of the packages pkg depends on, in
+// declaration order and without duplicates.
+func (r *Resolver) Dependencies(pkg string) ([]string, error) {
+	specs, err := r.dependencies(pkg)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		if name := parseDependency(spec); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
 // Resolve returns packages in installation order (dependencies first)
 func (r *Resolver) Resolve(packages []string) ([]string, error) {
 	r.resolved = make(map[string]bool)
@@ -70,15 +115,11 @@ func (r *Resolver) resolve(pkg string) error {
 
 	r.unresolved[pkg] = true
 
-	// Get dependencies
-	deps, err := r.db.GetDependencies(pkg)
+	// Get dependencies; a package that is not in the database is an
+	// UnknownPackageError
+	deps, err := r.dependencies(pkg)
 	if err != nil {
-		// Package not in database, might be a virtual package or error
-		// For now, just add it without dependencies
-		r.resolved[pkg] = true
-		delete(r.unresolved, pkg)
-		r.order = append(r.order, pkg)
-		return nil
+		return err
 	}
 
 	// Resolve each dependency
@@ -93,6 +134,10 @@ func (r *Resolver) resolve(pkg string) error {
 		}
 
 		if err := r.resolve(depName); err != nil {
+			var unknown *UnknownPackageError
+			if errors.As(err, &unknown) && unknown.RequiredBy == "" {
+				unknown.RequiredBy = pkg
+			}
 			return err
 		}
 	}
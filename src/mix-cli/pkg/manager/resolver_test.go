@@ -1,6 +1,7 @@
 package manager
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -206,3 +207,33 @@ func TestResolverDeepDependencies(t *testing.T) {
 		}
 	}
 }
+
+func TestResolverUnknownPackage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	db.AddPackage(&PackageInfo{
+		Name:         "app",
+		Version:      "1.0.0",
+		Dependencies: []string{"libmissing>=1.0"},
+	})
+
+	resolver := NewResolver(db)
+	var unknown *UnknownPackageError
+	if _, err := resolver.Resolve([]string{"nosuch"}); !errors.As(err, &unknown) || unknown.Name != "nosuch" {
+		t.Errorf("Expected an UnknownPackageError for nosuch, got %v", err)
+	}
+	if _, err := resolver.Resolve([]string{"app"}); !errors.As(err, &unknown) ||
+		unknown.Name != "libmissing" || unknown.RequiredBy != "app" {
+		t.Errorf("Expected libmissing required by app to be unknown, got %v", err)
+	}
+}
@@ -0,0 +1,480 @@
+package manager
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search queries are whitespace-separated terms and field filters. Every
+// term must appear in the name or description of a package; a filter
+// restricts the results further:
+//
+//	name:ssh        the name contains ssh
+//	desc:client     the description mentions client
+//	dep:openssl     the package depends on openssl
+//	file:/usr/bin/ssh
+//	                the package ships that path; a relative path matches
+//	                by file name or path suffix
+//	installed:yes   the package is installed (installed:no, it is not)
+//
+// name: and file: values may use * as a wildcard. Double quotes keep a
+// value with spaces together.
+
+// SearchQuery is a parsed search query.
+type SearchQuery struct {
+	Terms     []string
+	Names     []string
+	Descs     []string
+	Deps      []string
+	Files     []string
+	Installed *bool
+}
+
+// ParseSearchQuery splits query into terms and field filters.
+func ParseSearchQuery(query string) (*SearchQuery, error) {
+	q := &SearchQuery{}
+	for _, token := range splitSearchQuery(query) {
+		field, value, ok := strings.Cut(token, ":")
+		if !ok {
+			q.Terms = append(q.Terms, strings.ToLower(token))
+			continue
+		}
+
+		var list *[]string
+		switch strings.ToLower(field) {
+		case "name":
+			list = &q.Names
+		case "desc", "description":
+			list = &q.Descs
+		case "dep", "depends":
+			list = &q.Deps
+		case "file":
+			list = &q.Files
+		case "installed":
+			installed, err := parseSearchBool(value)
+			if err != nil {
+				return nil, err
+			}
+			q.Installed = &installed
+			continue
+		default:
+			// Not a filter, e.g. a term like c++:
+			q.Terms = append(q.Terms, strings.ToLower(token))
+			continue
+		}
+		if value == "" {
+			return nil, fmt.Errorf("empty %s: filter", field)
+		}
+		if list != &q.Files {
+			value = strings.ToLower(value)
+		}
+		*list = append(*list, value)
+	}
+	return q, nil
+}
+
+func parseSearchBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		return true, nil
+	case "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid installed: filter %q, want yes or no", value)
+}
+
+// splitSearchQuery splits query at whitespace outside double quotes and
+// removes the quotes.
+func splitSearchQuery(query string) []string {
+	var tokens []string
+	var b strings.Builder
+	quoted, inToken := false, false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted, inToken = !quoted, true
+		case unicode.IsSpace(r) && !quoted:
+			if inToken {
+				tokens = append(tokens, b.String())
+				b.Reset()
+			}
+			inToken = false
+		default:
+			b.WriteRune(r)
+			inToken = true
+		}
+	}
+	if inToken {
+		tokens = append(tokens, b.String())
+	}
+	return tokens
+}
+
+// likePattern turns a filter value into a LIKE pattern: * matches
+// anything and the LIKE wildcards match themselves. Patterns use \ as the
+// escape character.
+func likePattern(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+	return strings.ReplaceAll(value, "*", "%")
+}
+
+// searchCandidates lists every package search can find: the repository
+// packages and the installed packages the repository no longer has.
+const searchCandidates = `
+	candidates AS (
+		SELECT p.name AS name, p.version AS version,
+			COALESCE(p.description, '') AS description, i.version AS installed_version
+		FROM packages p
+		LEFT JOIN installed i ON i.name = p.name
+		UNION ALL
+		SELECT i.name, i.version, '', i.version
+		FROM installed i
+		WHERE i.name NOT IN (SELECT name FROM packages)
+	)`
+
+// filterSQL returns the conditions and arguments for the filters of q on
+// the candidates table c. Descriptions are filtered here only without
+// the full-text index.
+func (q *SearchQuery) filterSQL(fts bool) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	for _, name := range q.Names {
+		pattern := likePattern(name)
+		if !strings.Contains(name, "*") {
+			pattern = "%" + pattern + "%"
+		}
+		conds = append(conds, `LOWER(c.name) LIKE ? ESCAPE '\'`)
+		args = append(args, pattern)
+	}
+	if !fts {
+		for _, desc := range q.Descs {
+			conds = append(conds, `LOWER(c.description) LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likePattern(desc)+"%")
+		}
+	}
+	for _, dep := range q.Deps {
+		conds = append(conds, `EXISTS (SELECT 1 FROM package_deps d WHERE d.package = c.name AND LOWER(d.dep_name) = ?)`)
+		args = append(args, dep)
+	}
+	for _, file := range q.Files {
+		cond, fileArgs := fileCondition(file)
+		conds = append(conds, `(EXISTS (SELECT 1 FROM package_files f WHERE f.package = c.name AND `+cond+`)
+			OR EXISTS (SELECT 1 FROM files f WHERE f.package = c.name AND `+cond+`))`)
+		args = append(args, fileArgs...)
+		args = append(args, fileArgs...)
+	}
+	if q.Installed != nil {
+		if *q.Installed {
+			conds = append(conds, `c.installed_version IS NOT NULL`)
+		} else {
+			conds = append(conds, `c.installed_version IS NULL`)
+		}
+	}
+	return conds, args
+}
+
+// fileCondition returns the condition on f.path for a file: filter.
+func fileCondition(file string) (string, []interface{}) {
+	switch {
+	case strings.Contains(file, "*"):
+		pattern := likePattern(file)
+		if !strings.HasPrefix(file, "/") {
+			pattern = "%" + pattern
+		}
+		return `f.path LIKE ? ESCAPE '\'`, []interface{}{pattern}
+	case strings.HasPrefix(file, "/"):
+		return `f.path = ?`, []interface{}{file}
+	}
+	return `f.path LIKE ? ESCAPE '\'`, []interface{}{"%/" + likePattern(file)}
+}
+
+// matchExpression returns the FTS5 query for the terms and desc: filters
+// of q: every word must match, as a prefix, in any column or in the
+// description respectively. Terms without letters or digits cannot be
+// matched by the index and are returned as leftovers.
+func (q *SearchQuery) matchExpression() (string, []string) {
+	var parts, leftover []string
+	quote := func(s string) string {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"*`
+	}
+	for _, term := range q.Terms {
+		if hasWordChar(term) {
+			parts = append(parts, quote(term))
+		} else {
+			leftover = append(leftover, term)
+		}
+	}
+	for _, desc := range q.Descs {
+		if hasWordChar(desc) {
+			parts = append(parts, "description : "+quote(desc))
+		}
+	}
+	return strings.Join(parts, " "), leftover
+}
+
+func hasWordChar(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) >= 0
+}
+
+// termConditions returns the conditions that every term appears in the
+// name or description of c.
+func termConditions(terms []string) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + likePattern(term) + "%"
+		conds = append(conds, `(LOWER(c.name) LIKE ? ESCAPE '\' OR LOWER(c.description) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	return conds, args
+}
+
+// Search finds the packages matching query, see SearchQuery. Packages
+// named exactly like a term come first. With the full-text index, whole
+// word matches follow ranked by relevance, then matches inside words;
+// without it, names starting with a term rank above names containing one
+// and description matches. With installedOnly, only installed packages
+// are searched and their installed versions are reported.
+func (d *Database) Search(query string, installedOnly bool) ([]SearchResult, error) {
+	q, err := ParseSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if installedOnly {
+		installed := true
+		q.Installed = &installed
+	}
+
+	results, err := d.searchLike(q)
+	if err != nil {
+		return nil, err
+	}
+	if d.refreshSearchIndex() == nil {
+		ranked, err := d.searchFTS(q)
+		if err != nil {
+			return nil, err
+		}
+		results = mergeSearchResults(ranked, results)
+	}
+
+	if len(q.Files) > 0 {
+		for i := range results {
+			if results[i].Files, err = d.matchingFiles(results[i].Name, q.Files); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return results, nil
+}
+
+// mergeSearchResults returns ranked followed by the results of rest that
+// ranked does not have.
+func mergeSearchResults(ranked, rest []SearchResult) []SearchResult {
+	if len(ranked) == 0 {
+		return rest
+	}
+	seen := make(map[string]bool, len(ranked))
+	for _, r := range ranked {
+		seen[r.Name] = true
+	}
+	for _, r := range rest {
+		if !seen[r.Name] {
+			ranked = append(ranked, r)
+		}
+	}
+	return ranked
+}
+
+// searchColumns selects a SearchResult from the candidates table c.
+func (q *SearchQuery) searchColumns() string {
+	version := "c.version"
+	if q.Installed != nil && *q.Installed {
+		version = "COALESCE(c.installed_version, c.version)"
+	}
+	return `c.name, ` + version + `, c.description, c.installed_version IS NOT NULL`
+}
+
+// exactNameOrder returns the ORDER BY terms putting the candidates c
+// named exactly like a term of q first.
+func (q *SearchQuery) exactNameOrder() (string, []interface{}) {
+	if len(q.Terms) == 0 {
+		return "", nil
+	}
+	args := make([]interface{}, len(q.Terms))
+	for i, term := range q.Terms {
+		args[i] = term
+	}
+	return `LOWER(c.name) IN (?` + strings.Repeat(", ?", len(q.Terms)-1) + `) DESC, `, args
+}
+
+// searchFTS searches the full-text index, ranked by BM25 with name
+// matches weighing more than description matches. It returns nothing for
+// queries without words to look up.
+func (d *Database) searchFTS(q *SearchQuery) ([]SearchResult, error) {
+	match, leftover := q.matchExpression()
+	if match == "" {
+		return nil, nil
+	}
+	conds, args := q.filterSQL(true)
+	termConds, termArgs := termConditions(leftover)
+
+	exact, exactArgs := q.exactNameOrder()
+	query := `WITH` + searchCandidates + `
+		SELECT ` + q.searchColumns() + `
+		FROM package_search s
+		JOIN candidates c ON c.name = s.name
+		WHERE package_search MATCH ?`
+	for _, cond := range append(termConds, conds...) {
+		query += " AND " + cond
+	}
+	query += ` ORDER BY ` + exact + `bm25(package_search, 10.0, 1.0), c.name`
+
+	all := append([]interface{}{match}, termArgs...)
+	all = append(all, args...)
+	return d.scanSearchResults(query, append(all, exactArgs...)...)
+}
+
+// searchLike searches by pattern matching, ranked by how well the names
+// match the terms: exact names first, then names starting with a term,
+// names containing one and description matches.
+func (d *Database) searchLike(q *SearchQuery) ([]SearchResult, error) {
+	conds, args := q.filterSQL(false)
+	termConds, termArgs := termConditions(q.Terms)
+	conds = append(termConds, conds...)
+
+	query := `WITH` + searchCandidates + `
+		SELECT ` + q.searchColumns() + `
+		FROM candidates c`
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += ` ORDER BY c.name`
+
+	results, err := d.scanSearchResults(query, append(termArgs, args...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	rank := func(name string) int {
+		name = strings.ToLower(name)
+		best := 3
+		for _, term := range q.Terms {
+			switch {
+			case name == term:
+				return 0
+			case strings.HasPrefix(name, term):
+				best = min(best, 1)
+			case strings.Contains(name, term):
+				best = min(best, 2)
+			}
+		}
+		return best
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return rank(results[i].Name) < rank(results[j].Name)
+	})
+	return results, nil
+}
+
+func (d *Database) scanSearchResults(query string, args ...interface{}) ([]SearchResult, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var r SearchResult
+		if err := rows.Scan(&r.Name, &r.Version, &r.Description, &r.Installed); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// matchingFiles returns the paths of pkg matching any of the file:
+// filters.
+func (d *Database) matchingFiles(pkg string, files []string) ([]string, error) {
+	var conds []string
+	var args []interface{}
+	for _, file := range files {
+		cond, fileArgs := fileCondition(file)
+		conds = append(conds, cond)
+		args = append(args, fileArgs...)
+	}
+	where := strings.Join(conds, " OR ")
+	query := `SELECT path FROM package_files f WHERE f.package = ? AND (` + where + `)
+		UNION SELECT path FROM files f WHERE f.package = ? AND (` + where + `)
+		ORDER BY path`
+
+	all := append([]interface{}{pkg}, args...)
+	all = append(all, pkg)
+	return d.queryStrings(query, append(all, args...)...)
+}
+
+// refreshSearchIndex makes sure the full-text index exists and matches
+// the packages, rebuilding it when a write invalidated it. It fails when
+// SQLite was built without FTS5, or when the index is out of date and the
+// database is read-only, in which case searches fall back to plain
+// pattern matching.
+//
+// The index is rebuilt rather than kept up to date by triggers so that
+// builds without FTS5 can still write to a database that has it.
+func (d *Database) refreshSearchIndex() error {
+	var enabled bool
+	if err := d.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		return fmt.Errorf("SQLite was built without FTS5")
+	}
+
+	var built int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM search_index`).Scan(&built); err != nil {
+		return err
+	}
+	if built > 0 {
+		return nil
+	}
+	if d.readOnly {
+		return fmt.Errorf("the search index is out of date")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS package_search USING fts5(name, description, prefix='2 3')`,
+		`DELETE FROM package_search`,
+		`WITH` + searchCandidates + ` INSERT INTO package_search (name, description) SELECT name, description FROM candidates`,
+		`DELETE FROM search_index`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO search_index (built_at) VALUES (?)`, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// invalidateSearchIndex marks the full-text index as out of date, for
+// writes to the packages or installed tables.
+func invalidateSearchIndex(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM search_index`)
+	return err
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := ParseSearchQuery(`SSH name:open* dep:OpenSSL file:"/opt/My App/bin" installed:no c++:`)
+	if err != nil {
+		t.Fatalf("ParseSearchQuery failed: %v", err)
+	}
+	if fmt.Sprint(q.Terms) != "[ssh c++:]" || fmt.Sprint(q.Names) != "[open*]" || fmt.Sprint(q.Deps) != "[openssl]" {
+		t.Errorf("Unexpected terms or filters %+v", q)
+	}
+	if len(q.Files) != 1 || q.Files[0] != "/opt/My App/bin" {
+		t.Errorf("Expected the quoted path to be kept together, got %q", q.Files)
+	}
+	if q.Installed == nil || *q.Installed {
+		t.Errorf("Expected installed:no, got %v", q.Installed)
+	}
+
+	for _, query := range []string{"installed:maybe", "name:"} {
+		if _, err := ParseSearchQuery(query); err == nil {
+			t.Errorf("Expected %q to be rejected", query)
+		}
+	}
+}
+
+func TestSearchReadOnly(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	lock, err := AcquireLock(filepath.Join(tmpDir, "lock"), SharedLock, 0)
+	if err != nil {
+		t.Fatalf("AcquireLock failed: %v", err)
+	}
+	mgr.SetLock(lock)
+	defer mgr.Close()
+
+	if err := mgr.db.AddPackages([]PackageInfo{{Name: "openssh", Version: "9.6", Description: "Secure shell"}}); err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+
+	// A shared holder searches without rebuilding the full-text index
+	results, err := mgr.db.Search("openssh", false)
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected openssh to be found, got %v, %v", results, err)
+	}
+	var built int
+	if err := mgr.db.db.QueryRow(`SELECT COUNT(*) FROM search_index`).Scan(&built); err != nil || built != 0 {
+		t.Errorf("Expected the search index to be left alone, got %d, %v", built, err)
+	}
+}
+
+func TestSearchFilters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	defer db.Close()
+
+	err = db.AddPackages([]PackageInfo{
+		{Name: "openssh", Version: "9.6", Description: "Secure shell client and server",
+			Dependencies: []string{"openssl>=3.0"}, Files: []string{"/usr/bin/ssh", "/usr/sbin/sshd"}},
+		{Name: "openssl", Version: "3.2", Description: "TLS toolkit", Files: []string{"/usr/bin/openssl"}},
+		{Name: "libssh", Version: "0.10", Description: "Library implementing the SSH protocol"},
+		{Name: "ssh", Version: "1.0", Description: "Metapackage for the default client"},
+		{Name: "curl", Version: "8.5", Description: "Command line transfer tool", Dependencies: []string{"openssl"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	if err := db.RecordInstallation("curl", "8.4", []string{"/usr/bin/curl"}); err != nil {
+		t.Fatalf("RecordInstallation failed: %v", err)
+	}
+
+	search := func(query string, installedOnly bool) []SearchResult {
+		t.Helper()
+		results, err := db.Search(query, installedOnly)
+		if err != nil {
+			t.Fatalf("Search(%q) failed: %v", query, err)
+		}
+		return results
+	}
+	names := func(results []SearchResult) string {
+		var names []string
+		for _, r := range results {
+			names = append(names, r.Name)
+		}
+		sort.Strings(names)
+		return fmt.Sprint(names)
+	}
+
+	// The exact name comes first; names containing the term still match
+	results := search("ssh", false)
+	if len(results) == 0 || results[0].Name != "ssh" {
+		t.Errorf("Expected ssh to rank first, got %v", results)
+	}
+	if got := names(results); got != "[libssh openssh ssh]" {
+		t.Errorf("Search ssh found %s", got)
+	}
+
+	for query, want := range map[string]string{
+		"dep:openssl":              "[curl openssh]",
+		"dep:openssl client":       "[openssh]",
+		"name:open*":               "[openssh openssl]",
+		"desc:library":             "[libssh]",
+		"installed:yes":            "[curl]",
+		"dep:openssl installed:no": "[openssh]",
+		"file:sshd":                "[openssh]",
+		"file:/usr/bin/curl":       "[curl]",
+		"file:/usr/bin/*ssl":       "[openssl]",
+	} {
+		if got := names(search(query, false)); got != want {
+			t.Errorf("Search %q found %s, want %s", query, got, want)
+		}
+	}
+
+	results = search(`file:/usr/bin/ssh`, false)
+	if len(results) != 1 || results[0].Name != "openssh" || fmt.Sprint(results[0].Files) != "[/usr/bin/ssh]" {
+		t.Errorf("Expected openssh to provide /usr/bin/ssh, got %+v", results)
+	}
+
+	results = search("transfer", true)
+	if len(results) != 1 || results[0].Version != "8.4" || !results[0].Installed {
+		t.Errorf("Expected the installed curl version, got %+v", results)
+	}
+
+	// Writes make the next search see the new packages
+	if err := db.AddPackage(&PackageInfo{Name: "dropbear", Version: "2024", Description: "Small SSH server"}); err != nil {
+		t.Fatalf("AddPackage failed: %v", err)
+	}
+	if got := names(search("server", false)); got != "[dropbear openssh]" {
+		t.Errorf("Search server found %s", got)
+	}
+}
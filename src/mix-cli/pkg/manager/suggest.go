@@ -0,0 +1,181 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxSuggestions caps the "did you mean" list of UnknownPackageError.
+	maxSuggestions = 3
+	// staleIndexAge is how old a synced index gets before unknown package
+	// errors suggest refreshing it.
+	staleIndexAge = 7 * 24 * time.Hour
+)
+
+// UnknownPackageError reports a package name that is neither in the
+// package database nor installed.
+type UnknownPackageError struct {
+	Name string
+	// RequiredBy is the package that depends on Name, if any.
+	RequiredBy string
+	// Suggestions are known packages with a similar name or a description
+	// that mentions Name.
+	Suggestions []string
+	// Hint suggests running mix update when the database is empty or stale.
+	Hint string
+}
+
+func (e *UnknownPackageError) Error() string {
+	var b strings.Builder
+	if e.RequiredBy != "" {
+		fmt.Fprintf(&b, "package %s (required by %s) not found", e.Name, e.RequiredBy)
+	} else {
+		fmt.Fprintf(&b, "package %s not found", e.Name)
+	}
+	switch n := len(e.Suggestions); {
+	case n == 1:
+		fmt.Fprintf(&b, "; did you mean %s?", e.Suggestions[0])
+	case n > 1:
+		fmt.Fprintf(&b, "; did you mean %s or %s?", strings.Join(e.Suggestions[:n-1], ", "), e.Suggestions[n-1])
+	}
+	if e.Hint != "" {
+		fmt.Fprintf(&b, " (%s)", e.Hint)
+	}
+	return b.String()
+}
+
+// unknownPackage returns an UnknownPackageError for name with suggestions
+// and a hint filled in.
+func (m *Manager) unknownPackage(name string) error {
+	err := &UnknownPackageError{Name: name}
+	m.explainUnknown(err)
+	return err
+}
+
+// explainUnknown fills in the suggestions and the hint of e. Failures to
+// read the database just leave them out.
+func (m *Manager) explainUnknown(e *UnknownPackageError) {
+	if packages, err := m.db.PackageDescriptions(); err == nil {
+		e.Suggestions = suggestPackages(e.Name, packages)
+	}
+	e.Hint = m.staleIndexHint()
+}
+
+// staleIndexHint returns advice to run mix update when the package
+// database is empty, was never synced, or was last synced a while ago.
+func (m *Manager) staleIndexHint() string {
+	count, err := m.db.CountPackages()
+	if err != nil {
+		return ""
+	}
+	if count == 0 {
+		return "the package database is empty; run 'mix update' to download the package index"
+	}
+
+	synced, err := m.db.LastSyncTime()
+	switch {
+	case err != nil:
+		return ""
+	case synced.IsZero():
+		return "run 'mix update' to refresh the package index"
+	case time.Since(synced) > staleIndexAge:
+		days := int(time.Since(synced).Hours() / 24)
+		return fmt.Sprintf("the package index was last updated %d days ago; run 'mix update' to refresh it", days)
+	}
+	return ""
+}
+
+// suggestPackages returns up to maxSuggestions names from packages (name
+// to description) that are likely what name was meant to be: names within
+// a small edit distance first, then names containing it, then packages
+// whose description mentions it as a word.
+func suggestPackages(name string, packages map[string]string) []string {
+	query := strings.ToLower(name)
+	threshold := len(query) / 3
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	type candidate struct {
+		name  string
+		score int
+	}
+	var candidates []candidate
+	for pkg, description := range packages {
+		lower := strings.ToLower(pkg)
+		if lower == query {
+			continue
+		}
+		score := -1
+		if d := editDistance(query, lower); d <= threshold {
+			score = d
+		} else if len(query) >= 3 && strings.Contains(lower, query) {
+			score = threshold + 1
+		} else if len(query) >= 3 && mentions(description, query) {
+			score = threshold + 2
+		}
+		if score >= 0 {
+			candidates = append(candidates, candidate{pkg, score})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score < candidates[j].score
+		}
+		return candidates[i].name < candidates[j].name
+	})
+	if len(candidates) > maxSuggestions {
+		candidates = candidates[:maxSuggestions]
+	}
+
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.name
+	}
+	return names
+}
+
+// mentions reports whether text contains word as a whole word, ignoring
+// case.
+func mentions(text, word string) bool {
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '+' || r == '_')
+	}) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the optimal string alignment distance between a
+// and b: the Levenshtein distance, with swapping two adjacent characters
+// counted as one edit, the most common typo.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
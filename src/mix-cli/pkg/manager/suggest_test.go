@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSuggestPackages(t *testing.T) {
+	packages := map[string]string{
+		"openssh": "OpenSSH client and server",
+		"openssl": "TLS toolkit",
+		"curl":    "Command line tool for transferring data with URLs",
+		"lynx":    "Text-mode web browser",
+		"vim":     "Vi IMproved text editor",
+	}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"opnessh", "[openssh openssl]"},
+		{"openss", "[openssh openssl]"},
+		{"ssh", "[openssh]"},
+		{"browser", "[lynx]"},
+		{"culr", "[curl]"},
+		{"zzzzzz", "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(suggestPackages(tt.name, packages)); got != tt.want {
+			t.Errorf("suggestPackages(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if d := editDistance("kitten", "sitting"); d != 3 {
+		t.Errorf("editDistance(kitten, sitting) = %d, want 3", d)
+	}
+}
+
+func TestUnknownPackageErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	// An empty database suggests running mix update
+	_, err = mgr.ResolveDependencies([]string{"curl"})
+	var unknown *UnknownPackageError
+	if !errors.As(err, &unknown) || !strings.Contains(unknown.Hint, "empty") {
+		t.Errorf("Expected an empty database hint, got %v", err)
+	}
+
+	_, err = mgr.db.ReplacePackages([]PackageInfo{
+		{Name: "curl", Version: "8.0", Description: "URL transfer tool"},
+		{Name: "app", Version: "1.0", Dependencies: []string{"libcrul"}},
+	}, nil, "http://localhost:8080", SyncState{})
+	if err != nil {
+		t.Fatalf("ReplacePackages failed: %v", err)
+	}
+
+	_, err = mgr.ResolveDependencies([]string{"crul"})
+	if !errors.As(err, &unknown) || fmt.Sprint(unknown.Suggestions) != "[curl]" || unknown.Hint != "" {
+		t.Errorf("Expected curl to be suggested without a hint, got %v", err)
+	}
+	if want := "package crul not found; did you mean curl?"; err.Error() != want {
+		t.Errorf("Unexpected message %q, want %q", err, want)
+	}
+
+	_, err = mgr.ResolveDependencies([]string{"app"})
+	if !errors.As(err, &unknown) || unknown.RequiredBy != "app" || unknown.Name != "libcrul" {
+		t.Errorf("Expected libcrul required by app to be unknown, got %v", err)
+	}
+
+	// A stale index suggests refreshing it
+	if _, err := mgr.db.db.Exec(`UPDATE repo_sync SET synced_at = '2020-01-01T00:00:00Z'`); err != nil {
+		t.Fatalf("Failed to age the sync: %v", err)
+	}
+	err = mgr.Install(context.Background(), "crul")
+	if !errors.As(err, &unknown) || !strings.Contains(unknown.Hint, "days ago") {
+		t.Errorf("Expected a stale index hint, got %v", err)
+	}
+}
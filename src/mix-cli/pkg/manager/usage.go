@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"sort"
+	"syscall"
+)
+
+// PackageUsage is the space an installed package takes on disk.
+type PackageUsage struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Explicit bool   `json:"explicit"`
+	// Size is the space taken by the files of the package itself.
+	Size  int64 `json:"size"`
+	Files int   `json:"files"`
+	// Exclusive is the space freed by removing the package together with
+	// the dependencies no other package would need any more, listed in
+	// Orphans.
+	Exclusive int64    `json:"exclusive"`
+	Orphans   []string `json:"orphans,omitempty"`
+	// Closure is the space taken by the package and all the installed
+	// packages it depends on; what is not Exclusive is shared with other
+	// packages.
+	Closure int64 `json:"closure"`
+}
+
+// UsageNode is a package in a disk usage tree.
+type UsageNode struct {
+	PackageUsage
+	// Shared marks a dependency that stays installed when the root of the
+	// tree is removed.
+	Shared bool `json:"shared,omitempty"`
+	// Repeated marks a package already shown earlier in the tree; its
+	// children are left out.
+	Repeated bool         `json:"repeated,omitempty"`
+	Children []*UsageNode `json:"children,omitempty"`
+}
+
+// DiskUsage is the disk usage of the installed packages.
+type DiskUsage struct {
+	Packages map[string]*PackageUsage
+	// deps maps each installed package to the installed packages it
+	// depends on, dependents the other way round
+	deps       map[string][]string
+	dependents map[string][]string
+}
+
+// DiskUsage measures the files of every installed package. Files are
+// counted by the blocks they occupy, like du; a file with several links
+// is counted once, for the first package owning it, and directories are
+// not counted as they are usually shared.
+func (m *Manager) DiskUsage() (*DiskUsage, error) {
+	installed, err := m.db.ListInstalled()
+	if err != nil {
+		return nil, err
+	}
+	explicit, err := m.db.ExplicitPackages()
+	if err != nil {
+		return nil, err
+	}
+	deps, err := m.db.InstalledDependencies()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &DiskUsage{
+		Packages:   make(map[string]*PackageUsage, len(installed)),
+		deps:       deps,
+		dependents: make(map[string][]string),
+	}
+	for name, pkgDeps := range deps {
+		for _, dep := range pkgDeps {
+			usage.dependents[dep] = append(usage.dependents[dep], name)
+		}
+	}
+
+	type inode struct{ dev, ino uint64 }
+	seen := make(map[inode]bool)
+	for _, pkg := range installed {
+		u := &PackageUsage{Name: pkg.Name, Version: pkg.Version}
+		usage.Packages[pkg.Name] = u
+
+		files, err := m.db.GetInstalledFiles(pkg.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range files {
+			var st syscall.Stat_t
+			if err := syscall.Lstat(m.rootPath(path), &st); err != nil || st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+				continue
+			}
+			u.Files++
+			id := inode{uint64(st.Dev), uint64(st.Ino)}
+			if st.Nlink > 1 {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+			}
+			u.Size += int64(st.Blocks) * 512
+		}
+	}
+	for _, name := range explicit {
+		if u, ok := usage.Packages[name]; ok {
+			u.Explicit = true
+		}
+	}
+
+	for name, u := range usage.Packages {
+		closure := usage.closure(name)
+		for _, dep := range closure {
+			u.Closure += usage.Packages[dep].Size
+		}
+		removed := usage.exclusive(name, closure)
+		for _, dep := range closure {
+			if removed[dep] {
+				u.Exclusive += usage.Packages[dep].Size
+				if dep != name {
+					u.Orphans = append(u.Orphans, dep)
+				}
+			}
+		}
+		sort.Strings(u.Orphans)
+	}
+	return usage, nil
+}
+
+// closure returns name and every installed package it depends on,
+// directly or not.
+func (u *DiskUsage) closure(name string) []string {
+	closure := []string{name}
+	seen := map[string]bool{name: true}
+	for i := 0; i < len(closure); i++ {
+		for _, dep := range u.deps[closure[i]] {
+			if !seen[dep] {
+				seen[dep] = true
+				closure = append(closure, dep)
+			}
+		}
+	}
+	return closure
+}
+
+// exclusive returns the packages of closure, the closure of name, that
+// would be removed with name: dependencies installed as such whose
+// dependents would all be gone.
+func (u *DiskUsage) exclusive(name string, closure []string) map[string]bool {
+	removed := map[string]bool{name: true}
+	for changed := true; changed; {
+		changed = false
+		for _, dep := range closure {
+			if removed[dep] || u.Packages[dep].Explicit {
+				continue
+			}
+			orphaned := true
+			for _, dependent := range u.dependents[dep] {
+				if !removed[dependent] {
+					orphaned = false
+					break
+				}
+			}
+			if orphaned {
+				removed[dep] = true
+				changed = true
+			}
+		}
+	}
+	return removed
+}
+
+// Sorted returns the packages by decreasing size, or with exclusive by
+// decreasing exclusive size.
+func (u *DiskUsage) Sorted(exclusive bool) []*PackageUsage {
+	pkgs := make([]*PackageUsage, 0, len(u.Packages))
+	for _, pkg := range u.Packages {
+		pkgs = append(pkgs, pkg)
+	}
+	key := func(p *PackageUsage) int64 {
+		if exclusive {
+			return p.Exclusive
+		}
+		return p.Size
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		if a, b := key(pkgs[i]), key(pkgs[j]); a != b {
+			return a > b
+		}
+		return pkgs[i].Name < pkgs[j].Name
+	})
+	return pkgs
+}
+
+// Tree returns the installed dependencies of name as a tree, marking
+// those that would not be removed with name as Shared.
+func (u *DiskUsage) Tree(name string) *UsageNode {
+	removed := u.exclusive(name, u.closure(name))
+	expanded := map[string]bool{name: true}
+	var expand func(node *UsageNode)
+	expand = func(node *UsageNode) {
+		for _, dep := range u.deps[node.Name] {
+			child := &UsageNode{PackageUsage: *u.Packages[dep], Shared: !removed[dep]}
+			node.Children = append(node.Children, child)
+			if expanded[dep] {
+				child.Repeated = true
+				continue
+			}
+			expanded[dep] = true
+			expand(child)
+		}
+	}
+	root := &UsageNode{PackageUsage: *u.Packages[name]}
+	expand(root)
+	return root
+}
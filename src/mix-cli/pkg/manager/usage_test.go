@@ -0,0 +1,101 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+	root := filepath.Join(tmpDir, "root")
+	mgr.SetRoot(root)
+
+	err = mgr.db.AddPackages([]PackageInfo{
+		{Name: "app", Version: "2.0", Dependencies: []string{"lib>=1.0", "conf"}},
+		{Name: "lib", Version: "1.1", Dependencies: []string{"base"}},
+		{Name: "base", Version: "1.0"},
+		{Name: "conf", Version: "1.0"},
+		{Name: "tool", Version: "0.3", Dependencies: []string{"base"}},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	sizes := map[string]int{"app": 3, "lib": 5, "base": 7, "conf": 1, "tool": 2}
+	for name, blocks := range sizes {
+		path := "/usr/lib/" + name
+		if err := os.MkdirAll(filepath.Join(root, "usr/lib"), 0755); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(root, path), []byte(strings.Repeat("x", blocks*4096)), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if err := mgr.db.RecordInstallation(name, "1.0", []string{path, path + ".gone"}); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+	for _, name := range []string{"lib", "base"} {
+		if err := mgr.SetExplicit(name, false); err != nil {
+			t.Fatalf("SetExplicit failed: %v", err)
+		}
+	}
+
+	usage, err := mgr.DiskUsage()
+	if err != nil {
+		t.Fatalf("DiskUsage failed: %v", err)
+	}
+	size := func(name string) int64 { return usage.Packages[name].Size }
+	for name, blocks := range sizes {
+		pkg := usage.Packages[name]
+		if pkg.Files != 1 || pkg.Size < int64(blocks*4096) {
+			t.Errorf("Expected %s to have 1 file of at least %d bytes, got %+v", name, blocks*4096, pkg)
+		}
+	}
+
+	// base is still needed by tool and conf was installed explicitly
+	app := usage.Packages["app"]
+	if app.Exclusive != size("app")+size("lib") || fmt.Sprint(app.Orphans) != "[lib]" {
+		t.Errorf("Expected app to free itself and lib, got %+v", app)
+	}
+	if app.Closure != size("app")+size("lib")+size("base")+size("conf") {
+		t.Errorf("Expected the closure of app to cover all its dependencies, got %+v", app)
+	}
+	if lib := usage.Packages["lib"]; lib.Exclusive != size("lib") {
+		t.Errorf("Expected lib to free only itself, got %+v", lib)
+	}
+
+	sorted := usage.Sorted(true)
+	if sorted[0].Name != "app" {
+		t.Errorf("Expected app to have the largest exclusive size, got %s", sorted[0].Name)
+	}
+	if sorted = usage.Sorted(false); sorted[0].Name != "base" {
+		t.Errorf("Expected base to be the largest package, got %s", sorted[0].Name)
+	}
+
+	var render func(n *UsageNode) string
+	render = func(n *UsageNode) string {
+		s := n.Name
+		if n.Shared {
+			s += "*"
+		}
+		for _, child := range n.Children {
+			s += "(" + render(child) + ")"
+		}
+		return s
+	}
+	if got, want := render(usage.Tree("app")), "app(conf*)(lib(base*))"; got != want {
+		t.Errorf("Usage tree %s, want %s", got, want)
+	}
+}
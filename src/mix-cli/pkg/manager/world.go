@@ -0,0 +1,360 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultWorldPath is the world file, which lists the packages the system
+// should have.
+const DefaultWorldPath = "/etc/mix/world"
+
+// WorldEntry is a package in the world file, optionally with a version
+// constraint such as >=3.0.
+type WorldEntry struct {
+	Name string `json:"name"`
+	// Op is one of =, <, <=, > or >=, or empty for any version.
+	Op      string `json:"op,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+func (e WorldEntry) String() string {
+	return e.Name + e.Op + e.Version
+}
+
+// Allows reports whether version satisfies the constraint of e.
+func (e WorldEntry) Allows(version string) bool {
+	c := compareVersions(version, e.Version)
+	switch e.Op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return true
+}
+
+// ParseWorldEntry parses a world file entry: a package name, optionally
+// followed by a version constraint, e.g. "openssl>=3.0".
+func ParseWorldEntry(s string) (WorldEntry, error) {
+	s = strings.TrimSpace(s)
+	e := WorldEntry{Name: s}
+	if i := strings.IndexAny(s, "<>="); i >= 0 {
+		e.Name, e.Op, e.Version = strings.TrimSpace(s[:i]), s[i:i+1], s[i+1:]
+		if strings.HasPrefix(e.Version, "=") && e.Op != "=" {
+			e.Op, e.Version = e.Op+"=", e.Version[1:]
+		}
+		e.Version = strings.TrimSpace(e.Version)
+		if e.Version == "" || strings.ContainsAny(e.Version, "<>= \t") {
+			return WorldEntry{}, fmt.Errorf("invalid version constraint in %q", s)
+		}
+	}
+	if e.Name == "" || strings.ContainsAny(e.Name, " \t/") {
+		return WorldEntry{}, fmt.Errorf("invalid package name in %q", s)
+	}
+	return e, nil
+}
+
+// World is the world file: one package per line, with blank lines and #
+// comments. Lines are kept as written, so editing the world leaves the
+// comments in place.
+type World struct {
+	path  string
+	lines []worldLine
+}
+
+type worldLine struct {
+	text string
+	// entry is nil for blank and comment lines
+	entry *WorldEntry
+}
+
+// LoadWorld reads the world file at path. A missing file is an error
+// wrapping os.ErrNotExist.
+func LoadWorld(path string) (*World, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &World{path: path}
+	seen := make(map[string]int)
+	trimmed := strings.TrimRight(string(data), "\n")
+	if trimmed == "" {
+		return w, nil
+	}
+	for i, text := range strings.Split(trimmed, "\n") {
+		line := worldLine{text: text}
+		if content := strings.TrimSpace(text); content != "" && !strings.HasPrefix(content, "#") {
+			if j := strings.Index(content, "#"); j >= 0 {
+				content = content[:j]
+			}
+			e, err := ParseWorldEntry(content)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+			if prev, ok := seen[e.Name]; ok {
+				return nil, fmt.Errorf("%s:%d: %s is already listed on line %d", path, i+1, e.Name, prev)
+			}
+			seen[e.Name] = i + 1
+			line.entry = &e
+		}
+		w.lines = append(w.lines, line)
+	}
+	return w, nil
+}
+
+// LoadWorld reads the world file at path. Without one, the world is made
+// up of the packages that were installed explicitly, so that the first
+// install or remove writes out the current state.
+func (m *Manager) LoadWorld(path string) (*World, error) {
+	w, err := LoadWorld(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return w, err
+	}
+
+	explicit, err := m.db.ExplicitPackages()
+	if err != nil {
+		return nil, err
+	}
+	w = &World{path: path}
+	for _, name := range explicit {
+		w.Add(name)
+	}
+	return w, nil
+}
+
+// Path returns where the world file is saved.
+func (w *World) Path() string {
+	return w.path
+}
+
+// Entries returns the packages of the world in file order.
+func (w *World) Entries() []WorldEntry {
+	var entries []WorldEntry
+	for _, line := range w.lines {
+		if line.entry != nil {
+			entries = append(entries, *line.entry)
+		}
+	}
+	return entries
+}
+
+// Contains reports whether name is listed.
+func (w *World) Contains(name string) bool {
+	for _, line := range w.lines {
+		if line.entry != nil && line.entry.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Add lists name without a version constraint, unless it is listed
+// already. It reports whether the world changed.
+func (w *World) Add(name string) bool {
+	if w.Contains(name) {
+		return false
+	}
+	w.lines = append(w.lines, worldLine{text: name, entry: &WorldEntry{Name: name}})
+	return true
+}
+
+// Remove drops name from the world and reports whether it was listed.
+func (w *World) Remove(name string) bool {
+	for i, line := range w.lines {
+		if line.entry != nil && line.entry.Name == name {
+			w.lines = append(w.lines[:i], w.lines[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes the world file, replacing the old one atomically.
+func (w *World) Save() error {
+	var b strings.Builder
+	for _, line := range w.lines {
+		b.WriteString(line.text + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return err
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// SyncPlan is what it takes to bring the installed packages in line with
+// the world.
+type SyncPlan struct {
+	// Install lists the missing packages and their dependencies, in
+	// installation order.
+	Install []string `json:"install"`
+	// Upgrade lists installed packages whose version the world does not
+	// allow; NewVersion may be older than CurrentVersion.
+	Upgrade []PackageUpgrade `json:"upgrade"`
+	// Remove lists the packages neither listed nor needed by a listed
+	// package, dependents first.
+	Remove []string `json:"remove"`
+}
+
+// Empty reports whether the system already matches the world.
+func (p *SyncPlan) Empty() bool {
+	return len(p.Install) == 0 && len(p.Upgrade) == 0 && len(p.Remove) == 0
+}
+
+// PlanSync works out the installs, upgrades and removals that make the
+// installed packages match w. Only constraints force a version change;
+// mix upgrade still moves packages to the latest version.
+func (m *Manager) PlanSync(w *World) (*SyncPlan, error) {
+	plan := &SyncPlan{Install: []string{}, Upgrade: []PackageUpgrade{}, Remove: []string{}}
+	entries := w.Entries()
+
+	var missing []string
+	for _, e := range entries {
+		installed, err := m.db.GetInstalledPackage(e.Name)
+		if err == nil && e.Allows(installed.Version) {
+			continue
+		}
+		available, aerr := m.db.GetPackage(e.Name)
+		if aerr != nil {
+			return nil, m.unknownPackage(e.Name)
+		}
+		if !e.Allows(available.Version) {
+			return nil, fmt.Errorf("%s: the repository has version %s, which does not satisfy %s", e.Name, available.Version, e)
+		}
+		if err != nil {
+			missing = append(missing, e.Name)
+		} else {
+			plan.Upgrade = append(plan.Upgrade, PackageUpgrade{
+				Name:           e.Name,
+				CurrentVersion: installed.Version,
+				NewVersion:     available.Version,
+			})
+		}
+	}
+	sort.Slice(plan.Upgrade, func(i, j int) bool { return plan.Upgrade[i].Name < plan.Upgrade[j].Name })
+
+	if len(missing) > 0 {
+		order, err := m.ResolveDependencies(missing)
+		if err != nil {
+			return nil, err
+		}
+		plan.Install = append(plan.Install, order...)
+	}
+
+	// Everything the world needs, installed or about to be
+	resolver := m.newResolver()
+	needed := make(map[string]bool)
+	var queue []string
+	for _, e := range entries {
+		queue = append(queue, e.Name)
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if needed[name] {
+			continue
+		}
+		needed[name] = true
+		deps, err := m.dependencyNames(resolver, name)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, deps...)
+	}
+
+	installed, err := m.db.ListInstalled()
+	if err != nil {
+		return nil, err
+	}
+	remove := make(map[string]bool)
+	for _, pkg := range installed {
+		if !needed[pkg.Name] {
+			remove[pkg.Name] = true
+		}
+	}
+	plan.Remove, err = m.removeOrder(remove)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// removeOrder orders the packages of remove so that each comes before the
+// packages it depends on. It empties remove.
+func (m *Manager) removeOrder(remove map[string]bool) ([]string, error) {
+	deps, err := m.db.InstalledDependencies()
+	if err != nil {
+		return nil, err
+	}
+	dependents := make(map[string]int)
+	for name := range remove {
+		for _, dep := range deps[name] {
+			if remove[dep] {
+				dependents[dep]++
+			}
+		}
+	}
+
+	order := []string{}
+	left := len(remove)
+	for left > 0 {
+		// Take every package nothing left depends on; in a cycle, take
+		// them all
+		var next []string
+		for name := range remove {
+			if dependents[name] == 0 {
+				next = append(next, name)
+			}
+		}
+		if len(next) == 0 {
+			for name := range remove {
+				next = append(next, name)
+			}
+		}
+		sort.Strings(next)
+		for _, name := range next {
+			delete(remove, name)
+			for _, dep := range deps[name] {
+				dependents[dep]--
+			}
+		}
+		order = append(order, next...)
+		left -= len(next)
+	}
+	return order, nil
+}
+
+// MarkWorld records the installed packages listed in w as explicitly
+// installed and all others as dependencies.
+func (m *Manager) MarkWorld(w *World) error {
+	installed, err := m.db.ListInstalled()
+	if err != nil {
+		return err
+	}
+	for _, pkg := range installed {
+		if err := m.db.SetExplicit(pkg.Name, w.Contains(pkg.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseWorldEntry(t *testing.T) {
+	tests := []struct {
+		input string
+		want  WorldEntry
+	}{
+		{"openssh", WorldEntry{Name: "openssh"}},
+		{" openssl >= 3.0 ", WorldEntry{Name: "openssl", Op: ">=", Version: "3.0"}},
+		{"zlib=1.3", WorldEntry{Name: "zlib", Op: "=", Version: "1.3"}},
+		{"busybox<2", WorldEntry{Name: "busybox", Op: "<", Version: "2"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseWorldEntry(tt.input)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseWorldEntry(%q) = %+v, %v, want %+v", tt.input, got, err, tt.want)
+		}
+	}
+	for _, input := range []string{"", ">=1.0", "zlib>=", "zlib==1", "two words", "a/b"} {
+		if _, err := ParseWorldEntry(input); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+
+	e := WorldEntry{Name: "openssl", Op: ">=", Version: "3.0"}
+	if !e.Allows("3.2") || e.Allows("1.1.1") || !(WorldEntry{Name: "x"}).Allows("0.1") {
+		t.Error("Unexpected result of Allows")
+	}
+}
+
+func TestWorldFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "etc", "world")
+	if _, err := LoadWorld(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected ErrNotExist for a missing world file, got %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	content := "# servers\nopenssh\n\nopenssl>=3.0  # for TLS 1.3\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write world file: %v", err)
+	}
+
+	world, err := LoadWorld(path)
+	if err != nil {
+		t.Fatalf("LoadWorld failed: %v", err)
+	}
+	if fmt.Sprint(world.Entries()) != "[openssh openssl>=3.0]" {
+		t.Errorf("Unexpected entries %v", world.Entries())
+	}
+	if world.Add("openssh") || !world.Add("curl") || !world.Remove("openssh") || world.Remove("nope") {
+		t.Error("Unexpected result of Add or Remove")
+	}
+	if err := world.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read world file: %v", err)
+	}
+	if want := "# servers\n\nopenssl>=3.0  # for TLS 1.3\ncurl\n"; string(data) != want {
+		t.Errorf("Saved world file %q, want %q", data, want)
+	}
+
+	if err := os.WriteFile(path, []byte("curl\nzlib\ncurl>=8\n"), 0644); err != nil {
+		t.Fatalf("Failed to write world file: %v", err)
+	}
+	if _, err := LoadWorld(path); err == nil || !strings.Contains(err.Error(), ":3: curl is already listed on line 1") {
+		t.Errorf("Expected an error for a duplicate entry, got %v", err)
+	}
+}
+
+func TestPlanSync(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "mix-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	mgr, err := New(filepath.Join(tmpDir, "test.db"), "http://localhost:8080", filepath.Join(tmpDir, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	defer mgr.Close()
+
+	err = mgr.db.AddPackages([]PackageInfo{
+		{Name: "app", Version: "2.0", Dependencies: []string{"lib"}},
+		{Name: "lib", Version: "1.1", Dependencies: []string{"base"}},
+		{Name: "base", Version: "1.0"},
+		{Name: "old", Version: "1.0", Dependencies: []string{"olddep"}},
+		{Name: "olddep", Version: "1.0", Dependencies: []string{"base"}},
+		{Name: "ssl", Version: "3.2"},
+	})
+	if err != nil {
+		t.Fatalf("AddPackages failed: %v", err)
+	}
+	installed := map[string]string{"base": "1.0", "old": "1.0", "olddep": "1.0", "ssl": "1.1"}
+	for name, version := range installed {
+		if err := mgr.db.RecordInstallation(name, version, nil); err != nil {
+			t.Fatalf("RecordInstallation failed: %v", err)
+		}
+	}
+
+	// Without a world file, the explicit packages make up the world
+	path := filepath.Join(tmpDir, "world")
+	world, err := mgr.LoadWorld(path)
+	if err != nil {
+		t.Fatalf("LoadWorld failed: %v", err)
+	}
+	if fmt.Sprint(world.Entries()) != "[base old olddep ssl]" {
+		t.Errorf("Expected the explicit packages, got %v", world.Entries())
+	}
+
+	if err := os.WriteFile(path, []byte("app\nssl>=3.0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write world file: %v", err)
+	}
+	if world, err = mgr.LoadWorld(path); err != nil {
+		t.Fatalf("LoadWorld failed: %v", err)
+	}
+	plan, err := mgr.PlanSync(world)
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	if fmt.Sprint(plan.Install) != "[lib app]" {
+		t.Errorf("Expected app and lib to be installed in order, got %v", plan.Install)
+	}
+	if fmt.Sprint(plan.Upgrade) != "[{ssl 1.1 3.2}]" {
+		t.Errorf("Expected ssl to be upgraded to satisfy its constraint, got %v", plan.Upgrade)
+	}
+	if fmt.Sprint(plan.Remove) != "[old olddep]" {
+		t.Errorf("Expected old to be removed before olddep, and base kept for lib, got %v", plan.Remove)
+	}
+
+	if err := mgr.MarkWorld(world); err != nil {
+		t.Fatalf("MarkWorld failed: %v", err)
+	}
+	if explicit, _ := mgr.db.ExplicitPackages(); fmt.Sprint(explicit) != "[ssl]" {
+		t.Errorf("Expected only the installed world packages to be explicit, got %v", explicit)
+	}
+
+	for _, content := range []string{"app\nssl>=4\n", "app\nghost\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write world file: %v", err)
+		}
+		if world, err = mgr.LoadWorld(path); err != nil {
+			t.Fatalf("LoadWorld failed: %v", err)
+		}
+		if _, err := mgr.PlanSync(world); err == nil {
+			t.Errorf("Expected PlanSync to fail for %q", content)
+		}
+	}
+}